@@ -12,6 +12,12 @@ import (
 var force bool
 var keep bool
 var list bool
+var worktrees bool
+var unsafeGit bool
+var refWordlist string
+var refNames string
+var refVersions string
+var lfsHosts []string
 var rootCmd = &cobra.Command{
 	Use:   "goop",
 	Short: "goop is a very fast tool to grab sources from exposed .git folders",
@@ -21,13 +27,21 @@ var rootCmd = &cobra.Command{
 		if len(args) >= 2 {
 			dir = args[1]
 		}
+		refOpts := goop.RefNameOptions{Wordlist: refWordlist, Builtin: refNames != "none", MaxVersion: refVersions}
+		if refNames != "builtin" && refNames != "none" {
+			log.Error().Str("ref-names", refNames).Msg("expected builtin or none")
+			os.Exit(1)
+		}
+		if refVersions == "none" {
+			refOpts.MaxVersion = ""
+		}
 		if list {
-			if err := goop.CloneList(args[0], dir, force, keep); err != nil {
+			if err := goop.CloneList(args[0], dir, force, keep, worktrees, unsafeGit, refOpts, lfsHosts); err != nil {
 				log.Error().Err(err).Msg("exiting")
 				os.Exit(1)
 			}
 		} else {
-			if err := goop.Clone(args[0], dir, force, keep); err != nil {
+			if err := goop.Clone(args[0], dir, force, keep, worktrees, unsafeGit, refOpts, lfsHosts); err != nil {
 				log.Error().Err(err).Msg("exiting")
 				os.Exit(1)
 			}
@@ -39,6 +53,12 @@ func init() {
 	rootCmd.PersistentFlags().BoolVarP(&force, "force", "f", false, "overrides DIR if it already exists")
 	rootCmd.PersistentFlags().BoolVarP(&keep, "keep", "k", false, "keeps already downloaded files in DIR, useful if you keep being ratelimited by server")
 	rootCmd.PersistentFlags().BoolVarP(&list, "list", "l", false, "allows you to supply the name of a file containing a list of domain names instead of just one domain")
+	rootCmd.PersistentFlags().BoolVarP(&worktrees, "worktrees", "w", false, "checks out linked worktrees found on the server into DIR/.goop/worktrees/NAME")
+	rootCmd.PersistentFlags().BoolVar(&unsafeGit, "unsafe-git", false, "keeps the dumped git config and hooks as they are, instead of removing what would run commands when using git on the dump")
+	rootCmd.PersistentFlags().StringVar(&refWordlist, "ref-wordlist", "", "file with additional branch and tag names to look for, one per line")
+	rootCmd.PersistentFlags().StringVar(&refNames, "ref-names", "none", "whether to also look for several hundred built-in common branch and tag names, builtin or none")
+	rootCmd.PersistentFlags().StringSliceVar(&lfsHosts, "lfs-hosts", nil, "hosts besides the target's that the lfs server named in the dumped config may be contacted on, or * for any")
+	rootCmd.PersistentFlags().StringVar(&refVersions, "ref-versions", goop.DefaultRefNameOptions.MaxVersion, "highest version, as MAJOR.MINOR.PATCH, to look for as tags and release and hotfix branches, or none")
 }
 
 func Execute() {
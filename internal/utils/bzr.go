@@ -0,0 +1,245 @@
+package utils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const btreePageSize = 4096
+
+var btreeSignature = []byte("B+Tree Graph Index 2\n")
+
+// BTreeEntry is an entry of a Bazaar B+Tree graph index.
+type BTreeEntry struct {
+	Key   []string
+	Value string
+}
+
+// DecodeBTreeIndex returns every entry of a Bazaar B+Tree graph index, such as
+// pack-names or the indices of packs.
+func DecodeBTreeIndex(data []byte) ([]BTreeEntry, error) {
+	if !bytes.HasPrefix(data, btreeSignature) {
+		return nil, errors.New("not a B+Tree graph index")
+	}
+	header := make(map[string]string)
+	pos := len(btreeSignature)
+	for len(header) < 4 {
+		nl := bytes.IndexByte(data[pos:], '\n')
+		if nl < 0 {
+			return nil, errors.New("index header is truncated")
+		}
+		key, value, _ := strings.Cut(string(data[pos:pos+nl]), "=")
+		header[key] = value
+		pos += nl + 1
+	}
+	keyElements, err := strconv.Atoi(header["key_elements"])
+	if err != nil || keyElements < 1 {
+		return nil, errors.New("index header is malformed")
+	}
+	if header["row_lengths"] == "" {
+		return nil, nil
+	}
+	var rows []int
+	for _, n := range strings.Split(header["row_lengths"], ",") {
+		count, err := strconv.Atoi(n)
+		if err != nil || count < 0 {
+			return nil, errors.New("index header is malformed")
+		}
+		rows = append(rows, count)
+	}
+
+	// only the last row holds leaves, the ones above only point to them
+	first := 0
+	for _, count := range rows[:len(rows)-1] {
+		first += count
+	}
+	var entries []BTreeEntry
+	for page := first; page < first+rows[len(rows)-1]; page++ {
+		start := page * btreePageSize
+		if page == 0 {
+			start = pos
+		}
+		if start >= len(data) {
+			return entries, errors.New("index is truncated")
+		}
+		r, err := zlib.NewReader(bytes.NewReader(data[start:]))
+		if err != nil {
+			return entries, err
+		}
+		node, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return entries, err
+		}
+		lines := strings.Split(string(node), "\n")
+		if lines[0] != "type=leaf" {
+			return entries, fmt.Errorf("page %d isn't a leaf", page)
+		}
+		for _, line := range lines[1:] {
+			if line == "" {
+				break
+			}
+			// key elements, then the references and the value
+			fields := strings.SplitN(line, "\x00", keyElements+1)
+			if len(fields) <= keyElements {
+				return entries, errors.New("malformed index entry")
+			}
+			i := strings.LastIndexByte(fields[keyElements], 0)
+			entries = append(entries, BTreeEntry{Key: fields[:keyElements], Value: fields[keyElements][i+1:]})
+		}
+	}
+	return entries, nil
+}
+
+// BzrTreeData is the state of an entry of the dirstate in one of its trees.
+// Kind is "f" for files, "d" for directories, "l" for symlinks, "a" for
+// absent entries, "r" for relocated ones and "t" for tree references.
+type BzrTreeData struct {
+	Kind string
+	// Fingerprint is the sha1 of files, or the target of symlinks
+	Fingerprint string
+	Size        int64
+	Executable  bool
+	// Revision is the revision the entry was last changed in, for parent trees
+	Revision string
+}
+
+// BzrDirstateEntry is an entry of the dirstate, with its state in the
+// working tree, followed by its state in every parent tree.
+type BzrDirstateEntry struct {
+	Path   string
+	FileID string
+	Trees  []BzrTreeData
+}
+
+// BzrDirstate is the dirstate of a Bazaar checkout.
+type BzrDirstate struct {
+	Parents []string
+	Entries []BzrDirstateEntry
+}
+
+var bzrDirstateSignature = []byte("#bazaar dirstate flat format 3\n")
+
+func ParseBzrDirstate(data []byte) (*BzrDirstate, error) {
+	if !bytes.HasPrefix(data, bzrDirstateSignature) {
+		return nil, errors.New("unsupported dirstate format")
+	}
+	data = data[len(bzrDirstateSignature):]
+	// crc32 and num_entries
+	for i := 0; i < 2; i++ {
+		nl := bytes.IndexByte(data, '\n')
+		if nl < 0 {
+			return nil, errors.New("dirstate header is truncated")
+		}
+		data = data[nl+1:]
+	}
+
+	lines := strings.Split(string(data), "\x00\n\x00")
+	if len(lines) < 2 {
+		return nil, errors.New("dirstate is truncated")
+	}
+	parents := strings.Split(lines[0], "\x00")
+	if n, err := strconv.Atoi(parents[0]); err != nil || n != len(parents)-1 {
+		return nil, errors.New("dirstate parents are malformed")
+	}
+	ds := &BzrDirstate{Parents: parents[1:]}
+	trees := len(ds.Parents) + 1
+
+	for _, line := range lines[2:] {
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, "\x00")
+		if len(fields) != 3+5*trees {
+			return ds, errors.New("dirstate entry is malformed")
+		}
+		entry := BzrDirstateEntry{Path: fields[1], FileID: fields[2]}
+		if fields[0] != "" {
+			entry.Path = fields[0] + "/" + fields[1]
+		}
+		for t := 0; t < trees; t++ {
+			f := fields[3+5*t : 3+5*(t+1)]
+			size, _ := strconv.ParseInt(f[2], 10, 64)
+			data := BzrTreeData{Kind: f[0], Fingerprint: f[1], Size: size, Executable: f[3] == "y"}
+			// the working tree keeps packed stat data instead of a revision
+			if t > 0 {
+				data.Revision = f[4]
+			}
+			entry.Trees = append(entry.Trees, data)
+		}
+		ds.Entries = append(ds.Entries, entry)
+	}
+	return ds, nil
+}
+
+// ExtractBzrText extracts a text from a pack, given the value of its entry in
+// the text index: the offset and length of the groupcompress block in the
+// pack, and the start and end of the record in the block.
+func ExtractBzrText(pack []byte, value string) ([]byte, error) {
+	var blockStart, blockLen, start, end int
+	if _, err := fmt.Sscanf(value, "%d %d %d %d", &blockStart, &blockLen, &start, &end); err != nil {
+		return nil, fmt.Errorf("malformed index value %q", value)
+	}
+	if blockStart < 0 || blockLen < 0 || blockStart+blockLen > len(pack) {
+		return nil, errors.New("block is out of bounds")
+	}
+	content, err := decodeGroupCompressBlock(pack[blockStart : blockStart+blockLen])
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 || end > len(content) || start+1 >= end {
+		return nil, errors.New("record is out of bounds")
+	}
+
+	kind := content[start]
+	size, n := decodeDeltaSize(content[start+1 : end])
+	if n == 0 || start+1+n+size != end {
+		return nil, errors.New("record is malformed")
+	}
+	record := content[start+1+n : end]
+	switch kind {
+	case 'f':
+		return record, nil
+	case 'd':
+		// deltas copy from everything that came before them in the block, and
+		// don't start with the size of their source like git ones
+		return patchDelta(content, record)
+	}
+	return nil, fmt.Errorf("unknown record kind %q", kind)
+}
+
+func decodeGroupCompressBlock(block []byte) ([]byte, error) {
+	if !bytes.HasPrefix(block, []byte("gcb1z\n")) {
+		return nil, errors.New("unsupported groupcompress block")
+	}
+	fields := bytes.SplitN(block[6:], []byte("\n"), 3)
+	if len(fields) != 3 {
+		return nil, errors.New("groupcompress block is truncated")
+	}
+	zLen, err := strconv.Atoi(string(fields[0]))
+	if err != nil || zLen > len(fields[2]) {
+		return nil, errors.New("groupcompress block is malformed")
+	}
+	size, err := strconv.Atoi(string(fields[1]))
+	if err != nil {
+		return nil, errors.New("groupcompress block is malformed")
+	}
+	r, err := zlib.NewReader(bytes.NewReader(fields[2][:zLen]))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) != size {
+		return nil, errors.New("groupcompress block has the wrong size")
+	}
+	return content, nil
+}
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBzrDirstate(t *testing.T) {
+	// a checkout with one parent, where added.txt was added, removed.txt
+	// removed and old.txt renamed to new.txt
+	data, err := os.ReadFile("testdata/dirstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := ParseBzrDirstate(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev := "alice@example.com-20240101000000-abcdefghijklmnop"
+	if !reflect.DeepEqual(ds.Parents, []string{rev}) {
+		t.Errorf("parents = %v", ds.Parents)
+	}
+
+	sha := func(c string) string { return strings.Repeat(c, 40) }
+	tree := func(kind, fingerprint string, size int64, exec bool, revision string) BzrTreeData {
+		return BzrTreeData{Kind: kind, Fingerprint: fingerprint, Size: size, Executable: exec, Revision: revision}
+	}
+	want := []BzrDirstateEntry{
+		{"", "TREE_ROOT", []BzrTreeData{tree("d", "", 0, false, ""), tree("d", "", 0, false, rev)}},
+		{"README", "readme-id", []BzrTreeData{tree("f", sha("1"), 6, false, ""), tree("f", sha("1"), 6, false, rev)}},
+		{"added.txt", "added-id", []BzrTreeData{tree("f", "", 0, false, ""), tree("a", "", 0, false, "")}},
+		{"link", "link-id", []BzrTreeData{tree("l", "README", 0, false, ""), tree("l", "README", 0, false, rev)}},
+		{"new.txt", "moved-id", []BzrTreeData{tree("f", sha("2"), 4, false, ""), tree("r", "old.txt", 0, false, "")}},
+		{"old.txt", "moved-id", []BzrTreeData{tree("r", "new.txt", 0, false, ""), tree("f", sha("2"), 4, false, rev)}},
+		{"removed.txt", "removed-id", []BzrTreeData{tree("a", "", 0, false, ""), tree("f", sha("3"), 9, false, rev)}},
+		{"src", "src-id", []BzrTreeData{tree("d", "", 0, false, ""), tree("d", "", 0, false, rev)}},
+		{"src/run.sh", "run-id", []BzrTreeData{tree("f", sha("4"), 12, true, ""), tree("f", sha("4"), 12, true, rev)}},
+	}
+	if len(ds.Entries) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(ds.Entries), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(ds.Entries[i], want[i]) {
+			t.Errorf("entry %d = %+v, want %+v", i, ds.Entries[i], want[i])
+		}
+	}
+}
+
+func TestParseBzrDirstateMalformed(t *testing.T) {
+	data, err := os.ReadFile("testdata/dirstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := len(bzrDirstateSignature)
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"format 2", []byte("#bazaar dirstate flat format 2\ncrc32: 0\nnum_entries: 0\n"), "unsupported dirstate format"},
+		{"header", data[:header+10], "header is truncated"},
+		{"no entries", data[:bytes.Index(data, []byte("\x00\n\x00"))], "dirstate is truncated"},
+		{"parents", bytes.Replace(data, []byte("\n1\x00alice"), []byte("\n2\x00alice"), 1), "parents are malformed"},
+		{"entry", bytes.Replace(data, []byte("\x00readme-id\x00"), []byte("\x00readme-id\x00\x00"), 1), "entry is malformed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseBzrDirstate(tt.data); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("ParseBzrDirstate() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
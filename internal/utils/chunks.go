@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ReadChunks splits a file using git's chunk-based format (commit-graph,
+// multi-pack-index) into its chunks, keyed by chunk id. The table of contents
+// starts right after the header of headerLen bytes.
+func ReadChunks(data []byte, headerLen, numChunks int) (map[string][]byte, error) {
+	tocEnd := headerLen + (numChunks+1)*12
+	if len(data) < tocEnd {
+		return nil, errors.New("chunk table of contents is truncated")
+	}
+	chunks := make(map[string][]byte, numChunks)
+	for i := 0; i < numChunks; i++ {
+		entry := data[headerLen+i*12:]
+		id := string(entry[:4])
+		start := binary.BigEndian.Uint64(entry[4:12])
+		end := binary.BigEndian.Uint64(entry[16:24])
+		if start > end || end > uint64(len(data)) {
+			return nil, fmt.Errorf("chunk %q is out of bounds", id)
+		}
+		chunks[id] = data[start:end]
+	}
+	return chunks, nil
+}
+
+// ReadHashes splits a chunk of consecutive raw object names into hex strings.
+func ReadHashes(chunk []byte, format ObjectFormat) []string {
+	hashes := make([]string, 0, len(chunk)/format.Size)
+	for i := 0; i+format.Size <= len(chunk); i += format.Size {
+		hashes = append(hashes, fmt.Sprintf("%x", chunk[i:i+format.Size]))
+	}
+	return hashes
+}
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+type chunk struct {
+	id   string
+	data []byte
+}
+
+// chunkFile lays out header and chunks the way git's chunk-based formats do.
+func chunkFile(header []byte, chunks ...chunk) []byte {
+	data := append([]byte{}, header...)
+	off := uint64(len(header) + (len(chunks)+1)*12)
+	for _, c := range chunks {
+		data = append(data, c.id...)
+		data = binary.BigEndian.AppendUint64(data, off)
+		off += uint64(len(c.data))
+	}
+	data = append(data, 0, 0, 0, 0)
+	data = binary.BigEndian.AppendUint64(data, off)
+	for _, c := range chunks {
+		data = append(data, c.data...)
+	}
+	return data
+}
+
+func TestReadChunks(t *testing.T) {
+	valid := chunkFile([]byte("HEAD"), chunk{"AAAA", []byte("aaaa")}, chunk{"BBBB", []byte("bb")})
+
+	// the end of the last chunk pointing past the file
+	pastEnd := append([]byte{}, valid...)
+	binary.BigEndian.PutUint64(pastEnd[4+2*12+4:], 1<<40)
+	// a chunk ending before it starts
+	backwards := append([]byte{}, valid...)
+	binary.BigEndian.PutUint64(backwards[4+4:], 1<<40)
+
+	tests := []struct {
+		name      string
+		data      []byte
+		numChunks int
+		wantErr   string
+	}{
+		{"valid", valid, 2, ""},
+		{"truncated table of contents", valid[:20], 2, "truncated"},
+		{"more chunks than the file has", valid, 200, "truncated"},
+		{"chunk past the end", pastEnd, 2, "out of bounds"},
+		{"chunk ending before it starts", backwards, 2, "out of bounds"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks, err := ReadChunks(tt.data, 4, tt.numChunks)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ReadChunks() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(chunks["AAAA"]) != "aaaa" || string(chunks["BBBB"]) != "bb" {
+				t.Fatalf("ReadChunks() = %q", chunks)
+			}
+		})
+	}
+}
+
+func TestReadHashes(t *testing.T) {
+	// a trailing partial hash is ignored
+	hashes := ReadHashes(append(make([]byte, 20), 1, 2, 3), SHA1)
+	if len(hashes) != 1 || hashes[0] != strings.Repeat("0", 40) {
+		t.Fatalf("ReadHashes() = %v", hashes)
+	}
+}
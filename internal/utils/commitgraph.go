@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// CommitGraph is a decoded commit-graph file.
+type CommitGraph struct {
+	// Commits are the commits the graph lists
+	Commits []string
+	// Trees are the root trees of those commits
+	Trees []string
+}
+
+// ReadCommitGraph reads and decodes the commit-graph file at path.
+func ReadCommitGraph(path string, format ObjectFormat) (*CommitGraph, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeCommitGraph(data, format)
+}
+
+// DecodeCommitGraph decodes a commit-graph file, only keeping the object names
+// it lists.
+func DecodeCommitGraph(data []byte, format ObjectFormat) (*CommitGraph, error) {
+	// header: signature, version, hash version, number of chunks, number of
+	// base graphs
+	if len(data) < 8 || string(data[:4]) != "CGPH" {
+		return nil, errors.New("malformed commit graph signature")
+	}
+	if v := data[4]; v != 1 {
+		return nil, fmt.Errorf("unsupported commit graph version %d", v)
+	}
+	chunks, err := ReadChunks(data, 8, int(data[6]))
+	if err != nil {
+		return nil, err
+	}
+
+	graph := &CommitGraph{Commits: ReadHashes(chunks["OIDL"], format)}
+	// commit data entries start with the tree hash, followed by 16 bytes of
+	// parent positions, generation number and commit time
+	cdat := chunks["CDAT"]
+	stride := format.Size + 16
+	for i := 0; i+stride <= len(cdat); i += stride {
+		graph.Trees = append(graph.Trees, hex.EncodeToString(cdat[i:i+format.Size]))
+	}
+	return graph, nil
+}
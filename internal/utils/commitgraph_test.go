@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDecodeCommitGraph(t *testing.T) {
+	commit := bytes.Repeat([]byte{0x11}, 20)
+	tree := bytes.Repeat([]byte{0x22}, 20)
+	header := func(version, chunks byte) []byte {
+		return []byte{'C', 'G', 'P', 'H', version, 1, chunks, 0}
+	}
+	oidl := chunk{"OIDL", commit}
+	cdat := chunk{"CDAT", append(append([]byte{}, tree...), make([]byte, 16)...)}
+
+	tests := []struct {
+		name      string
+		data      []byte
+		wantTrees int
+		wantErr   string
+	}{
+		{"valid", chunkFile(header(1, 2), oidl, cdat), 1, ""},
+		{"without commit data", chunkFile(header(1, 1), oidl), 0, ""},
+		{"partial commit data", chunkFile(header(1, 2), oidl, chunk{"CDAT", tree}), 0, ""},
+		{"empty", nil, 0, "signature"},
+		{"bad signature", chunkFile(append([]byte("HPGC"), header(1, 2)[4:]...), oidl, cdat), 0, "signature"},
+		{"version 2", chunkFile(header(2, 2), oidl, cdat), 0, "version"},
+		{"truncated chunks", chunkFile(header(1, 2), oidl, cdat)[:20], 0, "truncated"},
+		{"more chunks than the file has", chunkFile(header(1, 255), oidl, cdat), 0, "truncated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			graph, err := DecodeCommitGraph(tt.data, SHA1)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("DecodeCommitGraph() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(graph.Commits) != 1 || graph.Commits[0] != strings.Repeat("11", 20) {
+				t.Fatalf("Commits = %v", graph.Commits)
+			}
+			if len(graph.Trees) != tt.wantTrees || (tt.wantTrees > 0 && graph.Trees[0] != strings.Repeat("22", 20)) {
+				t.Fatalf("Trees = %v", graph.Trees)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// ErrUnsafePath is returned for server-derived paths that would be written
+// outside of the directory they are confined to.
+var ErrUnsafePath = errors.New("unsafe path")
+
+// ConfinedPath returns where file goes inside of dir. Paths that are absolute,
+// go up with .., hold backslashes or NUL bytes, or pass through a symlink that
+// is already in dir are refused. Unless allowGit is set, so are paths going
+// through a .git directory, which would shadow the git metadata of the dump.
+func ConfinedPath(dir, file string, allowGit bool) (string, error) {
+	if file == "" || path.IsAbs(file) || strings.ContainsAny(file, "\\\x00") {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, file)
+	}
+	parts := strings.Split(strings.TrimSuffix(file, "/"), "/")
+	current := dir
+	for i, part := range parts {
+		switch {
+		case part == "..":
+			return "", fmt.Errorf("%w: %q leaves the directory", ErrUnsafePath, file)
+		case !allowGit && isGitDirName(part):
+			return "", fmt.Errorf("%w: %q is inside of a git directory", ErrUnsafePath, file)
+		case part == "" || part == ".":
+			continue
+		}
+		current = URL(current, part)
+		if i == len(parts)-1 {
+			break
+		}
+		if info, err := os.Lstat(current); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("%w: %q goes through a symlink", ErrUnsafePath, file)
+		}
+	}
+	return URL(dir, file), nil
+}
+
+// WriteConfinedFile writes a server-derived file inside of dir, refusing the
+// paths ConfinedPath refuses. A symlink already at the path is replaced
+// rather than written through.
+func WriteConfinedFile(dir, file string, content []byte, perm os.FileMode, allowGit bool) error {
+	target, err := ConfinedPath(dir, file, allowGit)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+	}
+	if err := CreateParentFolders(target); err != nil {
+		return err
+	}
+	return os.WriteFile(target, content, perm)
+}
+
+// MkdirConfined creates a server-derived directory inside of dir, refusing
+// the paths ConfinedPath refuses.
+func MkdirConfined(dir, name string, allowGit bool) error {
+	target, err := ConfinedPath(dir, name, allowGit)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(target, os.ModePerm)
+}
+
+// IsSafeWorkTreePath reports whether p, a path taken from the metadata of a
+// working copy, names a file inside of the work tree and out of its metadata
+// directory metaDir, such as .git, .svn or CVS.
+func IsSafeWorkTreePath(p, metaDir string) bool {
+	if p == "" || path.IsAbs(p) || strings.ContainsAny(p, "\\\x00") {
+		return false
+	}
+	for _, part := range strings.Split(p, "/") {
+		if part == "" || part == "." || part == ".." || isMetaDirName(part, metaDir) {
+			return false
+		}
+	}
+	return true
+}
+
+// isGitDirName reports whether a path component names a .git directory,
+// including the spellings case-insensitive filesystems and Windows resolve
+// to it.
+func isGitDirName(part string) bool {
+	return isMetaDirName(part, ".git")
+}
+
+func isMetaDirName(part, metaDir string) bool {
+	return strings.EqualFold(strings.TrimRight(part, ". "), metaDir)
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsSafeWorkTreePath(t *testing.T) {
+	tests := []struct {
+		path, metaDir string
+		want          bool
+	}{
+		{"src/main.go", ".svn", true},
+		{"a/.git/b", ".svn", true},
+		{"", ".svn", false},
+		{"/etc/passwd", ".svn", false},
+		{"../x", ".svn", false},
+		{"a/../../x", ".svn", false},
+		{"./x", ".hg", false},
+		{"a//b", ".hg", false},
+		{"a\\b", ".hg", false},
+		{"a\x00b", ".hg", false},
+		{".svn/wc.db", ".svn", false},
+		{"a/.SVN/entries", ".svn", false},
+		{".git. /config", ".git", false},
+		{"cvs/Entries", "CVS", false},
+		{".bzr/branch", ".bzr", false},
+	}
+	for _, tt := range tests {
+		if got := IsSafeWorkTreePath(tt.path, tt.metaDir); got != tt.want {
+			t.Errorf("IsSafeWorkTreePath(%q, %q) = %v, want %v", tt.path, tt.metaDir, got, tt.want)
+		}
+	}
+}
+
+func TestConfinedPath(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "real"), 0755)
+	os.Symlink("/tmp", filepath.Join(dir, "link"))
+
+	tests := []struct {
+		file     string
+		allowGit bool
+		ok       bool
+	}{
+		{"a/b", false, true},
+		{"real/x", false, true},
+		{".git/HEAD", true, true},
+		{".git/HEAD", false, false},
+		{"sub/.GIT/config", false, false},
+		{"../x", true, false},
+		{"/etc/passwd", true, false},
+		{"", true, false},
+		{"link/x", true, false},
+		{"a\\b", true, false},
+	}
+	for _, tt := range tests {
+		_, err := ConfinedPath(dir, tt.file, tt.allowGit)
+		if (err == nil) != tt.ok || (err != nil && !errors.Is(err, ErrUnsafePath)) {
+			t.Errorf("ConfinedPath(%q, %v) error = %v, want ok %v", tt.file, tt.allowGit, err, tt.ok)
+		}
+	}
+}
+
+func TestWriteConfinedFileReplacesSymlink(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "outside")
+	os.WriteFile(outside, []byte("untouched"), 0644)
+	os.Symlink(outside, filepath.Join(dir, "file"))
+
+	if err := WriteConfinedFile(dir, "file", []byte("written"), 0644, false); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(outside); string(content) != "untouched" {
+		t.Fatalf("write went through the symlink, outside file is %q", content)
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "file")); string(content) != "written" {
+		t.Fatalf("file is %q", content)
+	}
+}
@@ -0,0 +1,76 @@
+package utils
+
+import "strings"
+
+// CvsEntry is a file or directory listed in a CVS/Entries file.
+type CvsEntry struct {
+	Name     string
+	Dir      bool
+	Revision string
+}
+
+// Added returns whether the file was added but not committed yet.
+func (e CvsEntry) Added() bool {
+	return e.Revision == "0"
+}
+
+// Removed returns whether the file was removed but not committed yet.
+func (e CvsEntry) Removed() bool {
+	return strings.HasPrefix(e.Revision, "-")
+}
+
+// ParseCvsEntries parses a CVS/Entries file, and applies the changes from its
+// CVS/Entries.Log, if any.
+func ParseCvsEntries(entries, entriesLog []byte) []CvsEntry {
+	var parsed []CvsEntry
+	index := make(map[string]int)
+	apply := func(line string, remove bool) {
+		e, ok := parseCvsEntry(line)
+		if !ok {
+			return
+		}
+		i, exists := index[e.Name]
+		switch {
+		case remove && exists:
+			parsed[i].Name = ""
+		case remove:
+		case exists:
+			parsed[i] = e
+		default:
+			index[e.Name] = len(parsed)
+			parsed = append(parsed, e)
+		}
+	}
+
+	for _, line := range strings.Split(string(entries), "\n") {
+		apply(line, false)
+	}
+	for _, line := range strings.Split(string(entriesLog), "\n") {
+		if op, entry, ok := strings.Cut(line, " "); ok && (op == "A" || op == "R") {
+			apply(entry, op == "R")
+		}
+	}
+
+	var result []CvsEntry
+	for _, e := range parsed {
+		if e.Name != "" {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// parseCvsEntry parses "/name/revision/timestamp/options/tagdate" for files
+// and "D/name////" for directories.
+func parseCvsEntry(line string) (CvsEntry, bool) {
+	line = strings.TrimRight(line, "\r")
+	dir := strings.HasPrefix(line, "D/")
+	if dir {
+		line = line[1:]
+	}
+	fields := strings.Split(line, "/")
+	if len(fields) < 3 || fields[0] != "" || fields[1] == "" {
+		return CvsEntry{}, false
+	}
+	return CvsEntry{Name: fields[1], Dir: dir, Revision: fields[2]}, true
+}
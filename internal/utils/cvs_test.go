@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCvsEntries(t *testing.T) {
+	entries := "/README/1.3/Mon Jan  1 00:00:00 2024//\n" +
+		"/main.c/1.12/Result of merge+Mon Jan  1 00:00:00 2024/-kb/Trelease\r\n" +
+		"/new.c/0/dummy timestamp//\n" +
+		"/gone.c/-1.4/dummy timestamp//\n" +
+		"/later.c/1.1/Mon Jan  1 00:00:00 2024//\n" +
+		"D/src////\n" +
+		"D\n" +
+		"garbage\n" +
+		"//1.1///\n"
+	tests := []struct {
+		name string
+		log  string
+		want []CvsEntry
+	}{
+		{"entries", "", []CvsEntry{
+			{Name: "README", Revision: "1.3"},
+			{Name: "main.c", Revision: "1.12"},
+			{Name: "new.c", Revision: "0"},
+			{Name: "gone.c", Revision: "-1.4"},
+			{Name: "later.c", Revision: "1.1"},
+			{Name: "src", Dir: true},
+		}},
+		{"with log", "A D/doc////\n" +
+			"R /later.c/1.1/Mon Jan  1 00:00:00 2024//\n" +
+			"A /README/1.4/Tue Jan  2 00:00:00 2024//\n" +
+			"R D/src////\n" +
+			"A D/src////\n" +
+			"X /ignored.c/1.1///\n" +
+			"R /never-there.c/1.1///\n",
+			[]CvsEntry{
+				{Name: "README", Revision: "1.4"},
+				{Name: "main.c", Revision: "1.12"},
+				{Name: "new.c", Revision: "0"},
+				{Name: "gone.c", Revision: "-1.4"},
+				{Name: "src", Dir: true},
+				{Name: "doc", Dir: true},
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseCvsEntries([]byte(entries), []byte(tt.log))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseCvsEntries() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	for _, e := range []struct {
+		entry          CvsEntry
+		added, removed bool
+	}{
+		{CvsEntry{Name: "a", Revision: "1.1"}, false, false},
+		{CvsEntry{Name: "a", Revision: "0"}, true, false},
+		{CvsEntry{Name: "a", Revision: "-1.1"}, false, true},
+	} {
+		if e.entry.Added() != e.added || e.entry.Removed() != e.removed {
+			t.Errorf("%+v: Added() = %v, Removed() = %v", e.entry, e.entry.Added(), e.entry.Removed())
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package utils
+
+import "strings"
+
+// sections of the git config whose every key is kept, with * standing in for
+// a subsection
+var allowedGitConfigSections = map[string]bool{
+	"branch.*":   true,
+	"extensions": true,
+}
+
+// keys of the git config that are kept, the ones goop and git need to read
+// the dumped repository, with * standing in for a subsection
+var allowedGitConfigKeys = map[string]bool{
+	"core.bare":                    true,
+	"core.repositoryformatversion": true,
+	"lfs.url":                      true,
+	"remote.*.fetch":               true,
+	"remote.*.lfsurl":              true,
+	"remote.*.url":                 true,
+}
+
+// SanitizeGitConfig removes every key but the few needed to read the
+// repository from the content of a git config file, as so many of them can
+// make git run a command or reach elsewhere, leaving the section headers and
+// comments untouched. It returns the sanitized config and the names of the
+// removed keys.
+func SanitizeGitConfig(content []byte) ([]byte, []string) {
+	var out strings.Builder
+	var removed []string
+	var section, subsection string
+	dropping, continued := false, false
+
+	for _, line := range strings.SplitAfter(string(content), "\n") {
+		if continued {
+			// the value of the previous line goes on in this one
+			continued = isContinued(line)
+			if !dropping {
+				out.WriteString(line)
+			}
+			continue
+		}
+		dropping = false
+
+		rest := strings.TrimLeft(line, " \t")
+		if strings.HasPrefix(rest, "[") {
+			end, ok := parseGitConfigSection(rest, &section, &subsection)
+			if !ok {
+				out.WriteString(line)
+				continue
+			}
+			// a key can follow the section header on the same line
+			header := line[:len(line)-len(rest)+end]
+			rest = rest[end:]
+			key := gitConfigKey(rest)
+			if key != "" && !isAllowedGitConfigKey(section, subsection, key) {
+				removed = append(removed, gitConfigKeyName(section, subsection, key))
+				out.WriteString(header + "\n")
+				dropping = true
+			} else {
+				out.WriteString(line)
+			}
+			continued = key != "" && isContinued(rest)
+			continue
+		}
+
+		key := gitConfigKey(rest)
+		if key != "" && !isAllowedGitConfigKey(section, subsection, key) {
+			removed = append(removed, gitConfigKeyName(section, subsection, key))
+			dropping = true
+		} else {
+			out.WriteString(line)
+		}
+		continued = key != "" && isContinued(rest)
+	}
+	return []byte(out.String()), removed
+}
+
+// parseGitConfigSection parses a section header such as [core], [remote
+// "origin"] or the deprecated [branch.main], returning where it ends.
+func parseGitConfigSection(line string, section, subsection *string) (int, bool) {
+	i := 1
+	for i < len(line) && (isAlnum(line[i]) || line[i] == '-' || line[i] == '.') {
+		i++
+	}
+	name := line[1:i]
+	sub := ""
+	if dot := strings.IndexByte(name, '.'); dot >= 0 {
+		name, sub = name[:dot], strings.ToLower(name[dot+1:])
+	}
+	for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+		i++
+	}
+	if i < len(line) && line[i] == '"' {
+		var b strings.Builder
+		for i++; i < len(line) && line[i] != '"'; i++ {
+			if line[i] == '\\' && i+1 < len(line) {
+				i++
+			}
+			b.WriteByte(line[i])
+		}
+		if i >= len(line) {
+			return 0, false
+		}
+		sub = b.String()
+		i++
+	}
+	if i >= len(line) || line[i] != ']' || name == "" {
+		return 0, false
+	}
+	*section, *subsection = strings.ToLower(name), sub
+	return i + 1, true
+}
+
+// gitConfigKey returns the lowercased name of the key set on a line, if any.
+func gitConfigKey(line string) string {
+	line = strings.TrimLeft(line, " \t")
+	i := 0
+	for i < len(line) && (isAlnum(line[i]) || line[i] == '-') {
+		i++
+	}
+	return strings.ToLower(line[:i])
+}
+
+func gitConfigKeyName(section, subsection, key string) string {
+	if subsection == "" {
+		return section + "." + key
+	}
+	return section + "." + subsection + "." + key
+}
+
+func isAllowedGitConfigKey(section, subsection, key string) bool {
+	if subsection != "" {
+		return allowedGitConfigSections[section+".*"] || allowedGitConfigKeys[section+".*."+key]
+	}
+	return allowedGitConfigSections[section] || allowedGitConfigKeys[section+"."+key]
+}
+
+// isContinued reports whether a line ends with an escaped newline, which
+// continues its value on the next line.
+func isContinued(line string) bool {
+	line = strings.TrimRight(line, "\r\n")
+	n := 0
+	for n < len(line) && line[len(line)-1-n] == '\\' {
+		n++
+	}
+	return n%2 == 1
+}
+
+func isAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
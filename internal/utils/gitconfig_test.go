@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSanitizeGitConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      string
+		want        string
+		wantRemoved []string
+	}{
+		{
+			"kept",
+			"[core]\n\trepositoryformatversion = 1\n\tbare = false\n" +
+				"[extensions]\n\tobjectFormat = sha256\n\tworktreeConfig\n" +
+				"[remote \"origin\"]\n\turl = https://example.com/r.git\n\tfetch = +refs/heads/*:refs/remotes/origin/*\n" +
+				"[branch \"main\"]\n\tremote = origin\n\tmerge = refs/heads/main\n" +
+				"[lfs]\n\turl = https://example.com/r.git/info/lfs\n",
+			"[core]\n\trepositoryformatversion = 1\n\tbare = false\n" +
+				"[extensions]\n\tobjectFormat = sha256\n\tworktreeConfig\n" +
+				"[remote \"origin\"]\n\turl = https://example.com/r.git\n\tfetch = +refs/heads/*:refs/remotes/origin/*\n" +
+				"[branch \"main\"]\n\tremote = origin\n\tmerge = refs/heads/main\n" +
+				"[lfs]\n\turl = https://example.com/r.git/info/lfs\n",
+			nil,
+		},
+		{
+			"removed",
+			"[core]\n\tfsmonitor = touch /tmp/pwned\n\tworktree = /etc\n\tfilemode = true\n" +
+				"[protocol]\n\tallow = always\n[protocol \"ext\"]\n\tallow = always\n" +
+				"[remote \"origin\"]\n\turl = ext::sh -c touch% /tmp/pwned\n\tuploadpack = touch /tmp/pwned\n" +
+				"[alias]\n\tst = !touch /tmp/pwned\n" +
+				"[include]\n\tpath = /tmp/evil\n",
+			"[core]\n[protocol]\n[protocol \"ext\"]\n" +
+				"[remote \"origin\"]\n\turl = ext::sh -c touch% /tmp/pwned\n" +
+				"[alias]\n[include]\n",
+			[]string{"core.fsmonitor", "core.worktree", "core.filemode", "protocol.allow", "protocol.ext.allow", "remote.origin.uploadpack", "alias.st", "include.path"},
+		},
+		{
+			"case",
+			"[CORE]\n\tFsMonitor = x\n\tRepositoryFormatVersion = 0\n" +
+				"[Remote \"Origin\"]\n\tURL = u\n\tReceivePack = x\n" +
+				"[Branch.Main]\n\tRemote = origin\n" +
+				"[remote.Origin]\n\tVcs = x\n",
+			"[CORE]\n\tRepositoryFormatVersion = 0\n" +
+				"[Remote \"Origin\"]\n\tURL = u\n" +
+				"[Branch.Main]\n\tRemote = origin\n" +
+				"[remote.Origin]\n",
+			[]string{"core.fsmonitor", "remote.Origin.receivepack", "remote.origin.vcs"},
+		},
+		{
+			"continuation lines",
+			"[core]\n\teditor = vim \\\n\t  -c 'touch /tmp/pwned' \\\n\t  -c q\n\tbare = false \\\n\ttrue\n" +
+				"[filter \"lfs\"]\n\tsmudge = git-lfs \\\\\n\tclean = x\n",
+			"[core]\n\tbare = false \\\n\ttrue\n" +
+				"[filter \"lfs\"]\n",
+			[]string{"core.editor", "filter.lfs.smudge", "filter.lfs.clean"},
+		},
+		{
+			"key on the section line",
+			"[core] hooksPath = /tmp/hooks\n[core] bare = true\n",
+			"[core]\n[core] bare = true\n",
+			[]string{"core.hookspath"},
+		},
+		{
+			"escaped subsection",
+			"[remote \"a\\\"b\\\\c\"]\n\turl = u\n\tpushurl = p\n",
+			"[remote \"a\\\"b\\\\c\"]\n\turl = u\n",
+			[]string{"remote.a\"b\\c.pushurl"},
+		},
+		{
+			"comments and malformed headers",
+			"# [alias]\n; x = y\n[core\n\tbare = true\n\n[ \"x\"]\n",
+			"# [alias]\n; x = y\n[core\n\n[ \"x\"]\n",
+			// the header isn't closed, so the key is outside any section
+			[]string{".bare"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, removed := SanitizeGitConfig([]byte(tt.config))
+			if string(got) != tt.want {
+				t.Errorf("SanitizeGitConfig() = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(removed, tt.wantRemoved) {
+				t.Errorf("removed = %q, want %q", removed, tt.wantRemoved)
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// HgChangeset is a changeset stored in the changelog.
+type HgChangeset struct {
+	Manifest    string
+	User        string
+	Date        string
+	Branch      string
+	Files       []string
+	Description string
+}
+
+// ParseHgChangeset parses the text of a changelog revision.
+func ParseHgChangeset(text []byte) (*HgChangeset, error) {
+	header, desc, ok := bytes.Cut(text, []byte("\n\n"))
+	if !ok {
+		// changesets without files nor description end right after the date
+		header = bytes.TrimSuffix(text, []byte("\n"))
+	}
+	lines := strings.Split(string(header), "\n")
+	if len(lines) < 3 {
+		return nil, errors.New("changeset is truncated")
+	}
+	cs := &HgChangeset{
+		Manifest:    lines[0],
+		User:        lines[1],
+		Branch:      "default",
+		Files:       lines[3:],
+		Description: string(desc),
+	}
+	// the date line is "<unixtime> <tz offset> <extra>"
+	fields := strings.SplitN(lines[2], " ", 3)
+	if len(fields) >= 2 {
+		cs.Date = fields[0] + " " + fields[1]
+	}
+	if len(fields) == 3 {
+		for _, extra := range strings.Split(fields[2], "\x00") {
+			key, value, _ := strings.Cut(unescapeHgExtra(extra), ":")
+			if key == "branch" {
+				cs.Branch = value
+			}
+		}
+	}
+	return cs, nil
+}
+
+func unescapeHgExtra(s string) string {
+	return strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\r`, "\r", `\0`, "\x00").Replace(s)
+}
+
+// HgManifestEntry is a file of a manifest, with flags "x" for executables,
+// "l" for symlinks, or "t" for trees.
+type HgManifestEntry struct {
+	Path  string
+	Node  string
+	Flags string
+}
+
+// ParseHgManifest parses the text of a (flat) manifest revision.
+func ParseHgManifest(text []byte) ([]HgManifestEntry, error) {
+	var entries []HgManifestEntry
+	for _, line := range strings.Split(string(text), "\n") {
+		if line == "" {
+			continue
+		}
+		name, node, ok := strings.Cut(line, "\x00")
+		if !ok || len(node) < 40 {
+			return entries, fmt.Errorf("malformed manifest line %q", line)
+		}
+		entries = append(entries, HgManifestEntry{Path: name, Node: node[:40], Flags: node[40:]})
+	}
+	return entries, nil
+}
+
+// HgFileText strips the copy metadata from the text of a filelog revision.
+func HgFileText(text []byte) []byte {
+	if !bytes.HasPrefix(text, []byte("\x01\n")) {
+		return text
+	}
+	if end := bytes.Index(text[2:], []byte("\x01\n")); end >= 0 {
+		return text[end+4:]
+	}
+	return text
+}
+
+// HgDirstateEntry is a file tracked by the dirstate, with the state being
+// "n" (normal), "a" (added), "r" (removed) or "m" (merged).
+type HgDirstateEntry struct {
+	State  string
+	Path   string
+	CopyOf string
+}
+
+// HgDirstate is a (v1) dirstate, the list of files tracked by the working
+// directory and its parents.
+type HgDirstate struct {
+	Parents [2]string
+	Entries []HgDirstateEntry
+}
+
+func ParseHgDirstate(data []byte) (*HgDirstate, error) {
+	if len(data) < 2*sha1.Size {
+		return nil, errors.New("dirstate is truncated")
+	}
+	ds := &HgDirstate{Parents: [2]string{
+		hex.EncodeToString(data[:sha1.Size]),
+		hex.EncodeToString(data[sha1.Size : 2*sha1.Size]),
+	}}
+	data = data[2*sha1.Size:]
+	for len(data) > 0 {
+		// state, mode, size, mtime, name length
+		if len(data) < 17 {
+			return ds, errors.New("dirstate is truncated")
+		}
+		size := int(binary.BigEndian.Uint32(data[13:17]))
+		if 17+size > len(data) {
+			return ds, errors.New("dirstate is truncated")
+		}
+		name, copyOf, _ := strings.Cut(string(data[17:17+size]), "\x00")
+		ds.Entries = append(ds.Entries, HgDirstateEntry{State: string(data[0]), Path: name, CopyOf: copyOf})
+		data = data[17+size:]
+	}
+	return ds, nil
+}
+
+// HgStorePath returns the path in the store of the revlog for file (such as
+// data/foo.txt.i), encoded the way the requirements of the repository say.
+func HgStorePath(file string, requires map[string]bool) string {
+	file = encodeHgDir(file)
+	switch {
+	case requires["fncache"]:
+		return hybridEncodeHgPath(file, requires["dotencode"])
+	case requires["store"]:
+		return encodeHgFilename(file)
+	}
+	return file
+}
+
+// encodeHgDir keeps directories from clashing with revlog files.
+func encodeHgDir(p string) string {
+	if !strings.Contains(p, ".hg/") && !strings.Contains(p, ".i/") && !strings.Contains(p, ".d/") {
+		return p
+	}
+	return strings.NewReplacer(".hg/", ".hg.hg/", ".i/", ".i.hg/", ".d/", ".d.hg/").Replace(p)
+}
+
+func encodeHgFilename(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case c >= 'A' && c <= 'Z':
+			b.WriteByte('_')
+			b.WriteByte(c + 'a' - 'A')
+		case c == '_':
+			b.WriteString("__")
+		case c < 32 || c >= 126 || strings.IndexByte(`\:*?"<>|`, c) >= 0:
+			fmt.Fprintf(&b, "~%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func lowerEncodeHgPath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case c >= 'A' && c <= 'Z':
+			b.WriteByte(c + 'a' - 'A')
+		case c < 32 || c >= 126 || strings.IndexByte(`\:*?"<>|`, c) >= 0:
+			fmt.Fprintf(&b, "~%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// auxEncodeHgPath encodes the path components windows wouldn't accept:
+// reserved device names, and names ending (or starting, with dotencode) with
+// a dot or a space.
+func auxEncodeHgPath(parts []string, dotencode bool) []string {
+	for i, n := range parts {
+		if n == "" {
+			continue
+		}
+		if dotencode && (n[0] == '.' || n[0] == ' ') {
+			n = fmt.Sprintf("~%02x", n[0]) + n[1:]
+		} else {
+			l := strings.IndexByte(n, '.')
+			if l < 0 {
+				l = len(n)
+			}
+			if (l == 3 && (n[:3] == "con" || n[:3] == "prn" || n[:3] == "aux" || n[:3] == "nul")) ||
+				(l == 4 && n[3] >= '1' && n[3] <= '9' && (n[:3] == "com" || n[:3] == "lpt")) {
+				n = n[:2] + fmt.Sprintf("~%02x", n[2]) + n[3:]
+			}
+		}
+		if last := n[len(n)-1]; last == '.' || last == ' ' {
+			n = n[:len(n)-1] + fmt.Sprintf("~%02x", last)
+		}
+		parts[i] = n
+	}
+	return parts
+}
+
+// hybridEncodeHgPath is the encoding of fncache stores, which hash paths that
+// would get longer than 120 characters.
+func hybridEncodeHgPath(p string, dotencode bool) string {
+	const maxStorePathLen = 120
+	res := strings.Join(auxEncodeHgPath(strings.Split(encodeHgFilename(p), "/"), dotencode), "/")
+	if len(res) <= maxStorePathLen {
+		return res
+	}
+
+	sum := sha1.Sum([]byte(p))
+	digest := hex.EncodeToString(sum[:])
+	_, rest, _ := strings.Cut(p, "/")
+	parts := auxEncodeHgPath(strings.Split(lowerEncodeHgPath(rest), "/"), dotencode)
+	basename := parts[len(parts)-1]
+	ext := path.Ext(basename)
+
+	var dirs []string
+	dirsLen := 0
+	for _, d := range parts[:len(parts)-1] {
+		if len(d) > 8 {
+			d = d[:8]
+		}
+		if last := d[len(d)-1]; last == '.' || last == ' ' {
+			d = d[:len(d)-1] + "_"
+		}
+		l := len(d)
+		if dirsLen > 0 {
+			l = dirsLen + 1 + len(d)
+			if l > 68 {
+				break
+			}
+		}
+		dirs = append(dirs, d)
+		dirsLen = l
+	}
+	prefix := "dh/"
+	if len(dirs) > 0 {
+		prefix += strings.Join(dirs, "/") + "/"
+	}
+	res = prefix + digest + ext
+	if left := maxStorePathLen - len(res); left > 0 {
+		if left > len(basename) {
+			left = len(basename)
+		}
+		res = prefix + basename[:left] + digest + ext
+	}
+	return res
+}
@@ -0,0 +1,184 @@
+package utils
+
+import (
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHgStorePath(t *testing.T) {
+	var dirs []string
+	for _, d := range []string{"00", "01", "02", "03", "04", "05", "06", "07", "08", "09", "10", "11"} {
+		dirs = append(dirs, "directory"+d)
+	}
+	deep := "data/" + strings.Join(dirs, "/") + "/Some File Name With Capitals.txt.i"
+	long := "data/abcdefg.hij/klmnopqrstuvwxyz/" + strings.Repeat("y", 120) + ".txt.i"
+	spaced := "data/a. /" + strings.Repeat("x", 130) + ".d"
+
+	// expected paths are those of mercurial's store.py
+	tests := []struct {
+		file      string
+		plain     string
+		store     string
+		fncache   string
+		dotencode string
+	}{
+		{"data/foo.txt.i", "data/foo.txt.i", "data/foo.txt.i", "data/foo.txt.i", "data/foo.txt.i"},
+		{"data/ABC/Def_g.i", "data/ABC/Def_g.i", "data/_a_b_c/_def__g.i", "data/_a_b_c/_def__g.i", "data/_a_b_c/_def__g.i"},
+		{
+			"data/foo.i/bar.d/bla.hg/hi:world?/HELLO.i",
+			"data/foo.i.hg/bar.d.hg/bla.hg.hg/hi:world?/HELLO.i",
+			"data/foo.i.hg/bar.d.hg/bla.hg.hg/hi~3aworld~3f/_h_e_l_l_o.i",
+			"data/foo.i.hg/bar.d.hg/bla.hg.hg/hi~3aworld~3f/_h_e_l_l_o.i",
+			"data/foo.i.hg/bar.d.hg/bla.hg.hg/hi~3aworld~3f/_h_e_l_l_o.i",
+		},
+		{"data/.hgtags.i", "data/.hgtags.i", "data/.hgtags.i", "data/.hgtags.i", "data/~2ehgtags.i"},
+		{
+			"data/aux.bla/bla.aux/prn/PRN/lpt/com3/nul/coma/foo.NUL/normal.c.i",
+			"data/aux.bla/bla.aux/prn/PRN/lpt/com3/nul/coma/foo.NUL/normal.c.i",
+			"data/aux.bla/bla.aux/prn/_p_r_n/lpt/com3/nul/coma/foo._n_u_l/normal.c.i",
+			"data/au~78.bla/bla.aux/pr~6e/_p_r_n/lpt/co~6d3/nu~6c/coma/foo._n_u_l/normal.c.i",
+			"data/au~78.bla/bla.aux/pr~6e/_p_r_n/lpt/co~6d3/nu~6c/coma/foo._n_u_l/normal.c.i",
+		},
+		{
+			"data/.com1com2/lpt9.lpt4.lpt1/conprn/com0/lpt0/foo. .i",
+			"data/.com1com2/lpt9.lpt4.lpt1/conprn/com0/lpt0/foo. .i",
+			"data/.com1com2/lpt9.lpt4.lpt1/conprn/com0/lpt0/foo. .i",
+			"data/.com1com2/lp~749.lpt4.lpt1/conprn/com0/lpt0/foo. .i",
+			"data/~2ecom1com2/lp~749.lpt4.lpt1/conprn/com0/lpt0/foo. .i",
+		},
+		{"data/ends in space /x.i", "data/ends in space /x.i", "data/ends in space /x.i", "data/ends in space~20/x.i", "data/ends in space~20/x.i"},
+		{"data/tilde~and\abell.i", "data/tilde~and\abell.i", "data/tilde~7eand~07bell.i", "data/tilde~7eand~07bell.i", "data/tilde~7eand~07bell.i"},
+		{"data/aux/FOO_bar.i", "data/aux/FOO_bar.i", "data/aux/_f_o_o__bar.i", "data/au~78/_f_o_o__bar.i", "data/au~78/_f_o_o__bar.i"},
+		// paths longer than 120 characters are hashed
+		{
+			deep, deep,
+			"data/" + strings.Join(dirs, "/") + "/_some _file _name _with _capitals.txt.i",
+			"dh/director/director/director/director/director/director/director/some file na08e340994d84cc2c184da6b3069b935d3596a9ad.i",
+			"dh/director/director/director/director/director/director/director/some file na08e340994d84cc2c184da6b3069b935d3596a9ad.i",
+		},
+		{
+			long, long, long,
+			"dh/abcdefg_/klmnopqr/" + strings.Repeat("y", 57) + "13ddcf09dae540803d2e222c1c2904f7bb9b0820.i",
+			"dh/abcdefg_/klmnopqr/" + strings.Repeat("y", 57) + "13ddcf09dae540803d2e222c1c2904f7bb9b0820.i",
+		},
+		{
+			spaced, spaced, spaced,
+			"dh/a.~20/" + strings.Repeat("x", 69) + "d2547ce349370e5e8424ad7f106d6ad036836346.d",
+			"dh/a.~20/" + strings.Repeat("x", 69) + "d2547ce349370e5e8424ad7f106d6ad036836346.d",
+		},
+	}
+	for _, tt := range tests {
+		for _, c := range []struct {
+			requires map[string]bool
+			want     string
+		}{
+			{map[string]bool{}, tt.plain},
+			{map[string]bool{"store": true}, tt.store},
+			{map[string]bool{"store": true, "fncache": true}, tt.fncache},
+			{map[string]bool{"store": true, "fncache": true, "dotencode": true}, tt.dotencode},
+		} {
+			if got := HgStorePath(tt.file, c.requires); got != c.want {
+				t.Errorf("HgStorePath(%q, %v) = %q, want %q", tt.file, c.requires, got, c.want)
+			}
+		}
+	}
+}
+
+// hgDirstateEntry lays out an entry of a v1 dirstate.
+func hgDirstateEntry(state byte, name string) []byte {
+	entry := []byte{state}
+	entry = binary.BigEndian.AppendUint32(entry, 0100644)
+	entry = binary.BigEndian.AppendUint32(entry, 6)
+	entry = binary.BigEndian.AppendUint32(entry, 1700000000)
+	entry = binary.BigEndian.AppendUint32(entry, uint32(len(name)))
+	return append(entry, name...)
+}
+
+func TestParseHgDirstate(t *testing.T) {
+	p1 := strings.Repeat("\x11", 20)
+	p2 := strings.Repeat("\x00", 20)
+	var data []byte
+	data = append(data, p1+p2...)
+	data = append(data, hgDirstateEntry('n', "README")...)
+	data = append(data, hgDirstateEntry('a', "src/new.c")...)
+	data = append(data, hgDirstateEntry('r', "old.txt")...)
+	data = append(data, hgDirstateEntry('m', "copy.txt\x00README")...)
+
+	ds, err := ParseHgDirstate(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantParents := [2]string{strings.Repeat("11", 20), strings.Repeat("00", 20)}
+	if ds.Parents != wantParents {
+		t.Errorf("parents = %v, want %v", ds.Parents, wantParents)
+	}
+	want := []HgDirstateEntry{
+		{State: "n", Path: "README"},
+		{State: "a", Path: "src/new.c"},
+		{State: "r", Path: "old.txt"},
+		{State: "m", Path: "copy.txt", CopyOf: "README"},
+	}
+	if !reflect.DeepEqual(ds.Entries, want) {
+		t.Errorf("entries = %+v, want %+v", ds.Entries, want)
+	}
+
+	for _, n := range []int{39, len(data) - 1, 40 + 16} {
+		ds, err := ParseHgDirstate(data[:n])
+		if err == nil || !strings.Contains(err.Error(), "truncated") {
+			t.Errorf("%d bytes: error = %v, want it truncated", n, err)
+		}
+		if n > 40+17+6 && len(ds.Entries) != 3 {
+			t.Errorf("%d bytes: kept %d entries, want the 3 complete ones", n, len(ds.Entries))
+		}
+	}
+}
+
+func TestParseHgChangeset(t *testing.T) {
+	manifest := strings.Repeat("ab", 20)
+	tests := []struct {
+		name    string
+		text    string
+		want    *HgChangeset
+		wantErr bool
+	}{
+		{"default branch", manifest + "\nalice <a@b>\n1700000000 0\nREADME\nsrc/main.c\n\nfirst\n\nbody",
+			&HgChangeset{Manifest: manifest, User: "alice <a@b>", Date: "1700000000 0", Branch: "default", Files: []string{"README", "src/main.c"}, Description: "first\n\nbody"}, false},
+		{"named branch", manifest + "\nbob\n1700000000 -3600 branch:stable\x00close:1\nREADME\n\nfix",
+			&HgChangeset{Manifest: manifest, User: "bob", Date: "1700000000 -3600", Branch: "stable", Files: []string{"README"}, Description: "fix"}, false},
+		{"escaped branch", manifest + "\nbob\n0 0 branch:a\\\\b\\nc\n\nmsg",
+			&HgChangeset{Manifest: manifest, User: "bob", Date: "0 0", Branch: "a\\b\nc", Files: []string{}, Description: "msg"}, false},
+		{"truncated", manifest + "\nbob", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseHgChangeset([]byte(tt.text))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseHgChangeset() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseHgChangeset() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseHgManifest(t *testing.T) {
+	a, b := strings.Repeat("a", 40), strings.Repeat("b", 40)
+	got, err := ParseHgManifest([]byte("README\x00" + a + "\nbin/run\x00" + b + "x\nlink\x00" + a + "l\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []HgManifestEntry{{"README", a, ""}, {"bin/run", b, "x"}, {"link", a, "l"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseHgManifest() = %+v, want %+v", got, want)
+	}
+	if _, err := ParseHgManifest([]byte("README\x00abc\n")); err == nil {
+		t.Error("manifest line with a short node was accepted")
+	}
+
+	if got := HgFileText([]byte("\x01\ncopy: a\ncopyrev: " + a + "\n\x01\ncontent")); string(got) != "content" {
+		t.Errorf("HgFileText() = %q, want the content without copy metadata", got)
+	}
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+)
+
+// History holds the commits whose parents are overridden while walking
+// history: shallow commits (from .git/shallow) have no parents, grafted
+// commits (from .git/info/grafts) have the parents listed in the graft.
+type History struct {
+	Shallow map[string]bool
+	Grafts  map[string][]string
+}
+
+// NewHistory returns an empty History.
+func NewHistory() *History {
+	return &History{Shallow: make(map[string]bool), Grafts: make(map[string][]string)}
+}
+
+// ParseShallow adds the commits listed in a .git/shallow file.
+func (h *History) ParseShallow(content []byte, format ObjectFormat) {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		if hash := strings.TrimSpace(scanner.Text()); format.IsHash(hash) {
+			h.Shallow[hash] = true
+		}
+	}
+}
+
+// ParseGrafts adds the grafts listed in a .git/info/grafts file, each line
+// being a commit followed by its parents.
+func (h *History) ParseGrafts(content []byte, format ObjectFormat) {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if !format.IsHash(fields[0]) {
+			continue
+		}
+		var parents []string
+		for _, parent := range fields[1:] {
+			if format.IsHash(parent) {
+				parents = append(parents, parent)
+			}
+		}
+		h.Grafts[fields[0]] = parents
+	}
+}
+
+// Hashes returns every commit mentioned in the shallow and graft files.
+func (h *History) Hashes() []string {
+	var hashes []string
+	for hash := range h.Shallow {
+		hashes = append(hashes, hash)
+	}
+	for hash, parents := range h.Grafts {
+		hashes = append(hashes, hash)
+		hashes = append(hashes, parents...)
+	}
+	return hashes
+}
+
+// IsTruncated reports whether the history stops at shallow or grafted
+// commits.
+func (h *History) IsTruncated() bool {
+	return h != nil && (len(h.Shallow) > 0 || len(h.Grafts) > 0)
+}
+
+// ReferencedHashes works like GetRawReferencedHashes, except that the parents
+// of shallow and grafted commits are replaced. A nil History changes nothing.
+func (h *History) ReferencedHashes(hash, typ string, content []byte, format ObjectFormat) []string {
+	if h == nil || typ != "commit" {
+		return GetRawReferencedHashes(typ, content, format)
+	}
+	parents, grafted := h.Grafts[hash]
+	if !h.Shallow[hash] && !grafted {
+		return GetRawReferencedHashes(typ, content, format)
+	}
+	var hashes []string
+	if tree, _ := ParseCommit(content, format); tree != "" {
+		hashes = append(hashes, tree)
+	}
+	if !h.Shallow[hash] {
+		hashes = append(hashes, parents...)
+	}
+	return hashes
+}
@@ -0,0 +1,260 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+var indexSignature = []byte{'D', 'I', 'R', 'C'}
+
+const (
+	indexEntryExtended = 0x4000
+	indexNameMask      = 0xfff
+)
+
+// IndexEntry is a single entry of a git index file.
+type IndexEntry struct {
+	Name       string
+	Hash       string
+	Mode       uint32
+	UID        uint32
+	GID        uint32
+	Size       uint32
+	ModifiedAt time.Time
+	Stage      int
+}
+
+// Index is a git index file, decoded independently of go-git so it works
+// with every object format. Extensions are kept undecoded, keyed by their
+// signature.
+type Index struct {
+	Version    uint32
+	Entries    []*IndexEntry
+	Extensions map[string][]byte
+}
+
+// Entry returns the stage 0 entry with the given name.
+func (idx *Index) Entry(name string) (*IndexEntry, error) {
+	for _, e := range idx.Entries {
+		if e.Name == name && e.Stage == 0 {
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("entry %s not found", name)
+}
+
+// ReadIndex reads and decodes the index file at path. A split index is
+// merged with its shared index, when that is present next to it.
+func ReadIndex(path string, format ObjectFormat) (*Index, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := DecodeIndex(data, format)
+	if err != nil {
+		return idx, err
+	}
+	if shared := idx.SharedIndex(format); shared != "" {
+		sharedPath := filepath.Join(filepath.Dir(path), "sharedindex."+shared)
+		if !Exists(sharedPath) {
+			return idx, fmt.Errorf("shared index %s is missing", shared)
+		}
+		sharedData, err := os.ReadFile(sharedPath)
+		if err != nil {
+			return idx, err
+		}
+		sharedIdx, err := DecodeIndex(sharedData, format)
+		if err != nil {
+			return idx, fmt.Errorf("shared index %s: %w", shared, err)
+		}
+		if err := idx.MergeSharedIndex(sharedIdx, format); err != nil {
+			return idx, fmt.Errorf("shared index %s: %w", shared, err)
+		}
+	}
+	return idx, nil
+}
+
+// DecodeIndex decodes a version 2, 3 or 4 git index file. Decoding stops at
+// the first malformed entry, returning the entries read so far.
+func DecodeIndex(data []byte, format ObjectFormat) (*Index, error) {
+	if len(data) < 12+format.Size || !bytes.Equal(data[:4], indexSignature) {
+		return nil, errors.New("malformed index signature")
+	}
+	idx := &Index{
+		Version:    binary.BigEndian.Uint32(data[4:8]),
+		Extensions: make(map[string][]byte),
+	}
+	if idx.Version < 2 || idx.Version > 4 {
+		return nil, fmt.Errorf("unsupported index version %d", idx.Version)
+	}
+	count := int(binary.BigEndian.Uint32(data[8:12]))
+	end := len(data) - format.Size // trailing checksum
+
+	pos := 12
+	prevName := ""
+	for i := 0; i < count; i++ {
+		e, n, err := decodeIndexEntry(data[pos:end], idx.Version, prevName, format)
+		if err != nil {
+			return idx, fmt.Errorf("entry %d: %w", i, err)
+		}
+		idx.Entries = append(idx.Entries, e)
+		prevName = e.Name
+		pos += n
+	}
+
+	for pos+8 <= end {
+		sig := string(data[pos : pos+4])
+		size := int(binary.BigEndian.Uint32(data[pos+4 : pos+8]))
+		pos += 8
+		if size > end-pos {
+			return idx, fmt.Errorf("extension %q is truncated", sig)
+		}
+		idx.Extensions[sig] = data[pos : pos+size]
+		pos += size
+	}
+	return idx, nil
+}
+
+// EncodeIndex encodes idx as a version 2 index file, with its entries sorted
+// the way git expects them and its extensions after them.
+func EncodeIndex(idx *Index, format ObjectFormat) ([]byte, error) {
+	entries := make([]*IndexEntry, len(idx.Entries))
+	copy(entries, idx.Entries)
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		return entries[i].Stage < entries[j].Stage
+	})
+
+	be := binary.BigEndian
+	buf := append([]byte{}, indexSignature...)
+	buf = be.AppendUint32(buf, 2)
+	buf = be.AppendUint32(buf, uint32(len(entries)))
+	for _, e := range entries {
+		raw, err := hex.DecodeString(e.Hash)
+		if err != nil || len(raw) != format.Size {
+			return nil, fmt.Errorf("invalid object name %q for %s", e.Hash, e.Name)
+		}
+		if e.Stage < 0 || e.Stage > 3 {
+			return nil, fmt.Errorf("invalid stage %d for %s", e.Stage, e.Name)
+		}
+		var sec, nsec uint32
+		if !e.ModifiedAt.IsZero() {
+			sec, nsec = uint32(e.ModifiedAt.Unix()), uint32(e.ModifiedAt.Nanosecond())
+		}
+		start := len(buf)
+		// ctime, then mtime, then dev and ino
+		buf = be.AppendUint32(buf, sec)
+		buf = be.AppendUint32(buf, nsec)
+		buf = be.AppendUint32(buf, sec)
+		buf = be.AppendUint32(buf, nsec)
+		buf = append(buf, make([]byte, 8)...)
+		for _, v := range []uint32{e.Mode, e.UID, e.GID, e.Size} {
+			buf = be.AppendUint32(buf, v)
+		}
+		buf = append(buf, raw...)
+		buf = be.AppendUint16(buf, uint16(e.Stage)<<12|uint16(min(len(e.Name), indexNameMask)))
+		buf = append(buf, e.Name...)
+		buf = append(buf, make([]byte, 8-(len(buf)-start)%8)...)
+	}
+
+	sigs := make([]string, 0, len(idx.Extensions))
+	for sig := range idx.Extensions {
+		sigs = append(sigs, sig)
+	}
+	sort.Strings(sigs)
+	for _, sig := range sigs {
+		buf = append(buf, sig...)
+		buf = be.AppendUint32(buf, uint32(len(idx.Extensions[sig])))
+		buf = append(buf, idx.Extensions[sig]...)
+	}
+
+	h := format.New()
+	h.Write(buf)
+	return h.Sum(buf), nil
+}
+
+func decodeIndexEntry(data []byte, version uint32, prevName string, format ObjectFormat) (*IndexEntry, int, error) {
+	fixed := 40 + format.Size + 2
+	if len(data) < fixed {
+		return nil, 0, errors.New("entry is truncated")
+	}
+	be := binary.BigEndian
+	e := &IndexEntry{
+		ModifiedAt: time.Unix(int64(be.Uint32(data[8:12])), int64(be.Uint32(data[12:16]))),
+		Mode:       be.Uint32(data[24:28]),
+		UID:        be.Uint32(data[28:32]),
+		GID:        be.Uint32(data[32:36]),
+		Size:       be.Uint32(data[36:40]),
+		Hash:       hex.EncodeToString(data[40 : 40+format.Size]),
+	}
+	flags := be.Uint16(data[40+format.Size : fixed])
+	e.Stage = int(flags>>12) & 0x3
+	if flags&indexEntryExtended != 0 && version >= 3 {
+		fixed += 2
+		if len(data) < fixed {
+			return nil, 0, errors.New("entry is truncated")
+		}
+	}
+
+	rest := data[fixed:]
+	if version == 4 {
+		strip, n := decodeOffsetVarint(rest)
+		if n <= 0 || strip > len(prevName) {
+			return nil, 0, errors.New("malformed entry name")
+		}
+		nul := bytes.IndexByte(rest[n:], 0)
+		if nul < 0 {
+			return nil, 0, errors.New("entry name is not terminated")
+		}
+		e.Name = prevName[:len(prevName)-strip] + string(rest[n:n+nul])
+		return e, fixed + n + nul + 1, nil
+	}
+
+	nameLen := int(flags & indexNameMask)
+	if nameLen == indexNameMask {
+		nameLen = bytes.IndexByte(rest, 0)
+	}
+	if nameLen < 0 || nameLen > len(rest) {
+		return nil, 0, errors.New("malformed entry name")
+	}
+	e.Name = string(rest[:nameLen])
+	// entries are padded with 1-8 NUL bytes to a multiple of 8
+	size := (fixed + nameLen + 8) &^ 7
+	if size > len(data) {
+		return nil, 0, errors.New("entry is truncated")
+	}
+	return e, size, nil
+}
+
+// decodeOffsetVarint decodes the variable length integers used by index v4
+// and the OFS_DELTA pack entries, returning the value and bytes consumed.
+func decodeOffsetVarint(data []byte) (int, int) {
+	if len(data) == 0 {
+		return 0, 0
+	}
+	c := data[0]
+	val := int(c & 0x7f)
+	n := 1
+	for c&0x80 != 0 {
+		if n >= len(data) {
+			return 0, 0
+		}
+		if val > math.MaxInt>>8 {
+			return 0, 0
+		}
+		c = data[n]
+		n++
+		val = ((val + 1) << 7) | int(c&0x7f)
+	}
+	return val, n
+}
@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodeIndex(t *testing.T) {
+	longName := strings.Repeat("d/", 2100) + "f"
+	for _, format := range []ObjectFormat{SHA1, SHA256} {
+		t.Run(format.Name, func(t *testing.T) {
+			blob := format.HashObject("blob", []byte("hello\n"))
+			idx := &Index{
+				Version: 2,
+				Entries: []*IndexEntry{
+					{Name: "b", Hash: blob, Mode: 0100644, Size: 6, ModifiedAt: time.Unix(1700000000, 5)},
+					{Name: "a/c", Hash: blob, Mode: 0100755, Stage: 3},
+					{Name: "a/c", Hash: blob, Mode: 0100644, Stage: 1},
+					{Name: "a-b", Hash: blob, Mode: SymlinkMode},
+					{Name: longName, Hash: blob, Mode: 0100644},
+				},
+				Extensions: map[string][]byte{"TREE": {1, 2, 3}, "UNTR": {}},
+			}
+			data, err := EncodeIndex(idx, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := format.New()
+			h.Write(data[:len(data)-format.Size])
+			if !bytes.Equal(h.Sum(nil), data[len(data)-format.Size:]) {
+				t.Error("index doesn't end with its checksum")
+			}
+
+			got, err := DecodeIndex(data, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []IndexEntry{
+				{Name: "a-b", Hash: blob, Mode: SymlinkMode},
+				{Name: "a/c", Hash: blob, Mode: 0100644, Stage: 1},
+				{Name: "a/c", Hash: blob, Mode: 0100755, Stage: 3},
+				{Name: "b", Hash: blob, Mode: 0100644, Size: 6, ModifiedAt: time.Unix(1700000000, 5)},
+				{Name: longName, Hash: blob, Mode: 0100644},
+			}
+			if len(got.Entries) != len(want) {
+				t.Fatalf("decoded %d entries, want %d", len(got.Entries), len(want))
+			}
+			for i, e := range got.Entries {
+				w := want[i]
+				if w.ModifiedAt.IsZero() {
+					w.ModifiedAt = time.Unix(0, 0)
+				}
+				if e.Name != w.Name || e.Hash != w.Hash || e.Mode != w.Mode || e.Stage != w.Stage || e.Size != w.Size || !e.ModifiedAt.Equal(w.ModifiedAt) {
+					t.Errorf("entry %d = %+v, want %+v", i, *e, w)
+				}
+			}
+			if len(got.Extensions) != 2 || !bytes.Equal(got.Extensions["TREE"], []byte{1, 2, 3}) {
+				t.Errorf("extensions = %v", got.Extensions)
+			}
+		})
+	}
+}
+
+func TestEncodeIndexInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry IndexEntry
+		want  string
+	}{
+		{"short object name", IndexEntry{Name: "a", Hash: "abcd"}, "invalid object name"},
+		{"object name of the other format", IndexEntry{Name: "a", Hash: SHA256.HashObject("blob", nil)}, "invalid object name"},
+		{"stage", IndexEntry{Name: "a", Hash: SHA1.HashObject("blob", nil), Stage: 4}, "invalid stage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := EncodeIndex(&Index{Version: 2, Entries: []*IndexEntry{&tt.entry}}, SHA1)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("EncodeIndex() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
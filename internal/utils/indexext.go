@@ -0,0 +1,260 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CacheTree returns the tree objects recorded in the TREE (cache tree)
+// extension. Invalidated entries don't have a tree and are left out.
+func (idx *Index) CacheTree(format ObjectFormat) ([]string, error) {
+	data := idx.Extensions["TREE"]
+	var hashes []string
+	for len(data) > 0 {
+		nul := bytes.IndexByte(data, 0)
+		if nul < 0 {
+			return hashes, errors.New("malformed cache tree path")
+		}
+		data = data[nul+1:]
+		nl := bytes.IndexByte(data, '\n')
+		if nl < 0 {
+			return hashes, errors.New("malformed cache tree entry")
+		}
+		counts := strings.Fields(string(data[:nl]))
+		data = data[nl+1:]
+		if len(counts) != 2 {
+			return hashes, errors.New("malformed cache tree entry")
+		}
+		entries, err := strconv.Atoi(counts[0])
+		if err != nil {
+			return hashes, fmt.Errorf("malformed cache tree entry count: %w", err)
+		}
+		if entries < 0 {
+			continue
+		}
+		if len(data) < format.Size {
+			return hashes, errors.New("cache tree is truncated")
+		}
+		hashes = append(hashes, hex.EncodeToString(data[:format.Size]))
+		data = data[format.Size:]
+	}
+	return hashes, nil
+}
+
+// Untracked returns the paths of the untracked files and directories recorded
+// in the UNTR (untracked cache) extension. Directories end in a slash.
+func (idx *Index) Untracked(format ObjectFormat) ([]string, error) {
+	data, ok := idx.Extensions["UNTR"]
+	if !ok {
+		return nil, nil
+	}
+	r := &extReader{data: data}
+	// environment the cache is valid for
+	r.skip(r.varint())
+	// stat data of info/exclude and core.excludesFile, dir flags, and the hashes
+	// of both exclude files
+	r.skip(2*36 + 4 + 2*format.Size)
+	r.str() // per-directory exclude file name
+	dirs := r.varint()
+	if r.err != nil || dirs == 0 {
+		return nil, r.err
+	}
+
+	var untracked []string
+	var readDir func(prefix string)
+	readDir = func(prefix string) {
+		entries, subdirs := r.varint(), r.varint()
+		name := r.str()
+		if r.err != nil {
+			return
+		}
+		if name != "" {
+			prefix += name + "/"
+		}
+		for i := 0; i < entries && r.err == nil; i++ {
+			untracked = append(untracked, prefix+r.str())
+		}
+		for i := 0; i < subdirs && r.err == nil; i++ {
+			readDir(prefix)
+		}
+	}
+	readDir("")
+	return untracked, r.err
+}
+
+// SharedIndex returns the name of the shared index the index is split from,
+// as recorded in its link extension, or an empty string.
+func (idx *Index) SharedIndex(format ObjectFormat) string {
+	data, ok := idx.Extensions["link"]
+	if !ok || len(data) < format.Size {
+		return ""
+	}
+	return hex.EncodeToString(data[:format.Size])
+}
+
+// MergeSharedIndex applies a split index on top of the shared index it was
+// split from, leaving the merged entries in idx.
+func (idx *Index) MergeSharedIndex(shared *Index, format ObjectFormat) error {
+	data := idx.Extensions["link"]
+	if len(data) < format.Size {
+		return errors.New("index has no link extension")
+	}
+	var deleted, replaced []int
+	if len(data) > format.Size {
+		var n int
+		var err error
+		// both bitmaps index into the shared entries
+		if deleted, n, err = decodeEWAH(data[format.Size:], len(shared.Entries)); err != nil {
+			return fmt.Errorf("delete bitmap: %w", err)
+		}
+		if replaced, _, err = decodeEWAH(data[format.Size+n:], len(shared.Entries)); err != nil {
+			return fmt.Errorf("replace bitmap: %w", err)
+		}
+	}
+
+	base := make([]*IndexEntry, len(shared.Entries))
+	copy(base, shared.Entries)
+	// replacing entries come first, in the order of the shared entries they
+	// replace, and are stored without a name
+	next := 0
+	for _, pos := range replaced {
+		if pos >= len(base) || next >= len(idx.Entries) {
+			return errors.New("replace bitmap is out of bounds")
+		}
+		e := *idx.Entries[next]
+		e.Name = base[pos].Name
+		base[pos] = &e
+		next++
+	}
+	for _, pos := range deleted {
+		if pos >= len(base) {
+			return errors.New("delete bitmap is out of bounds")
+		}
+		base[pos] = nil
+	}
+
+	var entries []*IndexEntry
+	for _, e := range base {
+		if e != nil {
+			entries = append(entries, e)
+		}
+	}
+	entries = append(entries, idx.Entries[next:]...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		return entries[i].Stage < entries[j].Stage
+	})
+	idx.Entries = entries
+	return nil
+}
+
+// decodeEWAH decodes a git EWAH bitmap of at most limit bits, returning the
+// positions of the set bits and the number of bytes consumed.
+func decodeEWAH(data []byte, limit int) ([]int, int, error) {
+	be := binary.BigEndian
+	if len(data) < 8 {
+		return nil, 0, errors.New("bitmap is truncated")
+	}
+	bitCount := int(be.Uint32(data[0:4]))
+	if bitCount > limit {
+		return nil, 0, fmt.Errorf("bitmap has %d bits, but there are only %d entries", bitCount, limit)
+	}
+	words := int(be.Uint32(data[4:8]))
+	size := 8 + words*8 + 4
+	if words < 0 || len(data) < size {
+		return nil, 0, errors.New("bitmap is truncated")
+	}
+
+	var bits []int
+	pos := 0
+	// the last word may be padded, but no bit past bitCount may be set
+	end := (bitCount + 63) / 64 * 64
+	for i := 0; i < words; {
+		rlw := be.Uint64(data[8+i*8:])
+		run := int((rlw >> 1) & 0xffffffff)
+		literals := int(rlw >> 33)
+		if run > (end-pos)/64 {
+			return nil, 0, errors.New("bitmap is out of bounds")
+		}
+		if rlw&1 != 0 {
+			if pos+run*64 > bitCount {
+				return nil, 0, errors.New("bitmap is out of bounds")
+			}
+			for b := 0; b < run*64; b++ {
+				bits = append(bits, pos+b)
+			}
+		}
+		pos += run * 64
+		i++
+		for j := 0; j < literals && i < words; j++ {
+			if pos >= end {
+				return nil, 0, errors.New("bitmap is out of bounds")
+			}
+			word := be.Uint64(data[8+i*8:])
+			for b := 0; b < 64; b++ {
+				if word&(1<<uint(b)) != 0 {
+					if pos+b >= bitCount {
+						return nil, 0, errors.New("bitmap is out of bounds")
+					}
+					bits = append(bits, pos+b)
+				}
+			}
+			pos += 64
+			i++
+		}
+	}
+	return bits, size, nil
+}
+
+// extReader reads the fields of an index extension, remembering the first
+// error.
+type extReader struct {
+	data []byte
+	err  error
+}
+
+func (r *extReader) varint() int {
+	if r.err != nil {
+		return 0
+	}
+	val, n := decodeOffsetVarint(r.data)
+	if n <= 0 {
+		r.err = errors.New("extension is truncated")
+		return 0
+	}
+	r.data = r.data[n:]
+	return val
+}
+
+func (r *extReader) str() string {
+	if r.err != nil {
+		return ""
+	}
+	nul := bytes.IndexByte(r.data, 0)
+	if nul < 0 {
+		r.err = errors.New("extension is truncated")
+		return ""
+	}
+	s := string(r.data[:nul])
+	r.data = r.data[nul+1:]
+	return s
+}
+
+func (r *extReader) skip(n int) {
+	if r.err != nil {
+		return
+	}
+	if n < 0 || n > len(r.data) {
+		r.err = errors.New("extension is truncated")
+		return
+	}
+	r.data = r.data[n:]
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// ewah lays out an EWAH bitmap of bitCount bits from raw words.
+func ewah(bitCount uint32, words ...uint64) []byte {
+	data := binary.BigEndian.AppendUint32(nil, bitCount)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(words)))
+	for _, w := range words {
+		data = binary.BigEndian.AppendUint64(data, w)
+	}
+	// position of the last run length word
+	return binary.BigEndian.AppendUint32(data, 0)
+}
+
+// rlw builds a run length word of run words of bit, followed by literals
+// literal words.
+func rlw(bit uint64, run, literals uint64) uint64 {
+	return bit | run<<1 | literals<<33
+}
+
+func TestDecodeEWAH(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		limit   int
+		want    []int
+		wantErr string
+	}{
+		{"literal", ewah(64, rlw(0, 0, 1), 1<<1|1<<3), 64, []int{1, 3}, ""},
+		{"run of ones", ewah(128, rlw(1, 2, 0)), 128, seq(0, 128), ""},
+		{"run of zeros then literal", ewah(130, rlw(0, 2, 1), 1<<1), 200, []int{129}, ""},
+		{"padded last word", ewah(70, rlw(0, 1, 1), 1<<5), 70, []int{69}, ""},
+		{"empty", ewah(0), 0, nil, ""},
+		{"truncated header", []byte{0, 0, 0, 1}, 10, nil, "truncated"},
+		{"truncated words", ewah(64, rlw(0, 0, 1), 1)[:12], 64, nil, "truncated"},
+		{"more bits than entries", ewah(1000, rlw(0, 0, 1), 1), 10, nil, "only 10 entries"},
+		{"huge run of ones", ewah(64, rlw(1, 0xffffffff, 0)), 64, nil, "out of bounds"},
+		{"huge run of zeros", ewah(64, rlw(0, 0xffffffff, 1), 1), 64, nil, "out of bounds"},
+		{"set bit past the count", ewah(10, rlw(0, 0, 1), 1<<20), 64, nil, "out of bounds"},
+		{"literal past the count", ewah(64, rlw(0, 1, 1), 1), 64, nil, "out of bounds"},
+		{"run of ones past the count", ewah(100, rlw(1, 2, 0)), 128, nil, "out of bounds"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bits, n, err := decodeEWAH(tt.data, tt.limit)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("decodeEWAH() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != len(tt.data) {
+				t.Errorf("decodeEWAH() consumed %d bytes, want %d", n, len(tt.data))
+			}
+			if !reflect.DeepEqual(bits, tt.want) {
+				t.Errorf("decodeEWAH() = %v, want %v", bits, tt.want)
+			}
+		})
+	}
+}
+
+func seq(from, to int) []int {
+	var s []int
+	for i := from; i < to; i++ {
+		s = append(s, i)
+	}
+	return s
+}
@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LfsPointerMaxSize is the size above which a file can't be a git lfs pointer.
+const LfsPointerMaxSize = 1024
+
+// LfsPointer is what git lfs stores in the repository in place of a file, and
+// names the object holding its actual content.
+type LfsPointer struct {
+	Oid  string `json:"oid"`
+	Size int64  `json:"size"`
+}
+
+// ParseLfsPointer parses the content of a pointer file, and reports whether it
+// is one.
+func ParseLfsPointer(content []byte) (LfsPointer, bool) {
+	var p LfsPointer
+	if len(content) > LfsPointerMaxSize || !strings.HasPrefix(string(content), "version ") {
+		return p, false
+	}
+	var hasOid, hasSize bool
+	for _, line := range strings.Split(string(content), "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), " ")
+		switch key {
+		case "oid":
+			oid, ok := strings.CutPrefix(value, "sha256:")
+			if !ok || !SHA256.IsHash(oid) || strings.ToLower(oid) != oid {
+				return p, false
+			}
+			p.Oid, hasOid = oid, true
+		case "size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || size < 0 {
+				return p, false
+			}
+			p.Size, hasSize = size, true
+		}
+	}
+	return p, hasOid && hasSize
+}
+
+// LfsObjectPath returns the path of the object of a pointer, relative to the
+// git directory.
+func LfsObjectPath(oid string) string {
+	return "lfs/objects/" + oid[:2] + "/" + oid[2:4] + "/" + oid
+}
+
+// Verify reports whether content is the object the pointer names.
+func (p LfsPointer) Verify(content []byte) bool {
+	sum := sha256.Sum256(content)
+	return int64(len(content)) == p.Size && hex.EncodeToString(sum[:]) == p.Oid
+}
+
+// VerifyFile checks the file at path against the size and oid of the pointer,
+// hashing it as it's read.
+func (p LfsPointer) VerifyFile(path string) error {
+	sum, n, err := hashLfsFile(path)
+	if err != nil {
+		return err
+	}
+	if n != p.Size {
+		return fmt.Errorf("size mismatch, expected %d but got %d", p.Size, n)
+	}
+	if sum != p.Oid {
+		return fmt.Errorf("oid mismatch, file hashes to %s", sum)
+	}
+	return nil
+}
+
+// VerifyLfsObject checks that the file at path hashes to oid, for objects
+// whose pointer, and so size, isn't at hand.
+func VerifyLfsObject(path, oid string) error {
+	sum, _, err := hashLfsFile(path)
+	if err != nil {
+		return err
+	}
+	if sum != oid {
+		return fmt.Errorf("oid mismatch, file hashes to %s", sum)
+	}
+	return nil
+}
+
+func hashLfsFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// LfsHostAllowed reports whether host is one of hosts, or hosts allows any
+// with "*".
+func LfsHostAllowed(hosts []string, host string) bool {
+	for _, h := range hosts {
+		if h == "*" || (host != "" && strings.EqualFold(h, host)) {
+			return true
+		}
+	}
+	return false
+}
+
+// LfsBatchRequest is the request body of the batch API.
+type LfsBatchRequest struct {
+	Operation string       `json:"operation"`
+	Transfers []string     `json:"transfers,omitempty"`
+	Objects   []LfsPointer `json:"objects"`
+	HashAlgo  string       `json:"hash_algo,omitempty"`
+}
+
+// LfsAction tells where to transfer an object from or to.
+type LfsAction struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header,omitempty"`
+}
+
+// LfsObjectError is the error the batch API returns for a single object.
+type LfsObjectError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// LfsBatchObject is an object in the response of the batch API.
+type LfsBatchObject struct {
+	LfsPointer
+	Actions map[string]LfsAction `json:"actions,omitempty"`
+	Error   *LfsObjectError      `json:"error,omitempty"`
+}
+
+// LfsBatchResponse is the response body of the batch API.
+type LfsBatchResponse struct {
+	Transfer string           `json:"transfer,omitempty"`
+	Objects  []LfsBatchObject `json:"objects"`
+	HashAlgo string           `json:"hash_algo,omitempty"`
+}
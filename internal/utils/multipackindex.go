@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// MultiPackIndex is a decoded multi-pack-index file.
+type MultiPackIndex struct {
+	// PackNames are the names of the pack indexes covered, e.g. pack-<hash>.idx
+	PackNames []string
+	Hashes    []string
+}
+
+// ReadMultiPackIndex reads and decodes the multi-pack-index file at path.
+func ReadMultiPackIndex(path string, format ObjectFormat) (*MultiPackIndex, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeMultiPackIndex(data, format)
+}
+
+// DecodeMultiPackIndex decodes a multi-pack-index file, only keeping the pack
+// names and object names it lists.
+func DecodeMultiPackIndex(data []byte, format ObjectFormat) (*MultiPackIndex, error) {
+	// header: signature, version, hash version, number of chunks, number of
+	// base files, number of packs
+	if len(data) < 12 || string(data[:4]) != "MIDX" {
+		return nil, errors.New("malformed multi-pack-index signature")
+	}
+	if v := data[4]; v != 1 && v != 2 {
+		return nil, fmt.Errorf("unsupported multi-pack-index version %d", v)
+	}
+	chunks, err := ReadChunks(data, 12, int(data[6]))
+	if err != nil {
+		return nil, err
+	}
+	numPacks := int(binary.BigEndian.Uint32(data[8:12]))
+
+	midx := &MultiPackIndex{Hashes: ReadHashes(chunks["OIDL"], format)}
+	// pack names are NUL terminated, the chunk is padded to a multiple of 4
+	for _, name := range bytes.Split(chunks["PNAM"], []byte{0}) {
+		if len(name) > 0 {
+			midx.PackNames = append(midx.PackNames, string(name))
+		}
+	}
+	if len(midx.PackNames) != numPacks {
+		return midx, fmt.Errorf("expected %d pack names, found %d", numPacks, len(midx.PackNames))
+	}
+	return midx, nil
+}
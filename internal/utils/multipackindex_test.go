@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDecodeMultiPackIndex(t *testing.T) {
+	hash := bytes.Repeat([]byte{0xab}, 20)
+	header := func(version, chunks, packs byte) []byte {
+		return []byte{'M', 'I', 'D', 'X', version, 1, chunks, 0, 0, 0, 0, packs}
+	}
+	pnam := chunk{"PNAM", []byte("pack-a.idx\x00pack-b.idx\x00\x00\x00")}
+	oidl := chunk{"OIDL", append(append([]byte{}, hash...), hash...)}
+
+	tests := []struct {
+		name      string
+		data      []byte
+		wantPacks int
+		wantObjs  int
+		wantErr   string
+	}{
+		{"valid", chunkFile(header(1, 2, 2), pnam, oidl), 2, 2, ""},
+		{"empty", nil, 0, 0, "signature"},
+		{"truncated header", []byte("MIDX\x01"), 0, 0, "signature"},
+		{"bad signature", chunkFile(append([]byte("XDIM"), header(1, 2, 2)[4:]...), pnam, oidl), 0, 0, "signature"},
+		{"version 3", chunkFile(header(3, 2, 2), pnam, oidl), 0, 0, "version"},
+		{"truncated chunks", chunkFile(header(1, 2, 2), pnam, oidl)[:30], 0, 0, "truncated"},
+		{"missing pack names", chunkFile(header(1, 1, 2), oidl), 0, 2, "expected 2 pack names"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			midx, err := DecodeMultiPackIndex(tt.data, SHA1)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("DecodeMultiPackIndex() error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if midx == nil {
+				return
+			}
+			if len(midx.PackNames) != tt.wantPacks || len(midx.Hashes) != tt.wantObjs {
+				t.Fatalf("DecodeMultiPackIndex() = %v %v", midx.PackNames, midx.Hashes)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// how alike the words of two responses have to be for them to be the same page
+const notFoundSimilarity = 0.9
+
+var digitWords = regexp.MustCompile(`[[:alnum:]]*[[:digit:]][[:alnum:]]*`)
+
+// NotFoundPages holds fingerprints of what a server answers with 200 for paths
+// that don't exist, such as custom error pages or the index of a single page
+// app, so that later responses like them can be told apart from actual files.
+type NotFoundPages struct {
+	mu    sync.RWMutex
+	pages []notFoundPage
+}
+
+type notFoundPage struct {
+	size  int
+	hash  [sha1.Size]byte
+	words map[string]bool
+}
+
+// Reset forgets every fingerprint, for when another server is dumped.
+func (n *NotFoundPages) Reset() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.pages = nil
+}
+
+// Add fingerprints the body the server answered for uri, which doesn't exist.
+func (n *NotFoundPages) Add(uri string, body []byte) {
+	body = normalizeNotFound(uri, body)
+	page := notFoundPage{size: len(body), hash: sha1.Sum(body), words: words(body)}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, p := range n.pages {
+		if p.hash == page.hash {
+			return
+		}
+	}
+	n.pages = append(n.pages, page)
+}
+
+// Len returns the number of distinct pages fingerprinted.
+func (n *NotFoundPages) Len() int {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return len(n.pages)
+}
+
+// Match reports whether the body the server answered for uri is the same
+// page as one of the fingerprinted ones, either exactly or with only a few
+// words changed. Occurrences of the requested path are ignored, as error pages
+// often echo it, and so are numbers and words with digits in them, which are
+// usually timestamps, request ids or nonces.
+func (n *NotFoundPages) Match(uri string, body []byte) bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if len(n.pages) == 0 {
+		return false
+	}
+	body = normalizeNotFound(uri, body)
+	hash := sha1.Sum(body)
+	var bodyWords map[string]bool
+	for _, p := range n.pages {
+		if p.hash == hash {
+			return true
+		}
+		// only pages of about the same size are compared word by word
+		small, large := min(p.size, len(body)), max(p.size, len(body))
+		if large == 0 || float64(small)/float64(large) < notFoundSimilarity {
+			continue
+		}
+		if bodyWords == nil {
+			bodyWords = words(body)
+		}
+		if jaccard(p.words, bodyWords) >= notFoundSimilarity {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeNotFound(uri string, body []byte) []byte {
+	if u, err := url.Parse(uri); err == nil {
+		for _, p := range []string{u.EscapedPath(), u.Path} {
+			if p = strings.Trim(p, "/"); p == "" {
+				continue
+			}
+			body = bytes.ReplaceAll(body, []byte(p), nil)
+			body = bytes.ReplaceAll(body, []byte(path.Base(p)), nil)
+		}
+	}
+	return digitWords.ReplaceAll(body, []byte("0"))
+}
+
+func words(body []byte) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range bytes.FieldsFunc(body, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		set[string(w)] = true
+	}
+	return set
+}
+
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	shared := 0
+	for w := range a {
+		if b[w] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// ObjectFormat describes the hash algorithm a repository uses to name its
+// objects, as configured by extensions.objectFormat.
+type ObjectFormat struct {
+	Name    string
+	Size    int
+	HexSize int
+	newHash func() hash.Hash
+}
+
+var (
+	SHA1   = ObjectFormat{Name: "sha1", Size: sha1.Size, HexSize: sha1.Size * 2, newHash: sha1.New}
+	SHA256 = ObjectFormat{Name: "sha256", Size: sha256.Size, HexSize: sha256.Size * 2, newHash: sha256.New}
+)
+
+// ObjectFormatFromConfig reads extensions.objectFormat from the contents of a
+// git config file, defaulting to SHA1 if it isn't set.
+func ObjectFormatFromConfig(content []byte) (ObjectFormat, error) {
+	cfg, err := ini.LoadSources(ini.LoadOptions{Insensitive: true}, content)
+	if err != nil {
+		return SHA1, err
+	}
+	name := strings.ToLower(cfg.Section("extensions").Key("objectformat").String())
+	switch name {
+	case "", SHA1.Name:
+		return SHA1, nil
+	case SHA256.Name:
+		return SHA256, nil
+	}
+	return SHA1, fmt.Errorf("unknown object format %q", name)
+}
+
+// New returns a new hash.Hash computing object names in this format.
+func (f ObjectFormat) New() hash.Hash {
+	return f.newHash()
+}
+
+// IsHash reports whether s is a hex encoded object name in this format.
+func (f ObjectFormat) IsHash(s string) bool {
+	if len(s) != f.HexSize {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// HashObject computes the name of an object with the given type and content.
+func (f ObjectFormat) HashObject(typ string, content []byte) string {
+	h := f.New()
+	fmt.Fprintf(h, "%s %d\x00", typ, len(content))
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -1,25 +1,214 @@
 package utils
 
 import (
-	"github.com/go-git/go-git/v5/plumbing/object"
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
 )
 
-func GetReferencedHashes(obj object.Object) []string {
+// LooseObjectPath returns the path of a loose object relative to the .git
+// directory.
+func LooseObjectPath(hash string) string {
+	return fmt.Sprintf("objects/%s/%s", hash[:2], hash[2:])
+}
+
+// DecodeLooseObject inflates the contents of a loose object file and returns
+// the object type and content.
+func DecodeLooseObject(data []byte) (string, []byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return "", nil, err
+	}
+	defer zr.Close()
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return "", nil, err
+	}
+	nul := bytes.IndexByte(raw, 0)
+	if nul < 0 {
+		return "", nil, errors.New("malformed object header")
+	}
+	var typ string
+	var size int
+	if _, err := fmt.Sscanf(string(raw[:nul]), "%s %d", &typ, &size); err != nil {
+		return "", nil, fmt.Errorf("malformed object header: %w", err)
+	}
+	content := raw[nul+1:]
+	if len(content) != size {
+		return "", nil, fmt.Errorf("object size mismatch, header says %d but got %d", size, len(content))
+	}
+	return typ, content, nil
+}
+
+// VerifyLooseObject decodes the contents of a loose object file and checks
+// that the object hashes to its name.
+func VerifyLooseObject(data []byte, hash string, format ObjectFormat) (string, []byte, error) {
+	typ, content, err := DecodeLooseObject(data)
+	if err != nil {
+		return "", nil, err
+	}
+	if actual := format.HashObject(typ, content); actual != hash {
+		return "", nil, fmt.Errorf("object hashes to %s", actual)
+	}
+	return typ, content, nil
+}
+
+// ReadLooseObject reads and inflates the loose object stored at path.
+func ReadLooseObject(path string) (string, []byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	return DecodeLooseObject(data)
+}
+
+// ReadLooseObjectHeader returns the type and size of the loose object stored
+// at path, only inflating its header.
+func ReadLooseObjectHeader(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	zr, err := zlib.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return "", 0, err
+	}
+	defer zr.Close()
+	header, err := bufio.NewReader(io.LimitReader(zr, 32)).ReadString(0)
+	if err != nil {
+		return "", 0, errors.New("malformed object header")
+	}
+	var typ string
+	var size int64
+	if _, err := fmt.Sscanf(strings.TrimSuffix(header, "\x00"), "%s %d", &typ, &size); err != nil {
+		return "", 0, fmt.Errorf("malformed object header: %w", err)
+	}
+	if size < 0 {
+		return "", 0, errors.New("malformed object header")
+	}
+	return typ, size, nil
+}
+
+// WriteLooseObject stores an object in the objects directory of gitDir and
+// returns its name. Objects that already exist aren't rewritten.
+func WriteLooseObject(gitDir, typ string, content []byte, format ObjectFormat) (string, error) {
+	hash := format.HashObject(typ, content)
+	path := URL(gitDir, LooseObjectPath(hash))
+	if Exists(path) {
+		return hash, nil
+	}
+
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	fmt.Fprintf(zw, "%s %d\x00", typ, len(content))
+	zw.Write(content)
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	if err := CreateParentFolders(path); err != nil {
+		return "", err
+	}
+	return hash, os.WriteFile(path, buf.Bytes(), 0444)
+}
+
+// GitlinkMode is the mode of tree and index entries pointing to a commit in a
+// submodule.
+const GitlinkMode = 0160000
+
+// SymlinkMode is the mode of tree and index entries for symbolic links, whose
+// blob holds the target of the link.
+const SymlinkMode = 0120000
+
+// TreeEntry is a single entry of a tree object.
+type TreeEntry struct {
+	Mode uint32
+	Name string
+	Hash string
+}
+
+// ParseTree parses the entries of a tree object.
+func ParseTree(content []byte, format ObjectFormat) ([]TreeEntry, error) {
+	var entries []TreeEntry
+	for len(content) > 0 {
+		sp := bytes.IndexByte(content, ' ')
+		nul := bytes.IndexByte(content, 0)
+		if sp < 0 || nul < sp || len(content) < nul+1+format.Size {
+			return entries, errors.New("malformed tree entry")
+		}
+		mode, err := strconv.ParseUint(string(content[:sp]), 8, 32)
+		if err != nil {
+			return entries, fmt.Errorf("malformed tree entry mode: %w", err)
+		}
+		entries = append(entries, TreeEntry{
+			Mode: uint32(mode),
+			Name: string(content[sp+1 : nul]),
+			Hash: hex.EncodeToString(content[nul+1 : nul+1+format.Size]),
+		})
+		content = content[nul+1+format.Size:]
+	}
+	return entries, nil
+}
+
+// ParseCommit returns the tree and parents of a commit object.
+func ParseCommit(content []byte, format ObjectFormat) (string, []string) {
+	var tree string
+	var parents []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if line == "" {
+			// end of headers
+			break
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 || !format.IsHash(fields[1]) {
+			continue
+		}
+		switch fields[0] {
+		case "tree":
+			tree = fields[1]
+		case "parent":
+			parents = append(parents, fields[1])
+		}
+	}
+	return tree, parents
+}
+
+// GetRawReferencedHashes returns the hashes of the objects referenced by an
+// object, given its type and raw content. Submodule commits are left out, as
+// they live in a different repository.
+func GetRawReferencedHashes(typ string, content []byte, format ObjectFormat) []string {
 	var hashes []string
-	switch o := obj.(type) {
-	case *object.Commit:
-		hashes = append(hashes, o.TreeHash.String())
-		for _, p := range o.ParentHashes {
-			hashes = append(hashes, p.String())
-		}
-	case *object.Tree:
-		for _, e := range o.Entries {
-			hashes = append(hashes, e.Hash.String())
-		}
-	case *object.Blob:
-		// pass
-	case *object.Tag:
-		hashes = append(hashes, o.Target.String())
+	switch typ {
+	case "commit":
+		tree, parents := ParseCommit(content, format)
+		if tree != "" {
+			hashes = append(hashes, tree)
+		}
+		hashes = append(hashes, parents...)
+	case "tag":
+		for _, line := range strings.Split(string(content), "\n") {
+			if line == "" {
+				break
+			}
+			fields := strings.SplitN(line, " ", 2)
+			if len(fields) == 2 && fields[0] == "object" && format.IsHash(fields[1]) {
+				hashes = append(hashes, fields[1])
+			}
+		}
+	case "tree":
+		entries, _ := ParseTree(content, format)
+		for _, e := range entries {
+			if e.Mode != GitlinkMode {
+				hashes = append(hashes, e.Hash)
+			}
+		}
 	}
 	return hashes
 }
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadLooseObjectHeader(t *testing.T) {
+	dir := t.TempDir()
+	hash, err := WriteLooseObject(dir, "blob", bytes.Repeat([]byte("a"), 1<<20), SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	typ, size, err := ReadLooseObjectHeader(filepath.Join(dir, LooseObjectPath(hash)))
+	if err != nil || typ != "blob" || size != 1<<20 {
+		t.Fatalf("ReadLooseObjectHeader() = %s %d, %v", typ, size, err)
+	}
+
+	for name, data := range map[string][]byte{
+		"no header end": deflate(bytes.Repeat([]byte("a"), 100)),
+		"bad size":      deflate([]byte("blob -1\x00")),
+		"not deflated":  []byte("blob 1\x00a"),
+	} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, data, 0644)
+		if _, _, err := ReadLooseObjectHeader(path); err == nil {
+			t.Errorf("%s: ReadLooseObjectHeader() succeeded", name)
+		}
+	}
+}
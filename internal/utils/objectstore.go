@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ObjectStore reads objects from both the loose objects and the pack files of
+// a git directory. It is not safe for concurrent use.
+type ObjectStore struct {
+	gitDir string
+	format ObjectFormat
+	packs  []*Pack
+}
+
+// OpenObjectStore opens the objects in gitDir. Pack files that can't be
+// opened are skipped, the returned error lists them.
+func OpenObjectStore(gitDir string, format ObjectFormat) (*ObjectStore, error) {
+	s := &ObjectStore{gitDir: gitDir, format: format}
+	packFiles, err := filepath.Glob(URL(gitDir, "objects/pack/pack-*.pack"))
+	if err != nil {
+		return s, err
+	}
+	var errs []error
+	for _, packFile := range packFiles {
+		pack, err := OpenPack(packFile, strings.TrimSuffix(packFile, ".pack")+".idx", format)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", packFile, err))
+			continue
+		}
+		s.packs = append(s.packs, pack)
+	}
+	return s, errors.Join(errs...)
+}
+
+// Close closes all pack files of the store.
+func (s *ObjectStore) Close() {
+	for _, pack := range s.packs {
+		pack.Close()
+	}
+}
+
+// Has reports whether the object is present, either loose or packed.
+func (s *ObjectStore) Has(hash string) bool {
+	if !s.format.IsHash(hash) {
+		return false
+	}
+	if Exists(URL(s.gitDir, LooseObjectPath(hash))) {
+		return true
+	}
+	for _, pack := range s.packs {
+		if _, ok := pack.Index.Offsets[hash]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Object returns the type and content of an object.
+func (s *ObjectStore) Object(hash string) (string, []byte, error) {
+	if !s.format.IsHash(hash) {
+		return "", nil, fmt.Errorf("invalid object name %q", hash)
+	}
+	loosePath := URL(s.gitDir, LooseObjectPath(hash))
+	if Exists(loosePath) {
+		return ReadLooseObject(loosePath)
+	}
+	for _, pack := range s.packs {
+		if _, ok := pack.Index.Offsets[hash]; ok {
+			return pack.Object(hash)
+		}
+	}
+	return "", nil, fmt.Errorf("object %s not found", hash)
+}
+
+// ObjectType returns the type of an object, without reading the whole object.
+func (s *ObjectStore) ObjectType(hash string) (string, error) {
+	typ, _, err := s.ObjectHeader(hash)
+	return typ, err
+}
+
+// ObjectHeader returns the type and size of an object, without reading the
+// whole object.
+func (s *ObjectStore) ObjectHeader(hash string) (string, int64, error) {
+	if !s.format.IsHash(hash) {
+		return "", 0, fmt.Errorf("invalid object name %q", hash)
+	}
+	loosePath := URL(s.gitDir, LooseObjectPath(hash))
+	if Exists(loosePath) {
+		return ReadLooseObjectHeader(loosePath)
+	}
+	for _, pack := range s.packs {
+		if _, ok := pack.Index.Offsets[hash]; ok {
+			return pack.ObjectHeader(hash)
+		}
+	}
+	return "", 0, fmt.Errorf("object %s not found", hash)
+}
+
+// Hashes lists the names of all objects in the store.
+func (s *ObjectStore) Hashes() ([]string, error) {
+	seen := make(map[string]bool)
+	var hashes []string
+	looseObjs, err := filepath.Glob(URL(s.gitDir, "objects/[0-9a-f][0-9a-f]/*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range looseObjs {
+		hash := filepath.Base(filepath.Dir(path)) + filepath.Base(path)
+		if s.format.IsHash(hash) && !seen[hash] {
+			seen[hash] = true
+			hashes = append(hashes, hash)
+		}
+	}
+	for _, pack := range s.packs {
+		for _, hash := range pack.Index.Hashes {
+			if !seen[hash] {
+				seen[hash] = true
+				hashes = append(hashes, hash)
+			}
+		}
+	}
+	return hashes, nil
+}
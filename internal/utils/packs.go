@@ -0,0 +1,451 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var packIndexSignature = []byte{0xff, 't', 'O', 'c'}
+
+var packObjectTypes = map[byte]string{1: "commit", 2: "tree", 3: "blob", 4: "tag"}
+
+const (
+	packOfsDelta = 6
+	packRefDelta = 7
+	// maximum amount of bytes of resolved objects to keep around for deltas
+	packCacheSize = 64 << 20
+	// largest object we're willing to inflate, anything claiming to be larger
+	// is treated as corrupt rather than trusted with an allocation
+	maxObjectSize = 1 << 30
+)
+
+// PackIndex is a decoded version 2 pack index (.idx) file.
+type PackIndex struct {
+	Hashes   []string
+	Offsets  map[string]int64
+	PackHash string
+}
+
+// ReadPackIndex reads and decodes the pack index file at path.
+func ReadPackIndex(path string, format ObjectFormat) (*PackIndex, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return DecodePackIndex(data, format)
+}
+
+// DecodePackIndex decodes a version 2 pack index.
+func DecodePackIndex(data []byte, format ObjectFormat) (*PackIndex, error) {
+	be := binary.BigEndian
+	if len(data) < 8+256*4 || !bytes.Equal(data[:4], packIndexSignature) {
+		return nil, errors.New("malformed pack index signature")
+	}
+	if v := be.Uint32(data[4:8]); v != 2 {
+		return nil, fmt.Errorf("unsupported pack index version %d", v)
+	}
+	count := int(be.Uint32(data[8+255*4 : 8+256*4]))
+	namesStart := 8 + 256*4
+	crcStart := namesStart + count*format.Size
+	offStart := crcStart + count*4
+	bigOffStart := offStart + count*4
+	if len(data) < bigOffStart+2*format.Size {
+		return nil, errors.New("pack index is truncated")
+	}
+
+	idx := &PackIndex{
+		Hashes:   ReadHashes(data[namesStart:crcStart], format),
+		Offsets:  make(map[string]int64, count),
+		PackHash: hex.EncodeToString(data[len(data)-2*format.Size : len(data)-format.Size]),
+	}
+	for i, hash := range idx.Hashes {
+		off := int64(be.Uint32(data[offStart+i*4:]))
+		if off&0x80000000 != 0 {
+			pos := bigOffStart + int(off&0x7fffffff)*8
+			if pos+8 > len(data)-2*format.Size {
+				return nil, fmt.Errorf("large offset for %s is out of bounds", hash)
+			}
+			off = int64(be.Uint64(data[pos:]))
+		}
+		idx.Offsets[hash] = off
+	}
+	return idx, nil
+}
+
+// VerifyChecksumTrailer checks that the file at path ends with the checksum of
+// everything before it, as the pack files, pack indexes and other files git
+// writes do. The file is hashed as it's read, so it can be of any size.
+func VerifyChecksumTrailer(path string, format ObjectFormat) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < int64(format.Size) {
+		return errors.New("file is too short for a checksum")
+	}
+	h := format.New()
+	if _, err := io.CopyN(h, f, info.Size()-int64(format.Size)); err != nil {
+		return err
+	}
+	trailer := make([]byte, format.Size)
+	if _, err := io.ReadFull(f, trailer); err != nil {
+		return err
+	}
+	if sum := h.Sum(nil); !bytes.Equal(sum, trailer) {
+		return fmt.Errorf("checksum mismatch, file says %x but hashes to %x", trailer, sum)
+	}
+	return nil
+}
+
+// ReadChecksumTrailer returns the checksum at the end of the file at path.
+// With previous set, it returns the one right before it instead, which for a
+// pack index is the checksum of its pack.
+func ReadChecksumTrailer(path string, format ObjectFormat, previous bool) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	offset := int64(format.Size)
+	if previous {
+		offset *= 2
+	}
+	if _, err := f.Seek(-offset, io.SeekEnd); err != nil {
+		return "", err
+	}
+	trailer := make([]byte, format.Size)
+	if _, err := io.ReadFull(f, trailer); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(trailer), nil
+}
+
+// Pack reads objects from a pack file, resolving deltas, using the offsets
+// from its index.
+type Pack struct {
+	Index *PackIndex
+
+	f         *os.File
+	format    ObjectFormat
+	cache     map[int64]packObject
+	cacheSize int
+}
+
+type packObject struct {
+	typ     string
+	content []byte
+}
+
+// OpenPack opens the pack file at packPath for reading, along with the index
+// at idxPath.
+func OpenPack(packPath, idxPath string, format ObjectFormat) (*Pack, error) {
+	idx, err := ReadPackIndex(idxPath, format)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(packPath)
+	if err != nil {
+		return nil, err
+	}
+	return &Pack{Index: idx, f: f, format: format, cache: make(map[int64]packObject)}, nil
+}
+
+// Close closes the underlying pack file.
+func (p *Pack) Close() error {
+	return p.f.Close()
+}
+
+// Object returns the type and content of a packed object.
+func (p *Pack) Object(hash string) (string, []byte, error) {
+	off, ok := p.Index.Offsets[hash]
+	if !ok {
+		return "", nil, fmt.Errorf("object %s is not in pack", hash)
+	}
+	obj, err := p.objectAt(off, 0)
+	if err != nil {
+		return "", nil, err
+	}
+	return obj.typ, obj.content, nil
+}
+
+// ObjectType returns the type of a packed object without inflating it.
+func (p *Pack) ObjectType(hash string) (string, error) {
+	off, ok := p.Index.Offsets[hash]
+	if !ok {
+		return "", fmt.Errorf("object %s is not in pack", hash)
+	}
+	return p.typeAt(off)
+}
+
+// ObjectHeader returns the type and size of a packed object, only inflating
+// the start of it if it is a delta, where the size is found.
+func (p *Pack) ObjectHeader(hash string) (string, int64, error) {
+	off, ok := p.Index.Offsets[hash]
+	if !ok {
+		return "", 0, fmt.Errorf("object %s is not in pack", hash)
+	}
+	typ, dataOff, _, size, err := p.header(off)
+	if err != nil {
+		return "", 0, err
+	}
+	if name, ok := packObjectTypes[typ]; ok {
+		return name, size, nil
+	}
+	name, err := p.typeAt(off)
+	if err != nil {
+		return "", 0, err
+	}
+	zr, err := zlib.NewReader(bufio.NewReader(io.NewSectionReader(p.f, dataOff, 1<<62)))
+	if err != nil {
+		return "", 0, err
+	}
+	defer zr.Close()
+	// the delta starts with the sizes of its base and of its result
+	var buf [2 * 10]byte
+	n, err := io.ReadFull(zr, buf[:min(int64(len(buf)), size)])
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", 0, err
+	}
+	_, m := decodeDeltaSize(buf[:n])
+	if m == 0 {
+		return "", 0, errors.New("malformed delta")
+	}
+	resultSize, m := decodeDeltaSize(buf[m:n])
+	if m == 0 {
+		return "", 0, errors.New("malformed delta")
+	}
+	return name, int64(resultSize), nil
+}
+
+func (p *Pack) typeAt(off int64) (string, error) {
+	for depth := 0; depth < 1000; depth++ {
+		typ, _, baseOff, _, err := p.header(off)
+		if err != nil {
+			return "", err
+		}
+		if name, ok := packObjectTypes[typ]; ok {
+			return name, nil
+		}
+		off = baseOff
+	}
+	return "", errors.New("delta chain is too deep")
+}
+
+func (p *Pack) objectAt(off int64, depth int) (packObject, error) {
+	if obj, ok := p.cache[off]; ok {
+		return obj, nil
+	}
+	if depth > 1000 {
+		return packObject{}, errors.New("delta chain is too deep")
+	}
+	typ, dataOff, baseOff, size, err := p.header(off)
+	if err != nil {
+		return packObject{}, err
+	}
+	data, err := p.inflate(dataOff, size)
+	if err != nil {
+		return packObject{}, err
+	}
+
+	var obj packObject
+	if name, ok := packObjectTypes[typ]; ok {
+		obj = packObject{typ: name, content: data}
+	} else {
+		base, err := p.objectAt(baseOff, depth+1)
+		if err != nil {
+			return packObject{}, err
+		}
+		content, err := applyDelta(base.content, data)
+		if err != nil {
+			return packObject{}, err
+		}
+		obj = packObject{typ: base.typ, content: content}
+	}
+
+	if p.cacheSize+len(obj.content) > packCacheSize {
+		p.cache = make(map[int64]packObject)
+		p.cacheSize = 0
+	}
+	p.cache[off] = obj
+	p.cacheSize += len(obj.content)
+	return obj, nil
+}
+
+// header parses the entry header at off, returning the type, the offset of
+// the compressed data, the offset of the delta base (if any) and the
+// inflated size.
+func (p *Pack) header(off int64) (byte, int64, int64, int64, error) {
+	var buf [32 + 64]byte
+	n, err := p.f.ReadAt(buf[:], off)
+	if err != nil && err != io.EOF {
+		return 0, 0, 0, 0, err
+	}
+	b := buf[:n]
+	if len(b) == 0 {
+		return 0, 0, 0, 0, io.ErrUnexpectedEOF
+	}
+
+	c := b[0]
+	typ := (c >> 4) & 0x7
+	size := int64(c & 0x0f)
+	shift := uint(4)
+	i := 1
+	for c&0x80 != 0 {
+		if i >= len(b) {
+			return 0, 0, 0, 0, io.ErrUnexpectedEOF
+		}
+		if shift > 56 {
+			return 0, 0, 0, 0, errors.New("malformed object size")
+		}
+		c = b[i]
+		i++
+		size |= int64(c&0x7f) << shift
+		shift += 7
+	}
+	if size < 0 || size > maxObjectSize {
+		return 0, 0, 0, 0, fmt.Errorf("object size %d is out of bounds", size)
+	}
+
+	var baseOff int64
+	switch typ {
+	case packOfsDelta:
+		rel, n := decodeOffsetVarint(b[i:])
+		if n <= 0 || rel <= 0 || int64(rel) > off {
+			return 0, 0, 0, 0, errors.New("malformed delta base offset")
+		}
+		baseOff = off - int64(rel)
+		i += n
+	case packRefDelta:
+		if i+p.format.Size > len(b) {
+			return 0, 0, 0, 0, io.ErrUnexpectedEOF
+		}
+		base := hex.EncodeToString(b[i : i+p.format.Size])
+		var ok bool
+		if baseOff, ok = p.Index.Offsets[base]; !ok {
+			return 0, 0, 0, 0, fmt.Errorf("delta base %s is not in pack", base)
+		}
+		i += p.format.Size
+	default:
+		if _, ok := packObjectTypes[typ]; !ok {
+			return 0, 0, 0, 0, fmt.Errorf("unknown object type %d", typ)
+		}
+	}
+	return typ, off + int64(i), baseOff, size, nil
+}
+
+func (p *Pack) inflate(off, size int64) ([]byte, error) {
+	zr, err := zlib.NewReader(bufio.NewReader(io.NewSectionReader(p.f, off, 1<<62)))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	// the size comes from the pack, so the buffer only grows with what's
+	// actually there
+	data, err := io.ReadAll(io.LimitReader(zr, size))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, nil
+}
+
+// applyDelta applies a git delta to base.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	srcSize, n := decodeDeltaSize(delta)
+	if n == 0 || srcSize != len(base) {
+		return nil, errors.New("delta base size mismatch")
+	}
+	return patchDelta(base, delta[n:])
+}
+
+func decodeDeltaSize(delta []byte) (int, int) {
+	var size, shift int
+	for i, c := range delta {
+		if shift > 56 {
+			return 0, 0
+		}
+		size |= int(c&0x7f) << shift
+		shift += 7
+		if c&0x80 == 0 {
+			if size < 0 || size > maxObjectSize {
+				return 0, 0
+			}
+			return size, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// patchDelta applies the instructions of a delta, preceded by the size of the
+// result, copying from base.
+func patchDelta(base, delta []byte) ([]byte, error) {
+	dstSize, n := decodeDeltaSize(delta)
+	if n == 0 {
+		return nil, errors.New("malformed delta")
+	}
+	delta = delta[n:]
+
+	// dstSize is only a hint, the result grows with the instructions
+	out := make([]byte, 0, min(dstSize, packCacheSize))
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		switch {
+		case op&0x80 != 0:
+			var off, size int
+			for i := uint(0); i < 7; i++ {
+				if op&(1<<i) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return nil, errors.New("malformed delta copy instruction")
+				}
+				if i < 4 {
+					off |= int(delta[0]) << (8 * i)
+				} else {
+					size |= int(delta[0]) << (8 * (i - 4))
+				}
+				delta = delta[1:]
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if off+size > len(base) {
+				return nil, errors.New("delta copy out of bounds")
+			}
+			if len(out)+size > dstSize {
+				return nil, errors.New("delta result is larger than its size")
+			}
+			out = append(out, base[off:off+size]...)
+		case op != 0:
+			if int(op) > len(delta) {
+				return nil, errors.New("delta insert out of bounds")
+			}
+			if len(out)+int(op) > dstSize {
+				return nil, errors.New("delta result is larger than its size")
+			}
+			out = append(out, delta[:op]...)
+			delta = delta[op:]
+		default:
+			return nil, errors.New("reserved delta instruction")
+		}
+	}
+	if len(out) != dstSize {
+		return nil, errors.New("delta result size mismatch")
+	}
+	return out, nil
+}
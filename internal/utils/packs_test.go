@@ -0,0 +1,304 @@
+package utils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// packEntry encodes an object header claiming size, followed by the deflated
+// content.
+func packEntry(typ byte, size int, content []byte) []byte {
+	c := byte(typ<<4) | byte(size&0x0f)
+	size >>= 4
+	var b []byte
+	for size > 0 {
+		b = append(b, c|0x80)
+		c = byte(size & 0x7f)
+		size >>= 7
+	}
+	b = append(b, c)
+	return append(b, deflate(content)...)
+}
+
+func deflate(content []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(content)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// writePack writes a pack of the given entries, returning a Pack reading it
+// that knows about the entry at each offset by the name "<index>".
+func writePack(t *testing.T, entries ...[]byte) *Pack {
+	t.Helper()
+	data := []byte("PACK\x00\x00\x00\x02")
+	data = binary.BigEndian.AppendUint32(data, uint32(len(entries)))
+	offsets := make(map[string]int64)
+	for i, e := range entries {
+		offsets[string(rune('0'+i))] = int64(len(data))
+		data = append(data, e...)
+	}
+	sum := sha1.Sum(data)
+	data = append(data, sum[:]...)
+
+	path := filepath.Join(t.TempDir(), "pack-test.pack")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return &Pack{Index: &PackIndex{Offsets: offsets}, f: f, format: SHA1, cache: make(map[int64]packObject)}
+}
+
+func TestPackObject(t *testing.T) {
+	blob := []byte("hello world\n")
+	tests := []struct {
+		name    string
+		entries [][]byte
+		want    string
+		wantErr string
+	}{
+		{"blob", [][]byte{packEntry(3, len(blob), blob)}, string(blob), ""},
+		{"empty blob", [][]byte{packEntry(3, 0, nil)}, "", ""},
+		{"size larger than content", [][]byte{packEntry(3, 1000, blob)}, "", "unexpected EOF"},
+		{"size too large", [][]byte{packEntry(3, maxObjectSize+1, blob)}, "", "out of bounds"},
+		{"size varint overflows", [][]byte{append(bytes.Repeat([]byte{0xbf}, 12), 0x01)}, "", "malformed object size"},
+		{"unknown type", [][]byte{packEntry(5, len(blob), blob)}, "", "unknown object type"},
+		{"ofs delta to itself", [][]byte{append([]byte{0x65, 0x00}, deflate(nil)...)}, "", "malformed delta base offset"},
+		{"ofs delta before pack", [][]byte{append([]byte{0x65, 0x7f}, deflate(nil)...)}, "", "malformed delta base offset"},
+		{"ofs delta offset overflows", [][]byte{append(append([]byte{0x65}, bytes.Repeat([]byte{0xff}, 12)...), 0x01)}, "", "malformed delta base offset"},
+		{"ref delta to missing base", [][]byte{append([]byte{0x75}, make([]byte, 20)...)}, "", "is not in pack"},
+		{"not deflated", [][]byte{{0x3c, 'h', 'e', 'l', 'l', 'o'}}, "", "zlib"},
+		{
+			"delta with huge result size",
+			[][]byte{
+				packEntry(3, len(blob), blob),
+				append([]byte{0x6b, byte(len(packEntry(3, len(blob), blob)))}, deflate(append([]byte{byte(len(blob))}, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f))...),
+			},
+			"", "malformed delta",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := writePack(t, tt.entries...)
+			name := string(rune('0' + len(tt.entries) - 1))
+			typ, content, err := p.Object(name)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Object() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if typ != "blob" || string(content) != tt.want {
+				t.Fatalf("Object() = %s %q, want blob %q", typ, content, tt.want)
+			}
+		})
+	}
+}
+
+func TestPackDelta(t *testing.T) {
+	base := []byte("hello world\n")
+	tests := []struct {
+		name    string
+		delta   []byte
+		want    string
+		wantErr string
+	}{
+		// copy "hello " from the base, then insert "there\n"
+		{"copy and insert", []byte{byte(len(base)), 12, 0x90, 6, 6, 't', 'h', 'e', 'r', 'e', '\n'}, "hello there\n", ""},
+		// copies of the whole base, over and over
+		{"oversized copy", append([]byte{byte(len(base)), 12}, bytes.Repeat([]byte{0x90, 12}, 1000)...), "", "larger than its size"},
+		{"oversized insert", []byte{byte(len(base)), 2, 3, 'a', 'b', 'c'}, "", "larger than its size"},
+		{"undersized result", []byte{byte(len(base)), 12, 0x90, 6}, "", "size mismatch"},
+		{"copy out of base", []byte{byte(len(base)), 12, 0x91, 8, 6}, "", "out of bounds"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			baseEntry := packEntry(3, len(base), base)
+			// an ofs delta header, with the base offset right after it
+			header := packEntry(6, len(tt.delta), nil)
+			deltaEntry := append(header[:len(header)-len(deflate(nil))], byte(len(baseEntry)))
+			deltaEntry = append(deltaEntry, deflate(tt.delta)...)
+			p := writePack(t, baseEntry, deltaEntry)
+
+			typ, content, err := p.Object("1")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Object() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if typ != "blob" || string(content) != tt.want {
+				t.Fatalf("Object() = %s %q", typ, content)
+			}
+		})
+	}
+}
+
+func TestPackObjectHeader(t *testing.T) {
+	base := []byte("hello world\n")
+	delta := []byte{byte(len(base)), 12, 0x90, 6, 6, 't', 'h', 'e', 'r', 'e', '\n'}
+	baseEntry := packEntry(3, len(base), base)
+	deltaEntry := append(packEntry(6, len(delta), nil)[:1], byte(len(baseEntry)))
+	deltaEntry = append(deltaEntry, deflate(delta)...)
+	// a delta that ends before its result size
+	shortEntry := append(packEntry(6, 1, nil)[:1], byte(len(baseEntry)+len(deltaEntry)))
+	shortEntry = append(shortEntry, deflate([]byte{byte(len(base))})...)
+	p := writePack(t, baseEntry, deltaEntry, shortEntry)
+
+	tests := []struct {
+		name    string
+		want    int64
+		wantErr bool
+	}{
+		{"0", int64(len(base)), false},
+		{"1", 12, false},
+		{"2", 0, true},
+	}
+	for _, tt := range tests {
+		typ, size, err := p.ObjectHeader(tt.name)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ObjectHeader(%s) succeeded", tt.name)
+			}
+			continue
+		}
+		if err != nil || typ != "blob" || size != tt.want {
+			t.Errorf("ObjectHeader(%s) = %s %d, %v, want blob %d", tt.name, typ, size, err, tt.want)
+		}
+	}
+}
+
+// packIndex encodes a version 2 pack index of the given objects.
+func packIndex(offsets map[string]uint64, packSum []byte) []byte {
+	var hashes []string
+	for h := range offsets {
+		hashes = append(hashes, h)
+	}
+	sort.Strings(hashes)
+
+	data := append([]byte{}, packIndexSignature...)
+	data = binary.BigEndian.AppendUint32(data, 2)
+	for i := 0; i < 256; i++ {
+		n := 0
+		for _, h := range hashes {
+			if b, _ := hex.DecodeString(h[:2]); int(b[0]) <= i {
+				n++
+			}
+		}
+		data = binary.BigEndian.AppendUint32(data, uint32(n))
+	}
+	for _, h := range hashes {
+		b, _ := hex.DecodeString(h)
+		data = append(data, b...)
+	}
+	data = append(data, make([]byte, 4*len(hashes))...)
+	var large []byte
+	for _, h := range hashes {
+		off := offsets[h]
+		if off >= 0x80000000 {
+			data = binary.BigEndian.AppendUint32(data, 0x80000000|uint32(len(large)/8))
+			large = binary.BigEndian.AppendUint64(large, off)
+		} else {
+			data = binary.BigEndian.AppendUint32(data, uint32(off))
+		}
+	}
+	data = append(data, large...)
+	data = append(data, packSum...)
+	sum := sha1.Sum(data)
+	return append(data, sum[:]...)
+}
+
+func TestDecodePackIndex(t *testing.T) {
+	a := strings.Repeat("a", 40)
+	b := "0b" + strings.Repeat("1", 38)
+	packSum := bytes.Repeat([]byte{0xcc}, 20)
+	valid := packIndex(map[string]uint64{a: 12, b: 1 << 33}, packSum)
+
+	// a large offset pointing past the table of large offsets
+	badLarge := append([]byte{}, valid...)
+	offStart := 8 + 256*4 + 2*20 + 2*4
+	binary.BigEndian.PutUint32(badLarge[offStart:], 0x80000005)
+
+	// an object count far larger than the file
+	badCount := append([]byte{}, valid...)
+	binary.BigEndian.PutUint32(badCount[8+255*4:], 0xffffffff)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"valid", valid, ""},
+		{"empty", nil, "signature"},
+		{"bad signature", append([]byte("PACK"), valid[4:]...), "signature"},
+		{"version 1", append(append([]byte{}, valid[:4]...), append([]byte{0, 0, 0, 1}, valid[8:]...)...), "version"},
+		{"truncated fanout", valid[:100], "signature"},
+		{"truncated tables", valid[:8+256*4+30], "truncated"},
+		{"huge count", badCount, "truncated"},
+		{"large offset out of bounds", badLarge, "out of bounds"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idx, err := DecodePackIndex(tt.data, SHA1)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("DecodePackIndex() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(idx.Hashes) != 2 || idx.Offsets[a] != 12 || idx.Offsets[b] != 1<<33 {
+				t.Fatalf("DecodePackIndex() = %v %v", idx.Hashes, idx.Offsets)
+			}
+			if idx.PackHash != hex.EncodeToString(packSum) {
+				t.Fatalf("PackHash = %s", idx.PackHash)
+			}
+		})
+	}
+}
+
+func TestChecksumTrailer(t *testing.T) {
+	dir := t.TempDir()
+	valid := packIndex(map[string]uint64{strings.Repeat("a", 40): 12}, bytes.Repeat([]byte{0xcc}, 20))
+	corrupt := append([]byte{}, valid...)
+	corrupt[20] ^= 1
+
+	for name, data := range map[string][]byte{"valid": valid, "corrupt": corrupt, "short": valid[:10]} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, data, 0644)
+		err := VerifyChecksumTrailer(path, SHA1)
+		if (err == nil) != (name == "valid") {
+			t.Errorf("%s: VerifyChecksumTrailer() = %v", name, err)
+		}
+	}
+
+	path := filepath.Join(dir, "valid")
+	packSum, err := ReadChecksumTrailer(path, SHA1, true)
+	if err != nil || packSum != strings.Repeat("cc", 20) {
+		t.Errorf("ReadChecksumTrailer(previous) = %s, %v", packSum, err)
+	}
+	if _, err := ReadChecksumTrailer(filepath.Join(dir, "short"), SHA1, true); err == nil {
+		t.Error("ReadChecksumTrailer() of a short file succeeded")
+	}
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ResolveRef resolves a ref (such as HEAD or refs/heads/master) in gitDir to
+// an object name, following symbolic refs and falling back to packed-refs.
+func ResolveRef(gitDir, name string, format ObjectFormat) (string, error) {
+	for depth := 0; depth < 5; depth++ {
+		if strings.Contains(name, "..") {
+			return "", fmt.Errorf("ref %s is malformed", name)
+		}
+		content, err := os.ReadFile(URL(gitDir, name))
+		if err != nil {
+			hash, ok := packedRef(gitDir, name)
+			if !ok {
+				return "", fmt.Errorf("ref %s not found", name)
+			}
+			return hash, nil
+		}
+		value := strings.TrimSpace(string(content))
+		if target, ok := strings.CutPrefix(value, "ref:"); ok {
+			name = strings.TrimSpace(target)
+			continue
+		}
+		if !format.IsHash(value) {
+			return "", fmt.Errorf("ref %s is malformed", name)
+		}
+		return value, nil
+	}
+	return "", fmt.Errorf("ref %s is nested too deeply", name)
+}
+
+func packedRef(gitDir, name string) (string, bool) {
+	content, err := os.ReadFile(URL(gitDir, "packed-refs"))
+	if err != nil {
+		return "", false
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == name {
+			return fields[0], true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,249 @@
+package utils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	revlogEntrySize    = 64
+	revlogInline       = 1 << 16
+	revlogGeneralDelta = 1 << 17
+)
+
+var hgNullID = make([]byte, sha1.Size)
+
+// Revlog is a Mercurial revlog, the storage format of the changelog, the
+// manifest and the history of every file.
+type Revlog struct {
+	entries []revlogEntry
+	data    []byte
+	inline  bool
+	general bool
+	nodes   map[string]int
+}
+
+type revlogEntry struct {
+	offset   int64
+	length   int
+	base     int
+	linkRev  int
+	parents  [2]int
+	node     []byte
+	dataFrom int64
+}
+
+// ReadRevlog reads the revlog with the given index file (.i), along with its
+// data file (.d) if the data isn't inlined in the index.
+func ReadRevlog(indexPath string) (*Revlog, error) {
+	index, err := os.ReadFile(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	if !RevlogIsInline(index) {
+		data, err = os.ReadFile(strings.TrimSuffix(indexPath, ".i") + ".d")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return DecodeRevlog(index, data)
+}
+
+// RevlogIsInline returns whether the revlog index data holds the revision
+// data too, so that there is no .d file.
+func RevlogIsInline(index []byte) bool {
+	return len(index) < 4 || binary.BigEndian.Uint32(index)&revlogInline != 0
+}
+
+func DecodeRevlog(index, data []byte) (*Revlog, error) {
+	rl := &Revlog{nodes: make(map[string]int)}
+	if len(index) == 0 {
+		return rl, nil
+	}
+	if len(index) < 4 {
+		return nil, errors.New("revlog index is truncated")
+	}
+	header := binary.BigEndian.Uint32(index)
+	if version := header & 0xffff; version != 1 {
+		return nil, fmt.Errorf("unsupported revlog version %d", version)
+	}
+	rl.inline = header&revlogInline != 0
+	rl.general = header&revlogGeneralDelta != 0
+	if rl.inline {
+		rl.data = index
+	} else {
+		rl.data = data
+	}
+
+	be := binary.BigEndian
+	for pos := 0; pos < len(index); {
+		if pos+revlogEntrySize > len(index) {
+			return nil, errors.New("revlog index is truncated")
+		}
+		raw := index[pos : pos+revlogEntrySize]
+		rev := len(rl.entries)
+		e := revlogEntry{
+			offset:  int64(be.Uint64(raw) >> 16),
+			length:  int(int32(be.Uint32(raw[8:]))),
+			base:    int(int32(be.Uint32(raw[16:]))),
+			linkRev: int(int32(be.Uint32(raw[20:]))),
+			parents: [2]int{int(int32(be.Uint32(raw[24:]))), int(int32(be.Uint32(raw[28:])))},
+			node:    append([]byte(nil), raw[32:32+sha1.Size]...),
+		}
+		if rev == 0 {
+			// the first 4 bytes of the first entry hold the header instead
+			e.offset = 0
+		}
+		e.dataFrom = e.offset
+		pos += revlogEntrySize
+		if rl.inline {
+			e.dataFrom = int64(pos)
+			pos += e.length
+		}
+		if e.length < 0 || (rl.inline && pos > len(index)) {
+			return nil, fmt.Errorf("revision %d is out of bounds", rev)
+		}
+		rl.entries = append(rl.entries, e)
+		rl.nodes[hex.EncodeToString(e.node)] = rev
+	}
+	return rl, nil
+}
+
+// Len returns the number of revisions.
+func (rl *Revlog) Len() int {
+	return len(rl.entries)
+}
+
+// Node returns the node id of a revision.
+func (rl *Revlog) Node(rev int) string {
+	return hex.EncodeToString(rl.entries[rev].node)
+}
+
+// Rev returns the revision with the given node id.
+func (rl *Revlog) Rev(node string) (int, bool) {
+	rev, ok := rl.nodes[node]
+	return rev, ok
+}
+
+// LinkRev returns the changelog revision a revision was introduced by.
+func (rl *Revlog) LinkRev(rev int) int {
+	return rl.entries[rev].linkRev
+}
+
+// Revision returns the full text of a revision, after checking it against its
+// node id.
+func (rl *Revlog) Revision(rev int) ([]byte, error) {
+	if rev < 0 || rev >= len(rl.entries) {
+		return nil, fmt.Errorf("unknown revision %d", rev)
+	}
+	// walk back to the last full snapshot, then apply the deltas on top of it
+	var chain []int
+	for r := rev; ; {
+		chain = append(chain, r)
+		base := rl.entries[r].base
+		if base == r || base < 0 {
+			break
+		}
+		if rl.general {
+			r = base
+		} else {
+			r--
+		}
+		if r < 0 || r >= len(rl.entries) || len(chain) > len(rl.entries) {
+			return nil, fmt.Errorf("delta chain of revision %d is broken", rev)
+		}
+	}
+
+	text, err := rl.chunk(chain[len(chain)-1])
+	if err != nil {
+		return nil, err
+	}
+	for i := len(chain) - 2; i >= 0; i-- {
+		delta, err := rl.chunk(chain[i])
+		if err != nil {
+			return nil, err
+		}
+		if text, err = applyRevlogDelta(text, delta); err != nil {
+			return nil, fmt.Errorf("revision %d: %w", chain[i], err)
+		}
+	}
+
+	e := rl.entries[rev]
+	p1, p2 := rl.parentNode(e.parents[0]), rl.parentNode(e.parents[1])
+	if bytes.Compare(p1, p2) > 0 {
+		p1, p2 = p2, p1
+	}
+	h := sha1.New()
+	h.Write(p1)
+	h.Write(p2)
+	h.Write(text)
+	if !bytes.Equal(h.Sum(nil), e.node) {
+		return nil, fmt.Errorf("revision %d doesn't match its node id", rev)
+	}
+	return text, nil
+}
+
+func (rl *Revlog) parentNode(rev int) []byte {
+	if rev < 0 || rev >= len(rl.entries) {
+		return hgNullID
+	}
+	return rl.entries[rev].node
+}
+
+func (rl *Revlog) chunk(rev int) ([]byte, error) {
+	e := rl.entries[rev]
+	if e.dataFrom+int64(e.length) > int64(len(rl.data)) {
+		return nil, fmt.Errorf("revision %d is out of bounds", rev)
+	}
+	chunk := rl.data[e.dataFrom : e.dataFrom+int64(e.length)]
+	if len(chunk) == 0 {
+		return nil, nil
+	}
+	switch chunk[0] {
+	case 0:
+		return chunk, nil
+	case 'u':
+		return chunk[1:], nil
+	case 'x':
+		r, err := zlib.NewReader(bytes.NewReader(chunk))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	}
+	return nil, fmt.Errorf("unsupported compression %q for revision %d", chunk[0], rev)
+}
+
+// applyRevlogDelta applies a delta made of hunks replacing base[start:end]
+// with new data, in order.
+func applyRevlogDelta(base, delta []byte) ([]byte, error) {
+	be := binary.BigEndian
+	var out bytes.Buffer
+	last := 0
+	for len(delta) > 0 {
+		if len(delta) < 12 {
+			return nil, errors.New("delta is truncated")
+		}
+		start, end, size := int(be.Uint32(delta)), int(be.Uint32(delta[4:])), int(be.Uint32(delta[8:]))
+		delta = delta[12:]
+		if start < last || end < start || end > len(base) || size > len(delta) {
+			return nil, errors.New("delta is malformed")
+		}
+		out.Write(base[last:start])
+		out.Write(delta[:size])
+		delta = delta[size:]
+		last = end
+	}
+	out.Write(base[last:])
+	return out.Bytes(), nil
+}
@@ -0,0 +1,221 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testRev is a revision of a test revlog: its full text, and the chunk
+// stored for it, which is either the full text or a delta against base.
+type testRev struct {
+	text    string
+	chunk   []byte
+	base    int
+	parents [2]int
+}
+
+// hgDelta encodes a single hunk replacing base[start:end] with data.
+func hgDelta(start, end int, data string) []byte {
+	delta := binary.BigEndian.AppendUint32(nil, uint32(start))
+	delta = binary.BigEndian.AppendUint32(delta, uint32(end))
+	delta = binary.BigEndian.AppendUint32(delta, uint32(len(data)))
+	return append(delta, data...)
+}
+
+func hgNode(p1, p2 []byte, text string) []byte {
+	if bytes.Compare(p1, p2) > 0 {
+		p1, p2 = p2, p1
+	}
+	h := sha1.New()
+	h.Write(p1)
+	h.Write(p2)
+	h.Write([]byte(text))
+	return h.Sum(nil)
+}
+
+// buildRevlog lays out the index and data of a version 1 revlog, with the
+// data inlined in the index if inline is set.
+func buildRevlog(inline, general bool, revs []testRev) (index, data []byte, nodes []string) {
+	be := binary.BigEndian
+	var raw [][]byte
+	for i, r := range revs {
+		parent := func(p int) []byte {
+			if p < 0 {
+				return hgNullID
+			}
+			return raw[p]
+		}
+		raw = append(raw, hgNode(parent(r.parents[0]), parent(r.parents[1]), r.text))
+		nodes = append(nodes, hex.EncodeToString(raw[i]))
+
+		entry := be.AppendUint64(nil, uint64(len(data))<<16)
+		entry = be.AppendUint32(entry, uint32(len(r.chunk)))
+		entry = be.AppendUint32(entry, uint32(len(r.text)))
+		entry = be.AppendUint32(entry, uint32(r.base))
+		entry = be.AppendUint32(entry, uint32(i))
+		entry = be.AppendUint32(entry, uint32(int32(r.parents[0])))
+		entry = be.AppendUint32(entry, uint32(int32(r.parents[1])))
+		entry = append(entry, raw[i]...)
+		entry = append(entry, make([]byte, 12)...)
+		if i == 0 {
+			header := uint32(1)
+			if inline {
+				header |= revlogInline
+			}
+			if general {
+				header |= revlogGeneralDelta
+			}
+			be.PutUint32(entry, header)
+		}
+		index = append(index, entry...)
+		if inline {
+			index = append(index, r.chunk...)
+		} else {
+			data = append(data, r.chunk...)
+		}
+	}
+	return index, data, nodes
+}
+
+func TestRevlog(t *testing.T) {
+	v0 := "line 1\nline 2\nline 3\n"
+	v1 := "line 1\nline two\nline 3\n"
+	v2 := "line 1\nline 2\nline 3\nline 4\n"
+	v3 := "line zero\n" + v2
+	tests := []struct {
+		name    string
+		inline  bool
+		general bool
+		revs    []testRev
+	}{
+		{"inline", true, false, []testRev{
+			{v0, append([]byte("u"), v0...), 0, [2]int{-1, -1}},
+			// deltas against the previous revision, compressed or not
+			{v1, deflate(hgDelta(7, 14, "line two\n")), 0, [2]int{0, -1}},
+			{v2, hgDelta(7, 23, "line 2\nline 3\nline 4\n"), 0, [2]int{1, -1}},
+			{v3, deflate([]byte(v3)), 3, [2]int{2, 0}},
+		}},
+		{"separate data", false, false, []testRev{
+			{v0, deflate([]byte(v0)), 0, [2]int{-1, -1}},
+			{v1, hgDelta(7, 14, "line two\n"), 0, [2]int{0, -1}},
+			{v2, append([]byte("u"), v2...), 2, [2]int{1, -1}},
+			{v3, hgDelta(0, 0, "line zero\n"), 2, [2]int{2, -1}},
+		}},
+		{"general delta", false, true, []testRev{
+			{v0, append([]byte("u"), v0...), 0, [2]int{-1, -1}},
+			{v1, hgDelta(7, 14, "line two\n"), 0, [2]int{0, -1}},
+			// against the first revision rather than the previous one
+			{v2, hgDelta(21, 21, "line 4\n"), 0, [2]int{0, -1}},
+			{v3, deflate(hgDelta(0, 0, "line zero\n")), 2, [2]int{2, 1}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index, data, nodes := buildRevlog(tt.inline, tt.general, tt.revs)
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "foo.txt.i"), index, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if !tt.inline {
+				if err := os.WriteFile(filepath.Join(dir, "foo.txt.d"), data, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := RevlogIsInline(index); got != tt.inline {
+				t.Errorf("RevlogIsInline() = %v, want %v", got, tt.inline)
+			}
+
+			rl, err := ReadRevlog(filepath.Join(dir, "foo.txt.i"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rl.Len() != len(tt.revs) {
+				t.Fatalf("Len() = %d, want %d", rl.Len(), len(tt.revs))
+			}
+			for i, r := range tt.revs {
+				if rl.Node(i) != nodes[i] {
+					t.Errorf("Node(%d) = %s, want %s", i, rl.Node(i), nodes[i])
+				}
+				if rev, ok := rl.Rev(nodes[i]); !ok || rev != i {
+					t.Errorf("Rev(%s) = %d, %v, want %d", nodes[i], rev, ok, i)
+				}
+				if rl.LinkRev(i) != i {
+					t.Errorf("LinkRev(%d) = %d, want %d", i, rl.LinkRev(i), i)
+				}
+				text, err := rl.Revision(i)
+				if err != nil {
+					t.Errorf("Revision(%d): %v", i, err)
+					continue
+				}
+				if string(text) != r.text {
+					t.Errorf("Revision(%d) = %q, want %q", i, text, r.text)
+				}
+			}
+		})
+	}
+}
+
+func TestRevlogMalformed(t *testing.T) {
+	v0 := "hello\n"
+	valid := func() []testRev {
+		return []testRev{
+			{v0, append([]byte("u"), v0...), 0, [2]int{-1, -1}},
+			{"hello world\n", hgDelta(5, 5, " world"), 0, [2]int{0, -1}},
+		}
+	}
+	tests := []struct {
+		name    string
+		corrupt func(revs []testRev, index []byte) []byte
+		rev     int
+		want    string
+	}{
+		{"version", func(revs []testRev, index []byte) []byte {
+			index[3] = 2
+			return index
+		}, 0, "unsupported revlog version"},
+		{"truncated index", func(revs []testRev, index []byte) []byte {
+			return index[:len(index)-1]
+		}, 0, "out of bounds"},
+		{"text", func(revs []testRev, index []byte) []byte {
+			i := bytes.Index(index, []byte("hello\n"))
+			index[i] = 'j'
+			return index
+		}, 1, "doesn't match its node id"},
+		{"compression", func(revs []testRev, index []byte) []byte {
+			index[bytes.Index(index, []byte("uhello"))] = 'z'
+			return index
+		}, 0, "unsupported compression"},
+		{"delta", func(revs []testRev, index []byte) []byte {
+			i := bytes.LastIndex(index, []byte(" world"))
+			// the hunk ends past the end of the base
+			index[i-5] = 0x7f
+			return index
+		}, 1, "delta is malformed"},
+		{"delta chain", func(revs []testRev, index []byte) []byte {
+			// with general delta, a base after the revision itself
+			binary.BigEndian.PutUint32(index, 1|revlogInline|revlogGeneralDelta)
+			binary.BigEndian.PutUint32(index[64+len(revs[0].chunk)+16:], 7)
+			return index
+		}, 1, "delta chain of revision 1 is broken"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			revs := valid()
+			index, _, _ := buildRevlog(true, false, revs)
+			index = tt.corrupt(revs, index)
+			rl, err := DecodeRevlog(index, nil)
+			if err == nil {
+				_, err = rl.Revision(tt.rev)
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
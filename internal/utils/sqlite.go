@@ -0,0 +1,345 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var sqliteMagic = []byte("SQLite format 3\x00")
+
+// SQLiteDB is a read-only view of an sqlite3 database file, just enough to
+// scan the rows of its tables.
+type SQLiteDB struct {
+	data     []byte
+	pageSize int
+	usable   int
+}
+
+// SQLiteRow maps the column names of a table to their values, which are nil,
+// int64, float64, string or []byte.
+type SQLiteRow map[string]interface{}
+
+func ReadSQLite(path string) (*SQLiteDB, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeSQLite(data)
+}
+
+func DecodeSQLite(data []byte) (*SQLiteDB, error) {
+	if len(data) < 100 || !bytes.HasPrefix(data, sqliteMagic) {
+		return nil, errors.New("not an sqlite3 database")
+	}
+	be := binary.BigEndian
+	pageSize := int(be.Uint16(data[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return nil, fmt.Errorf("invalid page size %d", pageSize)
+	}
+	if enc := be.Uint32(data[56:60]); enc > 1 {
+		return nil, errors.New("only utf-8 databases are supported")
+	}
+	return &SQLiteDB{data: data, pageSize: pageSize, usable: pageSize - int(data[20])}, nil
+}
+
+// UserVersion returns the user version set by PRAGMA user_version.
+func (db *SQLiteDB) UserVersion() int {
+	return int(int32(binary.BigEndian.Uint32(db.data[60:64])))
+}
+
+// Table returns every row of the named table.
+func (db *SQLiteDB) Table(name string) ([]SQLiteRow, error) {
+	var root int
+	var sql string
+	err := db.scan(1, func(rowid int64, values []interface{}) {
+		if len(values) < 5 {
+			return
+		}
+		typ, _ := values[0].(string)
+		tblName, _ := values[1].(string)
+		if typ == "table" && strings.EqualFold(tblName, name) {
+			page, _ := values[3].(int64)
+			root = int(page)
+			sql, _ = values[4].(string)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if root == 0 {
+		return nil, fmt.Errorf("no such table: %s", name)
+	}
+	columns, rowidColumn := sqliteColumns(sql)
+
+	var rows []SQLiteRow
+	err = db.scan(root, func(rowid int64, values []interface{}) {
+		row := make(SQLiteRow, len(columns))
+		for i, column := range columns {
+			if i < len(values) {
+				row[column] = values[i]
+			} else {
+				row[column] = nil
+			}
+		}
+		if rowidColumn != "" {
+			row[rowidColumn] = rowid
+		}
+		rows = append(rows, row)
+	})
+	return rows, err
+}
+
+// scan walks the table b-tree rooted at page, calling fn for every row.
+func (db *SQLiteDB) scan(page int, fn func(rowid int64, values []interface{})) error {
+	visited := make(map[int]bool)
+	var walk func(page, depth int) error
+	walk = func(page, depth int) error {
+		if visited[page] || depth > 64 {
+			return errors.New("b-tree is cyclic")
+		}
+		visited[page] = true
+		data, err := db.page(page)
+		if err != nil {
+			return err
+		}
+		hdr := 0
+		if page == 1 {
+			hdr = 100
+		}
+		if len(data) < hdr+8 {
+			return errors.New("page is truncated")
+		}
+		be := binary.BigEndian
+		kind := data[hdr]
+		cells := int(be.Uint16(data[hdr+3:]))
+		ptrs := hdr + 8
+		if kind == 0x05 {
+			ptrs = hdr + 12
+		}
+		if len(data) < ptrs+2*cells {
+			return errors.New("page is truncated")
+		}
+
+		for i := 0; i < cells; i++ {
+			off := int(be.Uint16(data[ptrs+2*i:]))
+			if off >= len(data) {
+				return errors.New("cell is out of bounds")
+			}
+			cell := data[off:]
+			switch kind {
+			case 0x05:
+				if len(cell) < 4 {
+					return errors.New("cell is truncated")
+				}
+				if err := walk(int(be.Uint32(cell)), depth+1); err != nil {
+					return err
+				}
+			case 0x0d:
+				size, n := sqliteVarint(cell)
+				if n == 0 {
+					return errors.New("cell is truncated")
+				}
+				rowid, m := sqliteVarint(cell[n:])
+				if m == 0 {
+					return errors.New("cell is truncated")
+				}
+				payload, err := db.payload(cell[n+m:], int(size))
+				if err != nil {
+					return err
+				}
+				values, err := decodeSQLiteRecord(payload)
+				if err != nil {
+					return err
+				}
+				fn(int64(rowid), values)
+			default:
+				return fmt.Errorf("page %d is not a table b-tree page", page)
+			}
+		}
+		if kind == 0x05 {
+			return walk(int(be.Uint32(data[hdr+8:])), depth+1)
+		}
+		return nil
+	}
+	return walk(page, 0)
+}
+
+func (db *SQLiteDB) page(n int) ([]byte, error) {
+	start := (n - 1) * db.pageSize
+	if n < 1 || start+db.pageSize > len(db.data) {
+		return nil, fmt.Errorf("page %d is out of bounds", n)
+	}
+	return db.data[start : start+db.usable], nil
+}
+
+// payload returns the payload of a table leaf cell, following its overflow
+// pages if it didn't fit.
+func (db *SQLiteDB) payload(cell []byte, size int) ([]byte, error) {
+	maxLocal := db.usable - 35
+	local := size
+	if size > maxLocal {
+		minLocal := (db.usable-12)*32/255 - 23
+		local = minLocal + (size-minLocal)%(db.usable-4)
+		if local > maxLocal {
+			local = minLocal
+		}
+	}
+	if len(cell) < local {
+		return nil, errors.New("cell is truncated")
+	}
+	payload := append([]byte(nil), cell[:local]...)
+	if local == size {
+		return payload, nil
+	}
+	if len(cell) < local+4 {
+		return nil, errors.New("cell is truncated")
+	}
+	next := int(binary.BigEndian.Uint32(cell[local:]))
+	for pages := 0; len(payload) < size; pages++ {
+		if next == 0 || pages > len(db.data)/db.pageSize {
+			return nil, errors.New("overflow chain is broken")
+		}
+		data, err := db.page(next)
+		if err != nil {
+			return nil, err
+		}
+		next = int(binary.BigEndian.Uint32(data))
+		chunk := data[4:]
+		if rest := size - len(payload); len(chunk) > rest {
+			chunk = chunk[:rest]
+		}
+		payload = append(payload, chunk...)
+	}
+	return payload, nil
+}
+
+func decodeSQLiteRecord(payload []byte) ([]interface{}, error) {
+	hdrSize, n := sqliteVarint(payload)
+	if n == 0 || int(hdrSize) > len(payload) || int(hdrSize) < n {
+		return nil, errors.New("record header is malformed")
+	}
+	types := payload[n:hdrSize]
+	body := payload[hdrSize:]
+	var values []interface{}
+	for len(types) > 0 {
+		typ, n := sqliteVarint(types)
+		if n == 0 {
+			return nil, errors.New("record header is malformed")
+		}
+		types = types[n:]
+
+		var size int
+		switch {
+		case typ <= 4:
+			size = int(typ)
+		case typ == 5:
+			size = 6
+		case typ == 6 || typ == 7:
+			size = 8
+		case typ >= 12:
+			size = int((typ - 12) / 2)
+		}
+		if size > len(body) {
+			return nil, errors.New("record is truncated")
+		}
+		field := body[:size]
+		body = body[size:]
+
+		switch {
+		case typ == 0:
+			values = append(values, nil)
+		case typ <= 6:
+			var v int64
+			for i, b := range field {
+				if i == 0 {
+					v = int64(int8(b))
+				} else {
+					v = v<<8 | int64(b)
+				}
+			}
+			values = append(values, v)
+		case typ == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(field)))
+		case typ == 8 || typ == 9:
+			values = append(values, int64(typ-8))
+		case typ >= 12 && typ%2 == 0:
+			values = append(values, append([]byte(nil), field...))
+		case typ >= 13:
+			values = append(values, string(field))
+		default:
+			return nil, fmt.Errorf("unknown serial type %d", typ)
+		}
+	}
+	return values, nil
+}
+
+func sqliteVarint(data []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9 && i < len(data); i++ {
+		if i == 8 {
+			return v<<8 | uint64(data[i]), 9
+		}
+		v = v<<7 | uint64(data[i]&0x7f)
+		if data[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+var sqlCommentRegex = regexp.MustCompile(`(?s)--[^\n]*|/\*.*?\*/`)
+
+// sqliteColumns extracts the column names from a CREATE TABLE statement, and
+// the column aliasing the rowid, if any.
+func sqliteColumns(sql string) ([]string, string) {
+	sql = sqlCommentRegex.ReplaceAllString(sql, " ")
+	start, end := strings.IndexByte(sql, '('), strings.LastIndexByte(sql, ')')
+	if start < 0 || end < start {
+		return nil, ""
+	}
+
+	var defs []string
+	depth, last := 0, start+1
+	for i := start + 1; i < end; i++ {
+		switch sql[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				defs = append(defs, sql[last:i])
+				last = i + 1
+			}
+		}
+	}
+	defs = append(defs, sql[last:end])
+
+	var columns []string
+	var rowidColumn string
+	for _, def := range defs {
+		fields := strings.Fields(def)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "PRIMARY", "UNIQUE", "CHECK", "FOREIGN", "CONSTRAINT":
+			continue
+		}
+		name := strings.Trim(fields[0], "\"`[]'")
+		columns = append(columns, name)
+		if len(fields) >= 4 && strings.EqualFold(fields[1], "INTEGER") && strings.EqualFold(fields[2], "PRIMARY") && strings.EqualFold(fields[3], "KEY") {
+			rowidColumn = name
+		}
+	}
+	return columns, rowidColumn
+}
@@ -0,0 +1,180 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SvnRepository is a repository a working copy was checked out from.
+type SvnRepository struct {
+	Root string
+	UUID string
+}
+
+// SvnNode is a file or directory of a working copy, as it is in the working
+// tree (local changes included).
+type SvnNode struct {
+	Path       string
+	Kind       string
+	Revision   int64
+	Repository int64
+	ReposPath  string
+	// Checksum is the sha1 of the pristine text of files
+	Checksum string
+}
+
+// SvnWorkingCopy is the content of a Subversion 1.7+ wc.db.
+type SvnWorkingCopy struct {
+	Format       int
+	Repositories map[int64]SvnRepository
+	Nodes        []SvnNode
+	// Pristines holds the sha1 of every pristine text, including those only
+	// referenced by the base of deleted or replaced nodes
+	Pristines []string
+}
+
+// ReadWcDB reads the NODES, PRISTINE and REPOSITORY tables of a wc.db.
+func ReadWcDB(path string) (*SvnWorkingCopy, error) {
+	db, err := ReadSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	wc := &SvnWorkingCopy{Format: db.UserVersion(), Repositories: make(map[int64]SvnRepository)}
+
+	repos, err := db.Table("REPOSITORY")
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range repos {
+		id, _ := row["id"].(int64)
+		root, _ := row["root"].(string)
+		uuid, _ := row["uuid"].(string)
+		wc.Repositories[id] = SvnRepository{Root: root, UUID: uuid}
+	}
+
+	nodes, err := db.Table("NODES")
+	if err != nil {
+		return nil, err
+	}
+	// every layer of local changes is stored with a higher op_depth, the working
+	// tree is made of the topmost row of each path
+	type layer struct {
+		depth int64
+		row   SQLiteRow
+	}
+	top := make(map[string]layer)
+	for _, row := range nodes {
+		relpath, ok := row["local_relpath"].(string)
+		if !ok {
+			continue
+		}
+		depth, _ := row["op_depth"].(int64)
+		if l, ok := top[relpath]; !ok || depth > l.depth {
+			top[relpath] = layer{depth: depth, row: row}
+		}
+	}
+	for relpath, l := range top {
+		presence, _ := l.row["presence"].(string)
+		if presence != "normal" && presence != "incomplete" {
+			continue
+		}
+		node := SvnNode{Path: relpath}
+		node.Kind, _ = l.row["kind"].(string)
+		node.Revision, _ = l.row["revision"].(int64)
+		node.Repository, _ = l.row["repos_id"].(int64)
+		node.ReposPath, _ = l.row["repos_path"].(string)
+		if checksum, ok := l.row["checksum"].(string); ok {
+			node.Checksum = strings.TrimPrefix(checksum, "$sha1$")
+		}
+		wc.Nodes = append(wc.Nodes, node)
+	}
+	sort.Slice(wc.Nodes, func(i, j int) bool {
+		return wc.Nodes[i].Path < wc.Nodes[j].Path
+	})
+
+	pristines, err := db.Table("PRISTINE")
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range pristines {
+		if checksum, ok := row["checksum"].(string); ok && strings.HasPrefix(checksum, "$sha1$") {
+			wc.Pristines = append(wc.Pristines, strings.TrimPrefix(checksum, "$sha1$"))
+		}
+	}
+	return wc, nil
+}
+
+// SvnEntry is an entry of a pre-1.7 .svn/entries file. The first entry of a
+// file, with an empty name, describes the directory itself.
+type SvnEntry struct {
+	Name     string `xml:"name,attr"`
+	Kind     string `xml:"kind,attr"`
+	Revision string `xml:"revision,attr"`
+	URL      string `xml:"url,attr"`
+	Root     string `xml:"repos,attr"`
+	UUID     string `xml:"uuid,attr"`
+	Schedule string `xml:"schedule,attr"`
+	// Checksum is the md5 of the text base of files
+	Checksum string `xml:"checksum,attr"`
+	Deleted  bool   `xml:"deleted,attr"`
+	Absent   bool   `xml:"absent,attr"`
+}
+
+// ParseSvnEntries parses a .svn/entries file, either in the xml format of
+// Subversion 1.3 and older, or the line based format of 1.4 to 1.6.
+func ParseSvnEntries(content []byte) ([]SvnEntry, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte("<?xml")) {
+		var doc struct {
+			Entries []SvnEntry `xml:"entry"`
+		}
+		if err := xml.Unmarshal(content, &doc); err != nil {
+			return nil, err
+		}
+		return doc.Entries, nil
+	}
+
+	nl := bytes.IndexByte(content, '\n')
+	if nl < 0 {
+		return nil, errors.New("entries file is truncated")
+	}
+	var format int
+	if _, err := fmt.Sscanf(string(content[:nl]), "%d", &format); err != nil {
+		return nil, fmt.Errorf("unknown entries format: %w", err)
+	}
+
+	var entries []SvnEntry
+	// entries are terminated by a form feed, and list their fields in a fixed
+	// order, leaving out trailing empty ones
+	for _, record := range strings.Split(string(content[nl+1:]), "\f\n") {
+		if strings.TrimSpace(record) == "" {
+			continue
+		}
+		fields := strings.Split(record, "\n")
+		field := func(i int) string {
+			if i < len(fields) {
+				return fields[i]
+			}
+			return ""
+		}
+		entries = append(entries, SvnEntry{
+			Name:     field(0),
+			Kind:     field(1),
+			Revision: field(2),
+			URL:      field(3),
+			Root:     field(4),
+			Schedule: field(5),
+			Checksum: field(7),
+			Deleted:  field(22) == "deleted",
+			Absent:   field(23) == "absent",
+			UUID:     field(25),
+		})
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("entries file has no entries")
+	}
+	return entries, nil
+}
@@ -0,0 +1,192 @@
+package utils
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadWcDB(t *testing.T) {
+	// built from testdata/wc.db.sql, NODES spans interior pages and the
+	// properties of README overflow
+	wc, err := ReadWcDB("testdata/wc.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wc.Format != 31 {
+		t.Errorf("format = %d, want 31", wc.Format)
+	}
+	wantRepos := map[int64]SvnRepository{
+		1: {Root: "https://svn.example.com/repo", UUID: "0b8d6bd6-1f8e-4e2c-9c43-5d1b8f7a6a10"},
+		2: {Root: "https://svn.example.com/other", UUID: "6f1d0f5e-8a41-4b1c-a7c2-0c8b2f3e9d21"},
+	}
+	if !reflect.DeepEqual(wc.Repositories, wantRepos) {
+		t.Errorf("repositories = %v, want %v", wc.Repositories, wantRepos)
+	}
+
+	sha := func(c byte) string { return strings.Repeat(string(c), 40) }
+	want := []SvnNode{
+		{Path: "", Kind: "dir", Revision: 7, Repository: 1, ReposPath: "trunk"},
+		{Path: "README", Kind: "file", Revision: 7, Repository: 1, ReposPath: "trunk/README", Checksum: sha('1')},
+		{Path: "copied", Kind: "dir", Revision: 5, Repository: 1, ReposPath: "branches/y"},
+		{Path: "copied/new.txt", Kind: "file", Revision: 5, Repository: 1, ReposPath: "branches/y/new.txt", Checksum: sha('5')},
+		{Path: "gen", Kind: "dir", Revision: 7, Repository: 1, ReposPath: "trunk/gen"},
+	}
+	for i := 1; i <= 300; i++ {
+		name := fmt.Sprintf("gen/%03d.txt", i)
+		want = append(want, SvnNode{Path: name, Kind: "file", Revision: 7, Repository: 1, ReposPath: "trunk/" + name, Checksum: sha('1')})
+	}
+	want = append(want,
+		SvnNode{Path: "partial", Kind: "dir", Revision: 7, Repository: 1, ReposPath: "trunk/partial"},
+		SvnNode{Path: "replaced.txt", Kind: "file", Revision: 3, Repository: 2, ReposPath: "branches/x/replaced.txt", Checksum: sha('4')},
+	)
+	if len(wc.Nodes) != len(want) {
+		t.Fatalf("got %d nodes, want %d", len(wc.Nodes), len(want))
+	}
+	for i := range want {
+		if wc.Nodes[i] != want[i] {
+			t.Errorf("node %d = %+v, want %+v", i, wc.Nodes[i], want[i])
+		}
+	}
+
+	wantPristines := []string{sha('1'), sha('2'), sha('3'), sha('4'), sha('5')}
+	if !reflect.DeepEqual(wc.Pristines, wantPristines) {
+		t.Errorf("pristines = %v, want %v", wc.Pristines, wantPristines)
+	}
+}
+
+func TestReadWcDBMalformed(t *testing.T) {
+	data, err := ReadSQLite("testdata/wc.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		corrupt func(data []byte)
+		want    string
+	}{
+		{"magic", func(data []byte) { data[0] = 'X' }, "not an sqlite3 database"},
+		{"page size", func(data []byte) { data[16], data[17] = 0x03, 0x00 }, "invalid page size"},
+		{"encoding", func(data []byte) { data[59] = 2 }, "only utf-8"},
+		{"page type", func(data []byte) { data[100] = 0x0a }, "not a table b-tree page"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			corrupted := append([]byte(nil), data.data...)
+			tt.corrupt(corrupted)
+			db, err := DecodeSQLite(corrupted)
+			if err == nil {
+				_, err = db.Table("NODES")
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// svnEntriesRecord lays out an entry of a line based entries file from its
+// fields by position, leaving out the trailing empty ones.
+func svnEntriesRecord(fields map[int]string) string {
+	last := 0
+	for i := range fields {
+		last = max(last, i)
+	}
+	lines := make([]string, last+1)
+	for i, f := range fields {
+		lines[i] = f
+	}
+	return strings.Join(lines, "\n") + "\n\f\n"
+}
+
+func TestParseSvnEntries(t *testing.T) {
+	const (
+		url  = "https://svn.example.com/repo/trunk"
+		root = "https://svn.example.com/repo"
+		uuid = "0b8d6bd6-1f8e-4e2c-9c43-5d1b8f7a6a10"
+		md5  = "d41d8cd98f00b204e9800998ecf8427e"
+	)
+	tests := []struct {
+		name    string
+		content string
+		want    []SvnEntry
+		wantErr string
+	}{
+		{"xml", `<?xml version="1.0" encoding="utf-8"?>
+<wc-entries
+   xmlns="svn:">
+<entry
+   committed-rev="7"
+   name=""
+   committed-date="2005-01-01T00:00:00.000000Z"
+   url="` + url + `"
+   kind="dir"
+   uuid="` + uuid + `"
+   repos="` + root + `"
+   revision="7"/>
+<entry
+   name="README"
+   kind="file"
+   checksum="` + md5 + `"/>
+<entry
+   name="gone.txt"
+   kind="file"
+   deleted="true"/>
+<entry
+   name="new.txt"
+   kind="file"
+   schedule="add"/>
+<entry
+   name="hidden"
+   kind="dir"
+   absent="true"/>
+</wc-entries>
+`, []SvnEntry{
+			{Kind: "dir", Revision: "7", URL: url, Root: root, UUID: uuid},
+			{Name: "README", Kind: "file", Checksum: md5},
+			{Name: "gone.txt", Kind: "file", Deleted: true},
+			{Name: "new.txt", Kind: "file", Schedule: "add"},
+			{Name: "hidden", Kind: "dir", Absent: true},
+		}, ""},
+		{"lines", "10\n" +
+			svnEntriesRecord(map[int]string{1: "dir", 2: "7", 3: url, 4: root, 8: "2009-01-01T00:00:00.000000Z", 9: "7", 10: "alice", 25: uuid}) +
+			svnEntriesRecord(map[int]string{0: "README", 1: "file", 6: "2009-01-01T00:00:00.000000Z", 7: md5, 9: "7", 10: "alice"}) +
+			svnEntriesRecord(map[int]string{0: "new.txt", 1: "file", 5: "add"}) +
+			svnEntriesRecord(map[int]string{0: "gone.txt", 1: "file", 2: "5", 22: "deleted"}) +
+			svnEntriesRecord(map[int]string{0: "hidden", 1: "dir", 23: "absent"}) +
+			svnEntriesRecord(map[int]string{0: "sub", 1: "dir"}),
+			[]SvnEntry{
+				{Kind: "dir", Revision: "7", URL: url, Root: root, UUID: uuid},
+				{Name: "README", Kind: "file", Checksum: md5},
+				{Name: "new.txt", Kind: "file", Schedule: "add"},
+				{Name: "gone.txt", Kind: "file", Revision: "5", Deleted: true},
+				{Name: "hidden", Kind: "dir", Absent: true},
+				{Name: "sub", Kind: "dir"},
+			}, ""},
+		{"lines without trailing newline", "8\n\ndir\n3\n" + url + "\n\f\n", []SvnEntry{
+			{Kind: "dir", Revision: "3", URL: url},
+		}, ""},
+		{"truncated", "10", nil, "truncated"},
+		{"unknown format", "format\n\ndir\n\f\n", nil, "unknown entries format"},
+		{"no entries", "10\n\f\n", nil, "no entries"},
+		{"malformed xml", "<?xml version=\"1.0\"?>\n<wc-entries><entry name=", nil, "EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSvnEntries([]byte(tt.content))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseSvnEntries() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSvnEntries() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+-- builds wc.db with: sqlite3 wc.db < wc.db.sql
+-- the tables of a Subversion 1.8 working copy goop reads, with small pages so
+-- NODES spans interior pages and the long properties spill to overflow pages
+PRAGMA page_size = 512;
+PRAGMA user_version = 31;
+
+CREATE TABLE REPOSITORY (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  root  TEXT UNIQUE NOT NULL,
+  uuid  TEXT NOT NULL
+  );
+
+CREATE TABLE PRISTINE (
+  checksum  TEXT NOT NULL PRIMARY KEY,
+  compression  INTEGER,
+  size  INTEGER NOT NULL,
+  refcount  INTEGER NOT NULL,
+  md5_checksum  TEXT NOT NULL
+  );
+
+CREATE TABLE NODES (
+  wc_id  INTEGER NOT NULL REFERENCES WCROOT (id),
+  local_relpath  TEXT NOT NULL,
+  op_depth INTEGER NOT NULL,
+  parent_relpath  TEXT,
+  repos_id  INTEGER REFERENCES REPOSITORY (id),
+  repos_path  TEXT,
+  revision  INTEGER,
+  presence  TEXT NOT NULL,
+  moved_here  INTEGER,
+  moved_to  TEXT,
+  kind  TEXT NOT NULL,
+  properties  BLOB,
+  depth  TEXT,
+  checksum  TEXT REFERENCES PRISTINE (checksum),
+  symlink_target  TEXT,
+  changed_revision  INTEGER,
+  changed_date      INTEGER,
+  changed_author    TEXT,
+  translated_size  INTEGER,
+  last_mod_time  INTEGER,
+  dav_cache  BLOB,
+  file_external  INTEGER,
+  inherited_props  BLOB,
+  PRIMARY KEY (wc_id, local_relpath, op_depth)
+  );
+
+INSERT INTO REPOSITORY (root, uuid) VALUES
+  ('https://svn.example.com/repo', '0b8d6bd6-1f8e-4e2c-9c43-5d1b8f7a6a10'),
+  ('https://svn.example.com/other', '6f1d0f5e-8a41-4b1c-a7c2-0c8b2f3e9d21');
+
+INSERT INTO PRISTINE VALUES
+  ('$sha1$1111111111111111111111111111111111111111', NULL, 5, 1, '$md5 $11111111111111111111111111111111'),
+  ('$sha1$2222222222222222222222222222222222222222', NULL, 5, 1, '$md5 $22222222222222222222222222222222'),
+  ('$sha1$3333333333333333333333333333333333333333', NULL, 5, 1, '$md5 $33333333333333333333333333333333'),
+  ('$sha1$4444444444444444444444444444444444444444', NULL, 5, 1, '$md5 $44444444444444444444444444444444'),
+  ('$sha1$5555555555555555555555555555555555555555', NULL, 5, 1, '$md5 $55555555555555555555555555555555');
+
+INSERT INTO NODES (wc_id, local_relpath, op_depth, parent_relpath, repos_id, repos_path, revision, presence, kind, properties, checksum) VALUES
+  (1, '', 0, NULL, 1, 'trunk', 7, 'normal', 'dir', NULL, NULL),
+  -- unchanged, with properties too long to fit in its page
+  (1, 'README', 0, '', 1, 'trunk/README', 7, 'normal', 'file', zeroblob(3000), '$sha1$1111111111111111111111111111111111111111'),
+  -- deleted locally, its pristine text is still kept
+  (1, 'deleted.txt', 0, '', 1, 'trunk/deleted.txt', 7, 'normal', 'file', NULL, '$sha1$2222222222222222222222222222222222222222'),
+  (1, 'deleted.txt', 1, '', NULL, NULL, NULL, 'base-deleted', 'file', NULL, NULL),
+  -- replaced by a copy from another repository
+  (1, 'replaced.txt', 0, '', 1, 'trunk/replaced.txt', 7, 'normal', 'file', NULL, '$sha1$3333333333333333333333333333333333333333'),
+  (1, 'replaced.txt', 1, '', 2, 'branches/x/replaced.txt', 3, 'normal', 'file', NULL, '$sha1$4444444444444444444444444444444444444444'),
+  -- copied in under a copied directory, two layers above the base
+  (1, 'copied', 1, '', 1, 'branches/y', 5, 'normal', 'dir', NULL, NULL),
+  (1, 'copied/new.txt', 1, 'copied', 1, 'branches/y/new.txt', 5, 'normal', 'file', NULL, '$sha1$5555555555555555555555555555555555555555'),
+  (1, 'copied/new.txt', 2, 'copied', NULL, NULL, NULL, 'base-deleted', 'file', NULL, NULL),
+  (1, 'copied/new.txt', 3, 'copied', 1, 'branches/y/new.txt', 5, 'normal', 'file', NULL, '$sha1$5555555555555555555555555555555555555555'),
+  (1, 'excluded', 0, '', 1, 'trunk/excluded', 7, 'excluded', 'dir', NULL, NULL),
+  (1, 'partial', 0, '', 1, 'trunk/partial', 7, 'incomplete', 'dir', NULL, NULL);
+
+-- enough files for the table to need interior pages
+WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 300)
+INSERT INTO NODES (wc_id, local_relpath, op_depth, parent_relpath, repos_id, repos_path, revision, presence, kind, checksum)
+  SELECT 1, printf('gen/%03d.txt', i), 0, 'gen', 1, printf('trunk/gen/%03d.txt', i), 7, 'normal', 'file', '$sha1$1111111111111111111111111111111111111111' FROM n;
+INSERT INTO NODES (wc_id, local_relpath, op_depth, parent_relpath, repos_id, repos_path, revision, presence, kind) VALUES
+  (1, 'gen', 0, '', 1, 'trunk/gen', 7, 'normal', 'dir');
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Tree is a tree object built from the index.
+type Tree struct {
+	Hash    string
+	Content []byte
+}
+
+// EncodeTree encodes the entries of a tree object, sorting them the way git
+// does.
+func EncodeTree(entries []TreeEntry, format ObjectFormat) ([]byte, error) {
+	sorted := make([]TreeEntry, len(entries))
+	copy(sorted, entries)
+	sortKey := func(e TreeEntry) string {
+		// trees sort as if their name ended in a slash
+		if e.Mode == 040000 {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sortKey(sorted[i]) < sortKey(sorted[j])
+	})
+
+	var buf bytes.Buffer
+	for _, e := range sorted {
+		raw, err := hex.DecodeString(e.Hash)
+		if err != nil || len(raw) != format.Size {
+			return nil, fmt.Errorf("invalid object name %q for %s", e.Hash, e.Name)
+		}
+		fmt.Fprintf(&buf, "%o %s\x00", e.Mode, e.Name)
+		buf.Write(raw)
+	}
+	return buf.Bytes(), nil
+}
+
+// Trees builds the tree objects describing the stage 0 entries of the index,
+// keyed by directory path, with "" being the root. Directories containing
+// unmerged entries, and the directories above them, are left out as they
+// can't be represented by a tree.
+func (idx *Index) Trees(format ObjectFormat) (map[string]Tree, error) {
+	children := map[string][]TreeEntry{"": nil}
+	subdirs := map[string]map[string]bool{}
+	unmerged := map[string]bool{}
+
+	var addDir func(dir string)
+	addDir = func(dir string) {
+		if _, ok := children[dir]; ok {
+			return
+		}
+		children[dir] = nil
+		parent, _ := splitPath(dir)
+		addDir(parent)
+		if subdirs[parent] == nil {
+			subdirs[parent] = map[string]bool{}
+		}
+		subdirs[parent][dir] = true
+	}
+
+	for _, e := range idx.Entries {
+		name := strings.TrimSuffix(e.Name, "/")
+		dir, base := splitPath(name)
+		if e.Stage != 0 {
+			for d := dir; ; d, _ = splitPath(d) {
+				unmerged[d] = true
+				if d == "" {
+					break
+				}
+			}
+			continue
+		}
+		addDir(dir)
+		// sparse indexes keep whole directories as a single tree entry
+		children[dir] = append(children[dir], TreeEntry{Mode: e.Mode, Name: base, Hash: e.Hash})
+	}
+
+	trees := make(map[string]Tree)
+	var build func(dir string) (string, error)
+	build = func(dir string) (string, error) {
+		entries := children[dir]
+		complete := !unmerged[dir]
+		for sub := range subdirs[dir] {
+			hash, err := build(sub)
+			if err != nil {
+				return "", err
+			}
+			if hash == "" {
+				complete = false
+				continue
+			}
+			_, base := splitPath(sub)
+			entries = append(entries, TreeEntry{Mode: 040000, Name: base, Hash: hash})
+		}
+		if !complete {
+			return "", nil
+		}
+		content, err := EncodeTree(entries, format)
+		if err != nil {
+			return "", err
+		}
+		hash := format.HashObject("tree", content)
+		trees[dir] = Tree{Hash: hash, Content: content}
+		return hash, nil
+	}
+	if _, err := build(""); err != nil {
+		return nil, err
+	}
+	return trees, nil
+}
+
+func splitPath(p string) (string, string) {
+	i := strings.LastIndexByte(p, '/')
+	if i < 0 {
+		return "", p
+	}
+	return p[:i], p[i+1:]
+}
@@ -0,0 +1,100 @@
+package utils
+
+import "testing"
+
+func TestIndexTrees(t *testing.T) {
+	// the entries of a work tree, with the hashes git gives them in both
+	// object formats
+	type file struct {
+		name   string
+		mode   uint32
+		sha1   string
+		sha256 string
+	}
+	files := []file{
+		{"README", 0100644, "ce013625030ba8dba906f756967f9e9ca394464a", "2cf8d83d9ee29543b34a87727421fdecb7e3f3a183d337639025de576db9ebb4"},
+		{"bin/run.sh", 0100755, "1a2485251c33a70432394c93fb89330ef214bfc9", "1249034e3cf9007362d695b09b1fbdb4c578903bf10b665749b94743f8177ce1"},
+		{"docs/index.md", 0100644, "d8f8d46921aa81abc4c0d27703a8908333ae38c3", "4941ae8eb9e8255a4578af8e0b5193410509d50a3c838ef8a442a2090fb0802c"},
+		{"link", SymlinkMode, "100b93820ade4c16225673b4ca62bb3ade63c313", "8b07c6a78b8faa782f2461f398be5dce437dc88d12505e619e25f7c2106ccfad"},
+		{"src-file", 0100644, "587be6b4c3f93f93c489c0111bba5596147a26cb", "14f5162e2fe3d240d0d37aaab0f90e4af9a7cfa79639f3bab005b5bfb4174d9f"},
+		{"src/lib/util.go", 0100644, "55c21f80aa6524ff206213a9453abd5e759c8f48", "15a952fc08837e29c96616b2c042c01c531570a82a3671f65eeb556fa2c1621d"},
+		{"src/main.go", 0100644, "06ab7d0f9a35a7d1070711496d6ca1cb892a258f", "0772a933e3734c7de69f3b326c02fcdada051e2f3b6d1bf4ac0d08a2c419dc53"},
+	}
+	// docs as kept by a sparse index, a single entry for the whole directory
+	sparseDocs := file{"docs/", 040000, "632fae84d591a2bbb7777041861abc5685bdaa0e", "49154e75db62d5aa232e278250e82f31cec16659ac416ff7809f56d6867cfb3e"}
+
+	tests := []struct {
+		name   string
+		format ObjectFormat
+		sparse bool
+		stages map[string]int
+		want   map[string]string
+	}{
+		{"sha1", SHA1, false, nil, map[string]string{
+			"":        "85fdb5f31571367e18a4b29fd10f2a7a6fb47d56",
+			"bin":     "4d30b2ddd4dbd82d6ad7ee4d2a4ea360f5d65b61",
+			"docs":    "632fae84d591a2bbb7777041861abc5685bdaa0e",
+			"src":     "fb1533f8cb3d7bdd1c4fe9b22cc489455e894311",
+			"src/lib": "852cf65068765919f4b60c5dd8183986bac1eeef",
+		}},
+		{"sha1 sparse", SHA1, true, nil, map[string]string{
+			"":        "85fdb5f31571367e18a4b29fd10f2a7a6fb47d56",
+			"bin":     "4d30b2ddd4dbd82d6ad7ee4d2a4ea360f5d65b61",
+			"src":     "fb1533f8cb3d7bdd1c4fe9b22cc489455e894311",
+			"src/lib": "852cf65068765919f4b60c5dd8183986bac1eeef",
+		}},
+		{"sha256 sparse", SHA256, true, nil, map[string]string{
+			"":        "69790c87f05fe4cba93cdd4e0c00bb81c6be877333805a25d770ccb3ab88fbab",
+			"bin":     "e8149ac2617fb9bdbca28051b823de7d8ed993c486b21fcc400d1779f4f08218",
+			"src":     "f16c59bb50ff2472a1340c67fce3644304f4ff9424f0caf3c648cf3b276f876f",
+			"src/lib": "cd5558d1d07df1b76718f775ba45f700b520199ebaa96bedf177ac6e6b5b2ff7",
+		}},
+		{"unmerged", SHA1, false, map[string]int{"src/lib/util.go": 2}, map[string]string{
+			"bin":  "4d30b2ddd4dbd82d6ad7ee4d2a4ea360f5d65b61",
+			"docs": "632fae84d591a2bbb7777041861abc5685bdaa0e",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idx := &Index{Version: 2}
+			for _, f := range files {
+				if tt.sparse && f.name == "docs/index.md" {
+					f = sparseDocs
+				}
+				hash := f.sha1
+				if tt.format.Name == SHA256.Name {
+					hash = f.sha256
+				}
+				idx.Entries = append(idx.Entries, &IndexEntry{Name: f.name, Mode: f.mode, Hash: hash, Stage: tt.stages[f.name]})
+			}
+
+			trees, err := idx.Trees(tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(trees) != len(tt.want) {
+				t.Errorf("built trees for %d directories, want %d", len(trees), len(tt.want))
+			}
+			for dir, want := range tt.want {
+				tree, ok := trees[dir]
+				if !ok {
+					t.Errorf("tree /%s wasn't built", dir)
+					continue
+				}
+				if tree.Hash != want {
+					t.Errorf("tree /%s = %s, want %s", dir, tree.Hash, want)
+				}
+				if got := tt.format.HashObject("tree", tree.Content); got != tree.Hash {
+					t.Errorf("tree /%s content hashes to %s, not %s", dir, got, tree.Hash)
+				}
+			}
+		})
+	}
+}
+
+func TestIndexTreesInvalidHash(t *testing.T) {
+	idx := &Index{Version: 2, Entries: []*IndexEntry{{Name: "a", Mode: 0100644, Hash: "abcd"}}}
+	if _, err := idx.Trees(SHA1); err == nil {
+		t.Fatal("tree with a truncated object name was built")
+	}
+}
@@ -5,22 +5,30 @@ import (
 
 	"github.com/deletescape/goop/internal/utils"
 	"github.com/deletescape/jobtracker"
-	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/format/index"
-	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/phuslu/log"
 )
 
 type CreateObjectContext struct {
 	BaseDir string
-	Storage *filesystem.ObjectStorage
-	Index   *index.Index
+	// GitDir is the git directory the objects are written to
+	GitDir string
+	Format utils.ObjectFormat
+	Index  *utils.Index
 }
 
 func CreateObjectWorker(jt *jobtracker.JobTracker, f string, context jobtracker.Context) {
 	c := context.(CreateObjectContext)
+	if f == "" {
+		// the job tracker hands out empty jobs once its queue is drained
+		return
+	}
 
-	fp := utils.URL(c.BaseDir, f)
+	fp, err := utils.ConfinedPath(c.BaseDir, f, false)
+	if err != nil {
+		log.Warn().Str("dir", c.BaseDir).Str("file", f).Err(err).Msg("refusing to read file")
+		return
+	}
 
 	entry, err := c.Index.Entry(f)
 	if err != nil {
@@ -28,14 +36,17 @@ func CreateObjectWorker(jt *jobtracker.JobTracker, f string, context jobtracker.
 		return
 	}
 
-	fMode, err := entry.Mode.ToOSFileMode()
-	if err != nil {
-		log.Warn().Str("file", f).Err(err).Msg("failed to set filemode")
-	} else {
-		os.Chmod(fp, fMode)
+	// the metadata of symlinks would end up on whatever they point to
+	if info, err := os.Lstat(fp); err == nil && info.Mode()&os.ModeSymlink == 0 {
+		fMode, err := filemode.FileMode(entry.Mode).ToOSFileMode()
+		if err != nil {
+			log.Warn().Str("file", f).Err(err).Msg("failed to set filemode")
+		} else {
+			os.Chmod(fp, fMode)
+		}
+		os.Chown(fp, int(entry.UID), int(entry.GID))
+		os.Chtimes(fp, entry.ModifiedAt, entry.ModifiedAt)
 	}
-	os.Chown(fp, int(entry.UID), int(entry.GID))
-	os.Chtimes(fp, entry.ModifiedAt, entry.ModifiedAt)
 	//log.Info().Str("file", f).Msg("updated from index")
 
 	content, err := os.ReadFile(fp)
@@ -44,26 +55,13 @@ func CreateObjectWorker(jt *jobtracker.JobTracker, f string, context jobtracker.
 		return
 	}
 
-	hash := plumbing.ComputeHash(plumbing.BlobObject, content)
+	hash := c.Format.HashObject("blob", content)
 	if entry.Hash != hash {
 		log.Warn().Str("file", f).Msg("hash does not match hash in index, skipping object creation")
 		return
 	}
 
-	obj := c.Storage.NewEncodedObject()
-	obj.SetSize(int64(len(content)))
-	obj.SetType(plumbing.BlobObject)
-
-	ow, err := obj.Writer()
-	if err != nil {
-		log.Error().Str("file", f).Err(err).Msg("failed to create object writer")
-		return
-	}
-	defer ow.Close()
-	ow.Write(content)
-
-	_, err = c.Storage.SetEncodedObject(obj)
-	if err != nil {
+	if _, err := utils.WriteLooseObject(c.GitDir, "blob", content, c.Format); err != nil {
 		log.Error().Str("file", f).Err(err).Msg("failed to create object")
 		return
 	}
@@ -15,20 +15,39 @@ type DownloadContext struct {
 	BaseDir     string
 	AllowHTML   bool
 	AlllowEmpty bool
+	// WorkTree marks downloads into a work tree, where nothing may be written
+	// into a .git directory
+	WorkTree bool
 }
 
 func DownloadWorker(jt *jobtracker.JobTracker, file string, context jobtracker.Context) {
 	c := context.(DownloadContext)
+	if file == "" {
+		// the job tracker hands out empty jobs once its queue is drained
+		return
+	}
 	checkRatelimted()
 
-	targetFile := utils.URL(c.BaseDir, file)
+	targetFile, err := utils.ConfinedPath(c.BaseDir, file, !c.WorkTree)
+	if err != nil {
+		log.Warn().Str("dir", c.BaseDir).Str("file", file).Err(err).Msg("refusing to fetch file")
+		return
+	}
 	if utils.Exists(targetFile) {
 		log.Info().Str("file", targetFile).Msg("already fetched, skipping redownload")
 		return
 	}
 	uri := utils.URL(c.BaseURL, file)
-	code, body, err := c.C.Get(nil, uri)
-	if err == nil && code != 200 {
+	if isStreamed(file) {
+		downloadStreamed(jt, c.C, file, uri, targetFile)
+		return
+	}
+	resp, err := fetch(c.C, uri, nil, 0)
+	if err != nil {
+		log.Error().Str("uri", uri).Err(err).Msg("couldn't fetch file")
+		return
+	}
+	if code := resp.code; code != 200 {
 		if code == 429 {
 			setRatelimited()
 			jt.AddJob(file)
@@ -36,11 +55,13 @@ func DownloadWorker(jt *jobtracker.JobTracker, file string, context jobtracker.C
 		}
 		log.Warn().Str("uri", uri).Int("code", code).Msg("couldn't fetch file")
 		return
-	} else if err != nil {
-		log.Error().Str("uri", uri).Int("code", code).Err(err).Msg("couldn't fetch file")
-		return
 	}
+	body := resp.bodyFor(file)
 
+	if IsNotFound(uri, body) {
+		log.Warn().Str("uri", uri).Msg("file appears to be a not found page, skipping")
+		return
+	}
 	if !c.AllowHTML && utils.IsHTML(body) {
 		log.Warn().Str("uri", uri).Msg("file appears to be html, skipping")
 		return
@@ -49,13 +70,10 @@ func DownloadWorker(jt *jobtracker.JobTracker, file string, context jobtracker.C
 		log.Warn().Str("uri", uri).Msg("file appears to be empty, skipping")
 		return
 	}
-	if err := utils.CreateParentFolders(targetFile); err != nil {
-		log.Error().Str("uri", uri).Str("file", targetFile).Err(err).Msg("couldn't create parent directories")
-		return
-	}
-	if err := os.WriteFile(targetFile, body, os.ModePerm); err != nil {
+	if err := utils.WriteConfinedFile(c.BaseDir, file, body, os.ModePerm, !c.WorkTree); err != nil {
 		log.Error().Str("uri", uri).Str("file", targetFile).Err(err).Msg("clouldn't write file")
 		return
 	}
+	resp.recordEncoding(targetFile, uri)
 	log.Info().Str("uri", uri).Str("file", file).Msg("fetched file")
 }
@@ -0,0 +1,230 @@
+package workers
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/phuslu/log"
+	"github.com/valyala/fasthttp"
+)
+
+// every body is decoded before it's looked at, so it doesn't matter which of
+// these the server picks, or whether it compresses without being asked to
+const acceptEncoding = "gzip, deflate, br"
+
+// MaxBodySize caps the files that aren't streamed to disk, which are held in
+// memory: larger bodies are refused, as sent as well as decoded, as a few
+// kilobytes of compressed zeros would otherwise take all the memory
+const MaxBodySize = 256 << 20
+
+var errBodyTooLarge = fmt.Errorf("decoded body is larger than %d bytes", MaxBodySize)
+
+var looseObjectRegex = regexp.MustCompile(`(^|/)objects/[0-9a-f]{2}/[0-9a-f]{38}([0-9a-f]{24})?$`)
+
+// the first bytes of the files whose content is compressed or binary by
+// themselves, which servers sometimes label with a content encoding
+var fileMagics = []struct {
+	match func(file string) bool
+	magic func(body []byte) bool
+}{
+	{func(f string) bool { return looseObjectRegex.MatchString(f) }, isZlib},
+	{func(f string) bool { return strings.HasSuffix(f, ".pack") }, prefix("PACK")},
+	{func(f string) bool { return strings.HasSuffix(f, ".idx") }, prefix("\xfftOc")},
+	{func(f string) bool { return path.Base(f) == "index" }, prefix("DIRC")},
+	{func(f string) bool { return strings.HasSuffix(f, ".gz") || strings.HasSuffix(f, ".tgz") }, prefix("\x1f\x8b")},
+	{func(f string) bool { return strings.HasSuffix(f, ".zip") }, prefix("PK")},
+}
+
+type fetched struct {
+	code   int
+	header map[string]string
+	// raw is the body as sent, body is the body with the content encodings
+	// listed in encoding stripped
+	raw      []byte
+	body     []byte
+	encoding string
+}
+
+// fetch gets uri, asking for compressed content and decoding it, as well as
+// content the server compressed without being asked to.
+func fetch(c *fasthttp.Client, uri string, header map[string]string, maxRedirects int) (*fetched, error) {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI(uri)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	var err error
+	if maxRedirects > 0 {
+		err = c.DoRedirects(req, resp, maxRedirects)
+	} else {
+		err = c.Do(req, resp)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f := &fetched{
+		code:     resp.StatusCode(),
+		header:   headerMap(&resp.Header),
+		raw:      append([]byte(nil), resp.Body()...),
+		encoding: string(resp.Header.Peek("Content-Encoding")),
+	}
+	f.body = f.raw
+	if f.encoding != "" {
+		body, err := decodeContent(f.raw, f.encoding)
+		if errors.Is(err, errBodyTooLarge) {
+			return nil, err
+		} else if err != nil {
+			log.Warn().Str("uri", uri).Str("encoding", f.encoding).Err(err).Msg("couldn't decode body, keeping it as sent")
+			f.encoding = ""
+		} else {
+			f.body = body
+		}
+	}
+	return f, nil
+}
+
+// bodyFor returns the bytes to store as file. That's the decoded body, unless
+// the body as sent already is what file should hold and decoding broke it,
+// such as a loose object labelled as deflate or a .tar.gz labelled as gzip,
+// in which case the encoding is forgotten.
+func (f *fetched) bodyFor(file string) []byte {
+	if f.encoding == "" {
+		return f.body
+	}
+	for _, m := range fileMagics {
+		if m.match(file) && m.magic(f.raw) && !m.magic(f.body) {
+			log.Info().Str("file", file).Str("encoding", f.encoding).Msg("content encoding is part of the file, keeping it as sent")
+			f.body, f.encoding = f.raw, ""
+			break
+		}
+	}
+	return f.body
+}
+
+func decodeContent(body []byte, encoding string) ([]byte, error) {
+	codings := strings.Split(encoding, ",")
+	// the encodings are listed in the order they were applied
+	for i := len(codings) - 1; i >= 0; i-- {
+		var r io.Reader
+		switch coding := strings.ToLower(strings.TrimSpace(codings[i])); coding {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			zr, err := gzip.NewReader(bytes.NewReader(body))
+			if err != nil {
+				return nil, err
+			}
+			r = zr
+		case "deflate":
+			// deflate is meant to be zlib, but plenty of servers send a raw
+			// deflate stream instead
+			if isZlib(body) {
+				zr, err := zlib.NewReader(bytes.NewReader(body))
+				if err != nil {
+					return nil, err
+				}
+				r = zr
+			} else {
+				r = flate.NewReader(bytes.NewReader(body))
+			}
+		case "br":
+			r = brotli.NewReader(bytes.NewReader(body))
+		default:
+			return nil, fmt.Errorf("unsupported content encoding %q", coding)
+		}
+		decoded, err := io.ReadAll(io.LimitReader(r, MaxBodySize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(decoded) > MaxBodySize {
+			return nil, errBodyTooLarge
+		}
+		body = decoded
+	}
+	return body, nil
+}
+
+func isZlib(body []byte) bool {
+	return len(body) >= 2 && body[0]&0x0f == 8 && (uint16(body[0])<<8|uint16(body[1]))%31 == 0
+}
+
+func prefix(magic string) func([]byte) bool {
+	return func(body []byte) bool {
+		return bytes.HasPrefix(body, []byte(magic))
+	}
+}
+
+// Get fetches uri the way the workers do, returning the status code and the
+// decoded body.
+func Get(c *fasthttp.Client, uri string) (int, []byte, error) {
+	f, err := fetch(c, uri, nil, 0)
+	if err != nil {
+		return 0, nil, err
+	}
+	return f.code, f.body, nil
+}
+
+var encodingRecord struct {
+	sync.Mutex
+	dir string
+}
+
+type encodingEntry struct {
+	File     string `json:"file"`
+	URI      string `json:"uri"`
+	Encoding string `json:"encoding"`
+}
+
+// RecordEncodings makes the workers note which content encoding they
+// stripped from every file they write inside of dir, in
+// dir/.goop/encodings.jsonl.
+func RecordEncodings(dir string) {
+	encodingRecord.Lock()
+	defer encodingRecord.Unlock()
+	encodingRecord.dir = dir
+}
+
+// recordEncoding notes the content encoding stripped from the body of file,
+// if any.
+func (f *fetched) recordEncoding(file, uri string) {
+	if f.encoding == "" {
+		return
+	}
+	encodingRecord.Lock()
+	defer encodingRecord.Unlock()
+	if encodingRecord.dir == "" {
+		return
+	}
+	rel := strings.TrimPrefix(file, strings.TrimSuffix(encodingRecord.dir, "/")+"/")
+	line, _ := json.Marshal(encodingEntry{File: rel, URI: uri, Encoding: f.encoding})
+	recordFile := utils.URL(encodingRecord.dir, ".goop/encodings.jsonl")
+	if err := utils.CreateParentFolders(recordFile); err != nil {
+		log.Error().Str("file", recordFile).Err(err).Msg("couldn't record content encoding")
+		return
+	}
+	out, err := os.OpenFile(recordFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Error().Str("file", recordFile).Err(err).Msg("couldn't record content encoding")
+		return
+	}
+	defer out.Close()
+	out.Write(append(line, '\n'))
+}
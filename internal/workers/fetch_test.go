@@ -0,0 +1,91 @@
+package workers
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func compress(t *testing.T, w func(io.Writer) io.WriteCloser, content []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := w(&buf)
+	if _, err := zw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipWriter(w io.Writer) io.WriteCloser   { return gzip.NewWriter(w) }
+func zlibWriter(w io.Writer) io.WriteCloser   { return zlib.NewWriter(w) }
+func brotliWriter(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) }
+func flateWriter(w io.Writer) io.WriteCloser {
+	fw, _ := flate.NewWriter(w, flate.BestSpeed)
+	return fw
+}
+
+func TestDecodeContent(t *testing.T) {
+	content := []byte("ref: refs/heads/master\n")
+	tests := []struct {
+		name     string
+		body     []byte
+		encoding string
+		wantErr  bool
+	}{
+		{"identity", content, "identity", false},
+		{"gzip", compress(t, gzipWriter, content), "gzip", false},
+		{"x-gzip", compress(t, gzipWriter, content), "X-Gzip", false},
+		{"zlib deflate", compress(t, zlibWriter, content), "deflate", false},
+		{"raw deflate", compress(t, flateWriter, content), "deflate", false},
+		{"brotli", compress(t, brotliWriter, content), "br", false},
+		{"chained", compress(t, brotliWriter, compress(t, gzipWriter, content)), "gzip, br", false},
+		{"unsupported", content, "compress", true},
+		{"corrupt gzip", content, "gzip", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeContent(tt.body, tt.encoding)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("decodeContent = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("decodeContent = %q, want %q", got, content)
+			}
+		})
+	}
+}
+
+func TestDecodeContentTooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	zw, _ := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	if _, err := io.CopyN(zw, zeros{}, MaxBodySize+1); err != nil {
+		t.Fatal(err)
+	}
+	zw.Close()
+
+	if _, err := decodeContent(buf.Bytes(), "gzip"); !errors.Is(err, errBodyTooLarge) {
+		t.Errorf("decodeContent error = %v, want %v", err, errBodyTooLarge)
+	}
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
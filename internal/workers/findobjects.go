@@ -1,15 +1,12 @@
 package workers
 
 import (
-	"fmt"
 	"os"
+	"strings"
 	"sync"
 
 	"github.com/deletescape/goop/internal/utils"
 	"github.com/deletescape/jobtracker"
-	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/go-git/go-git/v5/storage/filesystem"
 	"github.com/phuslu/log"
 	"github.com/valyala/fasthttp"
 )
@@ -21,7 +18,17 @@ type FindObjectsContext struct {
 	C       *fasthttp.Client
 	BaseURL string
 	BaseDir string
-	Storage *filesystem.ObjectStorage
+	// GitDir is the path of the git directory relative to BaseURL and BaseDir
+	GitDir string
+	Format utils.ObjectFormat
+	// Packed holds the objects already present in downloaded pack files, their
+	// references have been walked before the workers are started.
+	Packed map[string]bool
+	// Alternates are the URLs of alternate object directories, tried in order
+	// when an object can't be fetched from the repository itself.
+	Alternates []string
+	// History overrides the parents of shallow and grafted commits
+	History *utils.History
 }
 
 func FindObjectsWorker(jt *jobtracker.JobTracker, obj string, context jobtracker.Context) {
@@ -29,86 +36,119 @@ func FindObjectsWorker(jt *jobtracker.JobTracker, obj string, context jobtracker
 
 	checkRatelimted()
 
-	if obj == "" {
+	if !c.Format.IsHash(obj) {
+		return
+	}
+
+	file := utils.URL(c.GitDir, utils.LooseObjectPath(obj))
+	fullPath, err := utils.ConfinedPath(c.BaseDir, file, true)
+	if err != nil {
+		log.Warn().Str("dir", c.BaseDir).Str("file", file).Err(err).Msg("refusing to fetch object")
 		return
 	}
 
 	checkedObjsMutex.Lock()
-	if checked, ok := checkedObjs[obj]; checked && ok {
+	if checked, ok := checkedObjs[fullPath]; checked && ok {
 		// Obj has already been checked
 		checkedObjsMutex.Unlock()
 		return
 	} else {
-		checkedObjs[obj] = true
+		checkedObjs[fullPath] = true
 	}
 	checkedObjsMutex.Unlock()
 
-	file := fmt.Sprintf(".git/objects/%s/%s", obj[:2], obj[2:])
-	fullPath := utils.URL(c.BaseDir, file)
+	if c.Packed[obj] {
+		log.Info().Str("obj", obj).Msg("object is packed, skipping download")
+		return
+	}
+
 	if utils.Exists(fullPath) {
-		log.Info().Str("obj", obj).Msg("already fetched, skipping redownload")
-		encObj, err := c.Storage.EncodedObject(plumbing.AnyObject, plumbing.NewHash(obj))
+		data, err := os.ReadFile(fullPath)
 		if err != nil {
 			log.Error().Str("obj", obj).Err(err).Msg("couldn't read object")
 			return
 		}
-		decObj, err := object.DecodeObject(c.Storage, encObj)
-		if err != nil {
-			log.Error().Str("obj", obj).Err(err).Msg("couldn't decode object")
+		typ, content, err := utils.VerifyLooseObject(data, obj, c.Format)
+		if err == nil {
+			log.Info().Str("obj", obj).Msg("already fetched, skipping redownload")
+			for _, h := range c.History.ReferencedHashes(obj, typ, content, c.Format) {
+				jt.AddJob(h)
+			}
 			return
 		}
-		referencedHashes := utils.GetReferencedHashes(decObj)
-		for _, h := range referencedHashes {
-			jt.AddJob(h)
-		}
-		return
-	}
-
-	uri := utils.URL(c.BaseURL, file)
-	code, body, err := c.C.Get(nil, uri)
-	if err == nil && code != 200 {
-		if code == 429 {
-			setRatelimited()
-			jt.AddJob(obj)
+		log.Warn().Str("obj", obj).Err(err).Msg("fetched object is corrupt, quarantining it")
+		if err := quarantineLooseObject(c.BaseDir, c.GitDir, fullPath, obj, data, err); err != nil {
+			log.Error().Str("obj", obj).Err(err).Msg("couldn't quarantine object")
 			return
 		}
-		log.Warn().Str("obj", obj).Int("code", code).Msg("failed to fetch object")
-		return
-	} else if err != nil {
-		log.Error().Str("obj", obj).Int("code", code).Err(err).Msg("failed to fetch object")
-		return
 	}
 
-	if utils.IsHTML(body) {
-		log.Warn().Str("uri", uri).Msg("file appears to be html, skipping")
-		return
+	uris := []string{utils.URL(c.BaseURL, file)}
+	for _, alt := range c.Alternates {
+		uris = append(uris, utils.URL(alt, strings.TrimPrefix(utils.LooseObjectPath(obj), "objects/")))
 	}
-	if utils.IsEmptyBytes(body) {
-		log.Warn().Str("uri", uri).Msg("file appears to be empty, skipping")
-		return
+	var uri, typ string
+	var body, content []byte
+	var fetchedObj *fetched
+	corrupt := false
+	for _, candidate := range uris {
+		f, err := fetch(c.C, candidate, nil, 0)
+		if err != nil {
+			log.Error().Str("obj", obj).Str("uri", candidate).Err(err).Msg("failed to fetch object")
+			continue
+		}
+		code, resp := f.code, f.bodyFor(file)
+		if code != 200 {
+			if code == 429 {
+				setRatelimited()
+				checkedObjsMutex.Lock()
+				delete(checkedObjs, fullPath)
+				checkedObjsMutex.Unlock()
+				jt.AddJob(obj)
+				return
+			}
+			log.Warn().Str("obj", obj).Str("uri", candidate).Int("code", code).Msg("failed to fetch object")
+			continue
+		}
+
+		if IsNotFound(candidate, resp) {
+			log.Warn().Str("uri", candidate).Msg("file appears to be a not found page, skipping")
+			continue
+		}
+		if utils.IsHTML(resp) {
+			log.Warn().Str("uri", candidate).Msg("file appears to be html, skipping")
+			continue
+		}
+		if utils.IsEmptyBytes(resp) {
+			log.Warn().Str("uri", candidate).Msg("file appears to be empty, skipping")
+			continue
+		}
+		t, cont, err := utils.VerifyLooseObject(resp, obj, c.Format)
+		if err != nil {
+			// a truncated transfer or something in the way, such as a waf
+			log.Warn().Str("obj", obj).Str("uri", candidate).Err(err).Msg("fetched object is corrupt, quarantining it")
+			quarantineObject(c.BaseDir, c.GitDir, f.raw, quarantineRecord{Object: obj, URI: candidate, Status: code, Header: f.header, Reason: err.Error()})
+			corrupt = true
+			continue
+		}
+		uri, body, typ, content, fetchedObj = candidate, resp, t, cont, f
+		break
 	}
-	if err := utils.CreateParentFolders(fullPath); err != nil {
-		log.Error().Str("uri", uri).Str("file", fullPath).Err(err).Msg("couldn't create parent directories")
+	if body == nil {
+		if corrupt && retryObject(fullPath, obj) {
+			jt.AddJob(obj)
+		}
 		return
 	}
-	if err := os.WriteFile(fullPath, body, os.ModePerm); err != nil {
+	if err := utils.WriteConfinedFile(c.BaseDir, file, body, os.ModePerm, true); err != nil {
 		log.Error().Str("uri", uri).Str("file", fullPath).Err(err).Msg("clouldn't write file")
 		return
 	}
+	fetchedObj.recordEncoding(fullPath, uri)
 
 	log.Info().Str("obj", obj).Msg("fetched object")
 
-	encObj, err := c.Storage.EncodedObject(plumbing.AnyObject, plumbing.NewHash(obj))
-	if err != nil {
-		log.Error().Str("obj", obj).Err(err).Msg("couldn't read object")
-		return
-	}
-	decObj, err := object.DecodeObject(c.Storage, encObj)
-	if err != nil {
-		log.Error().Str("obj", obj).Err(err).Msg("couldn't decode object")
-		return
-	}
-	referencedHashes := utils.GetReferencedHashes(decObj)
+	referencedHashes := c.History.ReferencedHashes(obj, typ, content, c.Format)
 	for _, h := range referencedHashes {
 		jt.AddJob(h)
 	}
@@ -24,24 +24,34 @@ type FindRefContext struct {
 	C       *fasthttp.Client
 	BaseURL string
 	BaseDir string
+	// GitDir is the path of the git directory relative to BaseURL and BaseDir
+	GitDir string
 }
 
 func FindRefWorker(jt *jobtracker.JobTracker, path string, context jobtracker.Context) {
 	c := context.(FindRefContext)
+	if path == "" {
+		// the job tracker hands out empty jobs once its queue is drained
+		return
+	}
 
 	checkRatelimted()
 
+	targetFile, err := utils.ConfinedPath(c.BaseDir, path, true)
+	if err != nil {
+		log.Warn().Str("dir", c.BaseDir).Str("file", path).Err(err).Msg("refusing to fetch ref")
+		return
+	}
+
 	checkedRefsMutex.Lock()
-	if checked, ok := checkedRefs[path]; checked && ok {
+	if checked, ok := checkedRefs[targetFile]; checked && ok {
 		// Ref has already been checked
 		checkedRefsMutex.Unlock()
 		return
 	} else {
-		checkedRefs[path] = true
+		checkedRefs[targetFile] = true
 	}
 	checkedRefsMutex.Unlock()
-
-	targetFile := utils.URL(c.BaseDir, path)
 	if utils.Exists(targetFile) {
 		log.Info().Str("file", targetFile).Msg("already fetched, skipping redownload")
 		content, err := os.ReadFile(targetFile)
@@ -50,17 +60,17 @@ func FindRefWorker(jt *jobtracker.JobTracker, path string, context jobtracker.Co
 			return
 		}
 		for _, ref := range refRegex.FindAll(content, -1) {
-			jt.AddJob(utils.URL(".git", string(ref)))
-			jt.AddJob(utils.URL(".git/logs", string(ref)))
+			jt.AddJob(utils.URL(c.GitDir, string(ref)))
+			jt.AddJob(utils.URL(c.GitDir, "logs/"+string(ref)))
 		}
-		if path == ".git/FETCH_HEAD" {
+		if path == utils.URL(c.GitDir, "FETCH_HEAD") {
 			// TODO figure out actual remote instead of just assuming origin here (if possible)
 			for _, branch := range branchRegex.FindAllSubmatch(content, -1) {
-				jt.AddJob(fmt.Sprintf(".git/refs/remotes/origin/%s", branch[1]))
-				jt.AddJob(fmt.Sprintf(".git/logs/refs/remotes/origin/%s", branch[1]))
+				jt.AddJob(fmt.Sprintf("%s/refs/remotes/origin/%s", c.GitDir, branch[1]))
+				jt.AddJob(fmt.Sprintf("%s/logs/refs/remotes/origin/%s", c.GitDir, branch[1]))
 			}
 		}
-		if path == ".git/config" || path == ".git/config.worktree" {
+		if path == utils.URL(c.GitDir, "config") || path == utils.URL(c.GitDir, "config.worktree") {
 			cfg, err := ini.Load(content)
 			if err != nil {
 				log.Error().Str("file", targetFile).Err(err).Msg("failed to parse git config")
@@ -72,8 +82,8 @@ func FindRefWorker(jt *jobtracker.JobTracker, path string, context jobtracker.Co
 					branch := strings.Trim(parts[1], `"`)
 					remote := sec.Key("remote").String()
 
-					jt.AddJob(fmt.Sprintf(".git/refs/remotes/%s/%s", remote, branch))
-					jt.AddJob(fmt.Sprintf(".git/logs/refs/remotes/%s/%s", remote, branch))
+					jt.AddJob(fmt.Sprintf("%s/refs/remotes/%s/%s", c.GitDir, remote, branch))
+					jt.AddJob(fmt.Sprintf("%s/logs/refs/remotes/%s/%s", c.GitDir, remote, branch))
 				}
 			}
 		}
@@ -81,8 +91,12 @@ func FindRefWorker(jt *jobtracker.JobTracker, path string, context jobtracker.Co
 	}
 
 	uri := utils.URL(c.BaseURL, path)
-	code, body, err := c.C.Get(nil, uri)
-	if err == nil && code != 200 {
+	resp, err := fetch(c.C, uri, nil, 0)
+	if err != nil {
+		log.Error().Str("uri", uri).Err(err).Msg("failed to fetch ref")
+		return
+	}
+	if code := resp.code; code != 200 {
 		if code == 429 {
 			setRatelimited()
 			jt.AddJob(path)
@@ -90,11 +104,13 @@ func FindRefWorker(jt *jobtracker.JobTracker, path string, context jobtracker.Co
 		}
 		log.Warn().Str("uri", uri).Int("code", code).Msg("failed to fetch ref")
 		return
-	} else if err != nil {
-		log.Error().Str("uri", uri).Int("code", code).Err(err).Msg("failed to fetch ref")
-		return
 	}
+	body := resp.bodyFor(path)
 
+	if IsNotFound(uri, body) {
+		log.Warn().Str("uri", uri).Msg("file appears to be a not found page, skipping")
+		return
+	}
 	if utils.IsHTML(body) {
 		log.Warn().Str("uri", uri).Msg("file appears to be html, skipping")
 		return
@@ -103,29 +119,26 @@ func FindRefWorker(jt *jobtracker.JobTracker, path string, context jobtracker.Co
 		log.Warn().Str("uri", uri).Msg("file appears to be empty, skipping")
 		return
 	}
-	if err := utils.CreateParentFolders(targetFile); err != nil {
-		log.Error().Str("uri", uri).Str("file", targetFile).Err(err).Msg("couldn't create parent directories")
-		return
-	}
-	if err := os.WriteFile(targetFile, body, os.ModePerm); err != nil {
+	if err := utils.WriteConfinedFile(c.BaseDir, path, body, os.ModePerm, true); err != nil {
 		log.Error().Str("uri", uri).Str("file", targetFile).Err(err).Msg("clouldn't write file")
 		return
 	}
+	resp.recordEncoding(targetFile, uri)
 
 	log.Info().Str("uri", uri).Msg("fetched ref")
 
 	for _, ref := range refRegex.FindAll(body, -1) {
-		jt.AddJob(utils.URL(".git", string(ref)))
-		jt.AddJob(utils.URL(".git/logs", string(ref)))
+		jt.AddJob(utils.URL(c.GitDir, string(ref)))
+		jt.AddJob(utils.URL(c.GitDir, "logs/"+string(ref)))
 	}
-	if path == ".git/FETCH_HEAD" {
+	if path == utils.URL(c.GitDir, "FETCH_HEAD") {
 		// TODO figure out actual remote instead of just assuming origin here (if possible)
 		for _, branch := range branchRegex.FindAllSubmatch(body, -1) {
-			jt.AddJob(fmt.Sprintf(".git/refs/remotes/origin/%s", branch[1]))
-			jt.AddJob(fmt.Sprintf(".git/logs/refs/remotes/origin/%s", branch[1]))
+			jt.AddJob(fmt.Sprintf("%s/refs/remotes/origin/%s", c.GitDir, branch[1]))
+			jt.AddJob(fmt.Sprintf("%s/logs/refs/remotes/origin/%s", c.GitDir, branch[1]))
 		}
 	}
-	if path == ".git/config" || path == ".git/config.worktree" {
+	if path == utils.URL(c.GitDir, "config") || path == utils.URL(c.GitDir, "config.worktree") {
 		cfg, err := ini.Load(body)
 		if err != nil {
 			log.Error().Str("file", targetFile).Err(err).Msg("failed to parse git config")
@@ -137,8 +150,8 @@ func FindRefWorker(jt *jobtracker.JobTracker, path string, context jobtracker.Co
 				branch := strings.Trim(parts[1], `"`)
 				remote := sec.Key("remote").String()
 
-				jt.AddJob(fmt.Sprintf(".git/refs/remotes/%s/%s", remote, branch))
-				jt.AddJob(fmt.Sprintf(".git/logs/refs/remotes/%s/%s", remote, branch))
+				jt.AddJob(fmt.Sprintf("%s/refs/remotes/%s/%s", c.GitDir, remote, branch))
+				jt.AddJob(fmt.Sprintf("%s/logs/refs/remotes/%s/%s", c.GitDir, remote, branch))
 			}
 		}
 	}
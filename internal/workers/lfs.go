@@ -0,0 +1,63 @@
+package workers
+
+import (
+	"net/url"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/deletescape/jobtracker"
+	"github.com/phuslu/log"
+	"github.com/valyala/fasthttp"
+)
+
+type LfsDownloadContext struct {
+	C *fasthttp.Client
+	// GitDir is the git directory the objects are stored in
+	GitDir string
+	// Objects maps the oid of every object to download to its batch API entry
+	Objects map[string]utils.LfsBatchObject
+	// Hosts are the hosts downloads may be redirected to, "*" allowing any
+	Hosts []string
+}
+
+func LfsDownloadWorker(jt *jobtracker.JobTracker, oid string, context jobtracker.Context) {
+	c := context.(LfsDownloadContext)
+	checkRatelimted()
+
+	obj, ok := c.Objects[oid]
+	if !ok {
+		return
+	}
+	targetFile, err := utils.ConfinedPath(c.GitDir, utils.LfsObjectPath(oid), true)
+	if err != nil {
+		log.Warn().Str("dir", c.GitDir).Str("oid", oid).Err(err).Msg("refusing to fetch lfs object")
+		return
+	}
+	if utils.Exists(targetFile) {
+		log.Info().Str("file", targetFile).Msg("already fetched, skipping redownload")
+		return
+	}
+	action := obj.Actions["download"]
+
+	follow := func(to *url.URL) bool {
+		if utils.LfsHostAllowed(c.Hosts, to.Hostname()) {
+			return true
+		}
+		log.Warn().Str("oid", oid).Str("host", to.Hostname()).Msg("lfs download redirects off the allowed hosts, not following it (allow it with --lfs-hosts)")
+		return false
+	}
+	code, err := streamFile(c.C, action.Href, targetFile, action.Header, follow, nil, obj.VerifyFile)
+	if err != nil {
+		log.Error().Str("oid", oid).Str("uri", action.Href).Err(err).Msg("couldn't fetch lfs object")
+		return
+	}
+	if code != 200 {
+		if code == 429 {
+			setRatelimited()
+			jt.AddJob(oid)
+			return
+		}
+		log.Warn().Str("oid", oid).Str("uri", action.Href).Int("code", code).Msg("couldn't fetch lfs object")
+		return
+	}
+	log.Info().Str("oid", oid).Str("file", targetFile).Msg("fetched lfs object")
+}
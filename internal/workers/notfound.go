@@ -0,0 +1,51 @@
+package workers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/phuslu/log"
+	"github.com/valyala/fasthttp"
+)
+
+var notFoundPages utils.NotFoundPages
+
+// FingerprintNotFound requests a few random paths that can't exist, under
+// .git/ and at the webroot, and remembers whatever the server answers with
+// 200 for them, so the workers can skip responses like those.
+func FingerprintNotFound(c *fasthttp.Client, baseURL string) {
+	notFoundPages.Reset()
+	probes := []string{
+		randomName(),
+		randomName() + ".txt",
+		randomName() + "/",
+		".git/" + randomName(),
+		".git/refs/heads/" + randomName(),
+		".git/objects/" + randomName()[:2] + "/" + randomName(),
+		".git/" + randomName() + "/",
+	}
+	for _, file := range probes {
+		uri := utils.URL(baseURL, file)
+		code, body, err := Get(c, uri)
+		if err != nil || code != 200 || utils.IsEmptyBytes(body) {
+			continue
+		}
+		notFoundPages.Add(uri, body)
+	}
+	if n := notFoundPages.Len(); n > 0 {
+		log.Warn().Str("base", baseURL).Int("pages", n).Msg("server answers paths that don't exist with 200, skipping responses like those")
+	}
+}
+
+// IsNotFound reports whether the body fetched from uri looks like what the
+// server answers for paths that don't exist.
+func IsNotFound(uri string, body []byte) bool {
+	return notFoundPages.Match(uri, body)
+}
+
+func randomName() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
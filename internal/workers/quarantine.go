@@ -0,0 +1,101 @@
+package workers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/phuslu/log"
+	"github.com/valyala/fasthttp"
+)
+
+// how often an object that came back corrupt is requested again
+const maxObjectRetries = 2
+
+var objectRetries = make(map[string]int)
+
+type quarantineRecord struct {
+	Object string            `json:"object,omitempty"`
+	File   string            `json:"file,omitempty"`
+	URI    string            `json:"uri,omitempty"`
+	Status int               `json:"status,omitempty"`
+	Header map[string]string `json:"header,omitempty"`
+	Reason string            `json:"reason"`
+	Time   time.Time         `json:"time"`
+}
+
+// quarantine puts a file named name in the quarantine directory of gitDir
+// with store, next to a json file describing where it came from. gitDir is
+// relative to baseDir.
+func quarantine(baseDir, gitDir, name string, record quarantineRecord, store func(target string) error) error {
+	record.Time = time.Now()
+	target := path.Join(gitDir, "quarantine", fmt.Sprintf("%s.%d", name, record.Time.UnixNano()))
+	if err := store(target); err != nil {
+		return err
+	}
+	meta, _ := json.MarshalIndent(record, "", "  ")
+	return utils.WriteConfinedFile(baseDir, target+".json", meta, 0644, true)
+}
+
+// quarantineObject keeps a body that should have been the object obj, but
+// isn't, in the quarantine directory of the git directory.
+func quarantineObject(baseDir, gitDir string, body []byte, record quarantineRecord) {
+	err := quarantine(baseDir, gitDir, record.Object, record, func(target string) error {
+		return utils.WriteConfinedFile(baseDir, target, body, 0644, true)
+	})
+	if err != nil {
+		log.Error().Str("obj", record.Object).Err(err).Msg("couldn't quarantine object")
+	}
+}
+
+// QuarantineFile moves a file of gitDir that turned out to be corrupt, such
+// as a pack that doesn't match its index, to the quarantine directory, so
+// that git doesn't trip over it and it can be fetched again.
+func QuarantineFile(gitDir, file, reason string) {
+	name := filepath.Base(file)
+	err := quarantine(gitDir, "", name, quarantineRecord{File: name, Reason: reason}, func(target string) error {
+		dst, err := utils.ConfinedPath(gitDir, target, true)
+		if err != nil {
+			return err
+		}
+		if err := utils.CreateParentFolders(dst); err != nil {
+			return err
+		}
+		return os.Rename(file, dst)
+	})
+	if err != nil {
+		log.Error().Str("dir", gitDir).Str("file", file).Err(err).Msg("couldn't quarantine file")
+	}
+}
+
+// retryObject reports whether obj may be requested once more after it came
+// back corrupt, and lets it be checked again if so.
+func retryObject(fullPath, obj string) bool {
+	checkedObjsMutex.Lock()
+	defer checkedObjsMutex.Unlock()
+	if objectRetries[obj] >= maxObjectRetries {
+		return false
+	}
+	objectRetries[obj]++
+	delete(checkedObjs, fullPath)
+	return true
+}
+
+func headerMap(h *fasthttp.ResponseHeader) map[string]string {
+	header := make(map[string]string)
+	h.VisitAll(func(k, v []byte) {
+		header[string(k)] = string(v)
+	})
+	return header
+}
+
+// quarantineLooseObject moves a loose object already in the dump that turned
+// out to be corrupt to the quarantine directory, so it can be fetched again.
+func quarantineLooseObject(baseDir, gitDir, fullPath, obj string, body []byte, reason error) error {
+	quarantineObject(baseDir, gitDir, body, quarantineRecord{Object: obj, Reason: reason.Error()})
+	return os.Remove(fullPath)
+}
@@ -19,18 +19,34 @@ type RecursiveDownloadContext struct {
 
 func RecursiveDownloadWorker(jt *jobtracker.JobTracker, f string, context jobtracker.Context) {
 	c := context.(RecursiveDownloadContext)
+	if f == "" {
+		// the job tracker hands out empty jobs once its queue is drained
+		return
+	}
 
 	checkRatelimted()
 
-	filePath := utils.URL(c.BaseDir, f)
+	filePath, err := utils.ConfinedPath(c.BaseDir, f, true)
+	if err != nil {
+		log.Warn().Str("dir", c.BaseDir).Str("file", f).Err(err).Msg("refusing to fetch file")
+		return
+	}
 	isDir := strings.HasSuffix(f, "/")
 	if !isDir && utils.Exists(filePath) {
 		log.Info().Str("file", filePath).Msg("already fetched, skipping redownload")
 		return
 	}
 	uri := utils.URL(c.BaseURL, f)
-	code, body, err := c.C.Get(nil, uri)
-	if err == nil && code != 200 {
+	if !isDir && isStreamed(filePath) {
+		downloadStreamed(jt, c.C, f, uri, filePath)
+		return
+	}
+	resp, err := fetch(c.C, uri, nil, 0)
+	if err != nil {
+		log.Error().Str("uri", uri).Err(err).Msg("failed to fetch file")
+		return
+	}
+	if code := resp.code; code != 200 {
 		if code == 429 {
 			setRatelimited()
 			jt.AddJob(f)
@@ -38,11 +54,13 @@ func RecursiveDownloadWorker(jt *jobtracker.JobTracker, f string, context jobtra
 		}
 		log.Warn().Str("uri", uri).Int("code", code).Msg("failed to fetch file")
 		return
-	} else if err != nil {
-		log.Error().Str("uri", uri).Int("code", code).Err(err).Msg("failed to fetch file")
-		return
 	}
+	body := resp.bodyFor(f)
 
+	if IsNotFound(uri, body) {
+		log.Warn().Str("uri", uri).Msg("file appears to be a not found page, skipping")
+		return
+	}
 	if isDir {
 		if !utils.IsHTML(body) {
 			log.Warn().Str("uri", uri).Msg("not a directory index, skipping")
@@ -60,14 +78,11 @@ func RecursiveDownloadWorker(jt *jobtracker.JobTracker, f string, context jobtra
 			jt.AddJob(utils.URL(f, idxf))
 		}
 	} else {
-		if err := utils.CreateParentFolders(filePath); err != nil {
-			log.Error().Str("file", filePath).Err(err).Msg("couldn't create parent directories")
-			return
-		}
-		if err := os.WriteFile(filePath, body, os.ModePerm); err != nil {
+		if err := utils.WriteConfinedFile(c.BaseDir, f, body, os.ModePerm, true); err != nil {
 			log.Error().Str("file", filePath).Err(err).Msg("couldn't write to file")
 			return
 		}
+		resp.recordEncoding(filePath, uri)
 		log.Info().Str("uri", uri).Msg("fetched file")
 	}
 }
@@ -0,0 +1,333 @@
+package workers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/deletescape/jobtracker"
+	"github.com/phuslu/log"
+	"github.com/valyala/fasthttp"
+)
+
+// large files are fetched in chunks of this size with range requests, so only
+// one chunk of them is held in memory at a time
+const streamChunkSize = 8 << 20
+
+// how many redirects a download follows, if it follows any
+const maxStreamRedirects = 5
+
+// how often a chunk is requested again after the connection dropped, before
+// leaving the download to be resumed later
+const maxChunkRetries = 3
+
+var streamedFileRegex = regexp.MustCompile(`(^|/)objects/pack/[^/]+\.(pack|idx|rev|bitmap|midx)$`)
+var lfsObjectRegex = regexp.MustCompile(`(^|/)lfs/objects/([0-9a-f]{2})/([0-9a-f]{2})/([0-9a-f]{64})$`)
+var checksummedFileRegex = regexp.MustCompile(`(?:pack|multi-pack-index)-([0-9a-f]{40}|[0-9a-f]{64})\.(?:pack|idx|rev|bitmap|midx)$`)
+
+var errNotFile = errors.New("response doesn't appear to be the file")
+
+// how much of the start of a large file is checked to be the file, rather than
+// an error page
+const streamSniffSize = 1 << 20
+
+// bodies larger than this are handed out as a stream by the clients returned
+// by StreamClient, rather than read into memory
+const streamBufferSize = 64 << 10
+
+var streamClients sync.Map
+
+// StreamClient returns a client set up like c that streams response bodies,
+// which have to be read with BodyStream.
+func StreamClient(c *fasthttp.Client) *fasthttp.Client {
+	if sc, ok := streamClients.Load(c); ok {
+		return sc.(*fasthttp.Client)
+	}
+	sc, _ := streamClients.LoadOrStore(c, &fasthttp.Client{
+		Name:                          c.Name,
+		NoDefaultUserAgentHeader:      c.NoDefaultUserAgentHeader,
+		Dial:                          c.Dial,
+		DialDualStack:                 c.DialDualStack,
+		TLSConfig:                     c.TLSConfig,
+		MaxConnsPerHost:               c.MaxConnsPerHost,
+		MaxIdleConnDuration:           c.MaxIdleConnDuration,
+		MaxConnDuration:               c.MaxConnDuration,
+		MaxIdemponentCallAttempts:     c.MaxIdemponentCallAttempts,
+		ReadTimeout:                   c.ReadTimeout,
+		WriteTimeout:                  c.WriteTimeout,
+		MaxConnWaitTimeout:            c.MaxConnWaitTimeout,
+		DisableHeaderNamesNormalizing: c.DisableHeaderNamesNormalizing,
+		DisablePathNormalizing:        c.DisablePathNormalizing,
+		MaxResponseBodySize:           streamBufferSize,
+		StreamResponseBody:            true,
+	})
+	return sc.(*fasthttp.Client)
+}
+
+// isStreamed reports whether file can get too large to be fetched in one go.
+func isStreamed(file string) bool {
+	return streamedFileRegex.MatchString(file) || lfsObjectOid(file) != ""
+}
+
+// lfsObjectOid returns the oid of the lfs object stored as file, or "" if
+// file isn't where lfs would store the object its name says it is.
+func lfsObjectOid(file string) string {
+	m := lfsObjectRegex.FindStringSubmatch(file)
+	if m == nil || m[4][:2] != m[2] || m[4][2:4] != m[3] {
+		return ""
+	}
+	return m[4]
+}
+
+// checksumVerifier returns a check that the file downloaded as file ends with
+// the checksum of its contents, in the object format implied by the hash in
+// its name, or hashes to its name for lfs objects, or nil if file is neither.
+func checksumVerifier(file string) func(string) error {
+	if oid := lfsObjectOid(file); oid != "" {
+		return func(path string) error {
+			return utils.VerifyLfsObject(path, oid)
+		}
+	}
+	m := checksummedFileRegex.FindStringSubmatch(file)
+	if m == nil {
+		return nil
+	}
+	format := utils.SHA1
+	if len(m[1]) == utils.SHA256.HexSize {
+		format = utils.SHA256
+	}
+	return func(path string) error {
+		return utils.VerifyChecksumTrailer(path, format)
+	}
+}
+
+// downloadStreamed fetches a large file for the download workers with
+// streamFile, queueing it again when we get rate limited.
+func downloadStreamed(jt *jobtracker.JobTracker, c *fasthttp.Client, job, uri, targetFile string) {
+	looksRight := looksLikeFile
+	if lfsObjectOid(targetFile) != "" {
+		// an lfs object can be anything, html included, its oid is checked
+		// once it's complete
+		looksRight = nil
+	}
+	code, err := streamFile(c, uri, targetFile, nil, nil, looksRight, checksumVerifier(targetFile))
+	if err != nil {
+		log.Error().Str("uri", uri).Str("file", targetFile).Err(err).Msg("couldn't fetch file")
+		return
+	}
+	if code != 200 {
+		if code == 429 {
+			setRatelimited()
+			jt.AddJob(job)
+			return
+		}
+		log.Warn().Str("uri", uri).Int("code", code).Msg("couldn't fetch file")
+		return
+	}
+	log.Info().Str("uri", uri).Str("file", targetFile).Msg("fetched file")
+}
+
+// streamFile fetches uri to target in chunks, appending them to target.part,
+// so that a download that was interrupted, in this run or an earlier one,
+// picks up where it stopped. Redirects are only followed if follow allows
+// them. The start of the file is checked with looksRight, and once complete,
+// the file is checked with verify and moved in place. It returns 200 on
+// success, or the status code the server answered with otherwise.
+func streamFile(c *fasthttp.Client, uri, target string, header map[string]string, follow func(*url.URL) bool, looksRight func(string, []byte) bool, verify func(string) error) (int, error) {
+	part := target + ".part"
+	if info, err := os.Lstat(part); err == nil && !info.Mode().IsRegular() {
+		if err := os.Remove(part); err != nil {
+			return 0, err
+		}
+	}
+	if err := utils.CreateParentFolders(part); err != nil {
+		return 0, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		resumed, code, err := streamToPart(c, uri, part, header, follow, looksRight)
+		if err != nil || code != 200 {
+			// keep what we got of the file to resume from, but nothing else
+			if info, statErr := os.Stat(part); statErr == nil && info.Size() == 0 {
+				os.Remove(part)
+			}
+			return code, err
+		}
+		if verify != nil {
+			if err := verify(part); err != nil {
+				os.Remove(part)
+				// what we resumed from may have been another version of the file
+				if resumed && attempt == 0 {
+					log.Warn().Str("uri", uri).Err(err).Msg("resumed download is corrupt, starting over")
+					continue
+				}
+				return 0, err
+			}
+		}
+		return 200, os.Rename(part, target)
+	}
+}
+
+func streamToPart(c *fasthttp.Client, uri, part string, header map[string]string, follow func(*url.URL) bool, looksRight func(string, []byte) bool) (bool, int, error) {
+	out, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return false, 0, err
+	}
+	defer out.Close()
+	info, err := out.Stat()
+	if err != nil {
+		return false, 0, err
+	}
+	offset := info.Size()
+	resumed := offset > 0
+	if resumed {
+		log.Info().Str("uri", uri).Int64("offset", offset).Msg("resuming download")
+	}
+
+	retries := 0
+	for {
+		code, total, n, err := fetchRange(c, uri, header, follow, out, offset, looksRight)
+		if code == 200 {
+			// the server ignored the range, so the file starts over
+			resumed, offset = false, 0
+		}
+		offset += n
+		if errors.Is(err, errNotFile) {
+			out.Truncate(0)
+			return resumed, 0, err
+		}
+		if err != nil {
+			if retries < maxChunkRetries {
+				retries++
+				time.Sleep(time.Duration(retries) * time.Second)
+				log.Warn().Str("uri", uri).Int64("offset", offset).Err(err).Msg("download interrupted, resuming")
+				continue
+			}
+			return resumed, 0, err
+		}
+		retries = 0
+
+		switch code {
+		case 206:
+			if n == 0 || (total >= 0 && offset >= total) || (total < 0 && n < streamChunkSize) {
+				return resumed, 200, nil
+			}
+		case 200:
+			return resumed, 200, nil
+		case 416:
+			// nothing is left after what we already have
+			if offset > 0 {
+				return resumed, 200, nil
+			}
+			return resumed, code, nil
+		default:
+			return resumed, code, nil
+		}
+	}
+}
+
+// fetchRange requests a chunk of uri starting at offset, and copies it to out
+// as it arrives. A server ignoring the range sends the whole file, which is
+// copied over out from its start. The start of the file is checked with
+// looksRight first. It returns the status code, the total size of the file if
+// the server told it, and how much was written, even if the connection
+// dropped along the way.
+func fetchRange(c *fasthttp.Client, uri string, header map[string]string, follow func(*url.URL) bool, out *os.File, offset int64, looksRight func(string, []byte) bool) (int, int64, int64, error) {
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	if err := doFollowing(StreamClient(c), uri, header, follow, offset, resp); err != nil {
+		return 0, 0, 0, err
+	}
+	defer resp.CloseBodyStream()
+
+	code, total := resp.StatusCode(), int64(-1)
+	switch code {
+	case 206:
+		var start, end int64
+		var size string
+		if _, err := fmt.Sscanf(string(resp.Header.Peek("Content-Range")), "bytes %d-%d/%s", &start, &end, &size); err != nil {
+			return 0, 0, 0, fmt.Errorf("malformed content range: %w", err)
+		}
+		if start != offset {
+			return 0, 0, 0, fmt.Errorf("server sent a range starting at %d instead of %d", start, offset)
+		}
+		fmt.Sscanf(size, "%d", &total)
+	case 200:
+		offset = 0
+	default:
+		return code, total, 0, nil
+	}
+
+	body := resp.BodyStream()
+	if offset == 0 && looksRight != nil {
+		start, err := io.ReadAll(io.LimitReader(body, streamSniffSize))
+		if err != nil {
+			return code, total, 0, err
+		}
+		if !looksRight(uri, start) {
+			return code, total, 0, errNotFile
+		}
+		body = io.MultiReader(bytes.NewReader(start), body)
+	}
+	if code == 200 {
+		if err := out.Truncate(0); err != nil {
+			return code, total, 0, err
+		}
+	}
+	n, err := io.Copy(io.NewOffsetWriter(out, offset), body)
+	return code, total, n, err
+}
+
+// doFollowing requests a chunk of uri starting at offset, following the
+// redirects follow allows one hop at a time. The Authorization header is only
+// sent to the host of uri.
+func doFollowing(c *fasthttp.Client, uri string, header map[string]string, follow func(*url.URL) bool, offset int64, resp *fasthttp.Response) error {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	origin, err := url.Parse(uri)
+	if err != nil {
+		return err
+	}
+	target := origin
+	for redirects := 0; ; redirects++ {
+		req.Reset()
+		req.SetRequestURI(target.String())
+		for k, v := range header {
+			if strings.EqualFold(k, "Authorization") && !strings.EqualFold(target.Host, origin.Host) {
+				continue
+			}
+			req.Header.Set(k, v)
+		}
+		// ranges apply to the encoded body, which we couldn't decode piece by piece
+		req.Header.Set("Accept-Encoding", "identity")
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+streamChunkSize-1))
+		if err := c.Do(req, resp); err != nil {
+			return err
+		}
+		location := string(resp.Header.Peek("Location"))
+		if follow == nil || !fasthttp.StatusCodeIsRedirect(resp.StatusCode()) || location == "" || redirects >= maxStreamRedirects {
+			return nil
+		}
+		next, err := target.Parse(location)
+		if err != nil || (next.Scheme != "http" && next.Scheme != "https") || !follow(next) {
+			return nil
+		}
+		resp.CloseBodyStream()
+		target = next
+	}
+}
+
+// looksLikeFile runs the checks the download workers run on a body against
+// the start of a large file.
+func looksLikeFile(uri string, body []byte) bool {
+	start := body[:min(len(body), 1024)]
+	return !utils.IsEmptyBytes(body) && !utils.IsHTML(start) && !IsNotFound(uri, body)
+}
@@ -0,0 +1,67 @@
+package workers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/valyala/fasthttp"
+)
+
+func TestIsStreamed(t *testing.T) {
+	oid := strings.Repeat("ab", 32)
+	tests := []struct {
+		file string
+		want bool
+	}{
+		{".git/objects/pack/pack-" + strings.Repeat("a", 40) + ".pack", true},
+		{".git/objects/pack/multi-pack-index", false},
+		{".git/lfs/objects/ab/ab/" + oid, true},
+		{"out/.git/lfs/objects/ab/ab/" + oid, true},
+		{".git/lfs/objects/ab/cd/" + oid, false},
+		{".git/lfs/objects/ab/ab/" + oid[:40], false},
+		{".git/lfs/objects/ab/ab/" + oid + ".part", false},
+		{".git/objects/ab/" + strings.Repeat("c", 38), false},
+	}
+	for _, tt := range tests {
+		if got := isStreamed(tt.file); got != tt.want {
+			t.Errorf("isStreamed(%q) = %v, want %v", tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadStreamedLfsObject(t *testing.T) {
+	content := "<html>an lfs object can be anything</html>\n"
+	sum := sha256.Sum256([]byte(content))
+	good := hex.EncodeToString(sum[:])
+	bad := strings.Repeat("0", 64)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Unix(0, 0), strings.NewReader(content))
+	}))
+	defer srv.Close()
+	c := &fasthttp.Client{}
+	dir := t.TempDir()
+
+	for oid, want := range map[string]bool{good: true, bad: false} {
+		target := filepath.Join(dir, ".git", utils.LfsObjectPath(oid))
+		downloadStreamed(nil, c, oid, srv.URL+"/"+oid, target)
+		got, err := os.ReadFile(target)
+		if want && (err != nil || string(got) != content) {
+			t.Errorf("object %s = %q, %v", oid, got, err)
+		}
+		if !want && err == nil {
+			t.Errorf("object %s not matching its oid was written", oid)
+		}
+		if _, err := os.Stat(target + ".part"); err == nil {
+			t.Errorf("object %s was left as a partial download", oid)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package goop
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/deletescape/goop/internal/workers"
+	"github.com/deletescape/jobtracker"
+	"github.com/phuslu/log"
+)
+
+// git refuses to follow alternates nested deeper than this as well
+const maxAlternateDepth = 5
+
+// findAlternates follows objects/info/alternates and objects/info/http-alternates
+// of the dumped repository, and of every alternate found that way, returning
+// the URLs of the alternate object directories.
+func findAlternates(baseURL string, repo repository) []string {
+	objectsURL := utils.URL(baseURL, repo.path(".git/objects"))
+	seen := map[string]bool{objectsURL: true}
+	var alternates []string
+
+	queue := []string{objectsURL}
+	for depth := 0; depth < maxAlternateDepth && len(queue) > 0; depth++ {
+		var next []string
+		for _, dir := range queue {
+			for _, file := range []string{"info/alternates", "info/http-alternates"} {
+				uri := utils.URL(dir, file)
+				code, body, err := workers.Get(c, uri)
+				if err != nil || code != 200 || utils.IsHTML(body) || workers.IsNotFound(uri, body) {
+					continue
+				}
+				scanner := bufio.NewScanner(bytes.NewReader(body))
+				for scanner.Scan() {
+					line := strings.TrimSpace(scanner.Text())
+					if line == "" || strings.HasPrefix(line, "#") {
+						continue
+					}
+					alt := resolveAlternate(dir, line, file == "info/http-alternates")
+					if alt == "" {
+						log.Warn().Str("uri", uri).Str("alternate", line).Msg("couldn't resolve alternate object directory")
+						continue
+					}
+					if seen[alt] {
+						continue
+					}
+					seen[alt] = true
+					log.Info().Str("uri", uri).Str("alternate", alt).Msg("found alternate object directory")
+					alternates = append(alternates, alt)
+					next = append(next, alt)
+				}
+			}
+		}
+		queue = next
+	}
+	return alternates
+}
+
+// resolveAlternate maps a line of an alternates file in the object directory
+// at objectsURL to the URL of the object directory it points to. Relative
+// paths are resolved against objectsURL. Absolute paths in alternates are
+// paths on the server's file system, so every suffix of them is tried as a
+// path below the web root.
+func resolveAlternate(objectsURL, line string, httpAlternate bool) string {
+	base, err := url.Parse(objectsURL + "/")
+	if err != nil {
+		return ""
+	}
+	if httpAlternate && (strings.HasPrefix(line, "http://") || strings.HasPrefix(line, "https://")) {
+		alt, err := url.Parse(line)
+		if err != nil || alt.Host != base.Host {
+			// don't let the target send us to other hosts
+			return ""
+		}
+		return strings.TrimSuffix(alt.String(), "/")
+	}
+	if !strings.HasPrefix(line, "/") || httpAlternate {
+		return strings.TrimSuffix(base.ResolveReference(&url.URL{Path: line}).String(), "/")
+	}
+
+	parts := strings.Split(strings.Trim(line, "/"), "/")
+	for i := range parts {
+		candidate := base.ResolveReference(&url.URL{Path: "/" + strings.Join(parts[i:], "/") + "/"})
+		for _, probe := range []string{"../HEAD", "info/packs"} {
+			uri := candidate.ResolveReference(&url.URL{Path: probe}).String()
+			code, body, err := workers.Get(c, uri)
+			if err == nil && code == 200 && !utils.IsHTML(body) && !utils.IsEmptyBytes(body) && !workers.IsNotFound(uri, body) {
+				return strings.TrimSuffix(candidate.String(), "/")
+			}
+		}
+	}
+	return ""
+}
+
+// fetchAlternatePacks downloads the pack files listed in the objects/info/packs
+// of every alternate into the object directory of the dumped repository.
+func fetchAlternatePacks(gitDir string, alternates []string) {
+	objectsDir := utils.URL(gitDir, "objects")
+	for _, alt := range alternates {
+		uri := utils.URL(alt, "info/packs")
+		code, body, err := workers.Get(c, uri)
+		if err != nil || code != 200 || utils.IsHTML(body) || workers.IsNotFound(uri, body) {
+			continue
+		}
+		log.Info().Str("alternate", alt).Msg("fetching packs of alternate")
+		jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+		for _, hash := range packRegex.FindAllSubmatch(body, -1) {
+			jt.AddJobs(
+				fmt.Sprintf("pack/pack-%s.idx", hash[1]),
+				fmt.Sprintf("pack/pack-%s.pack", hash[1]),
+			)
+		}
+		jt.StartAndWait(workers.DownloadContext{C: c, BaseURL: alt, BaseDir: objectsDir}, false)
+	}
+}
@@ -0,0 +1,218 @@
+package goop
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/deletescape/goop/internal/workers"
+	"github.com/deletescape/jobtracker"
+	"github.com/phuslu/log"
+	"gopkg.in/ini.v1"
+)
+
+// FetchBzr dumps the Bazaar checkout exposed at baseURL, rebuilding the tree
+// of its last revision from the texts stored in the packs of its repository.
+func FetchBzr(baseURL, baseDir string) error {
+	log.Info().Str("base", baseURL).Msg("testing if recursive download is possible")
+	code, body, err := workers.Get(c, utils.URL(baseURL, ".bzr/"))
+	if err != nil && !utils.IgnoreError(err) {
+		return err
+	}
+	if code == 200 && utils.IsHTML(body) && !workers.IsNotFound(utils.URL(baseURL, ".bzr/"), body) {
+		lnk, _ := url.Parse(utils.URL(baseURL, ".bzr/"))
+		indexedFiles, err := utils.GetIndexedFiles(body, lnk.Path)
+		if err != nil {
+			return err
+		}
+		if utils.StringsContain(indexedFiles, "branch-format") {
+			log.Info().Str("base", baseURL).Msg("fetching .bzr/ recursively")
+			jt := jobtracker.NewJobTracker(workers.RecursiveDownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+			jt.AddJobs(indexedFiles...)
+			jt.StartAndWait(workers.RecursiveDownloadContext{C: c, BaseURL: utils.URL(baseURL, ".bzr/"), BaseDir: utils.URL(baseDir, ".bzr/")}, true)
+		}
+	}
+
+	log.Info().Str("base", baseURL).Msg("fetching bazaar metadata")
+	jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+	jt.AddJobs(bzrFiles...)
+	jt.StartAndWait(workers.DownloadContext{C: c, BaseURL: baseURL, BaseDir: baseDir}, false)
+
+	read := func(file string) string {
+		content, _ := os.ReadFile(utils.URL(baseDir, file))
+		return strings.TrimSpace(string(content))
+	}
+	revno, revid, _ := strings.Cut(read(".bzr/branch/last-revision"), " ")
+	entry := log.Info().Str("base", baseURL).Str("revno", revno).Str("revid", revid).Str("format", read(".bzr/repository/format"))
+	if cfg, err := ini.Load(utils.URL(baseDir, ".bzr/branch/branch.conf")); err == nil {
+		entry = entry.Str("parent", cfg.Section("").Key("parent_location").String()).
+			Str("push", cfg.Section("").Key("push_location").String()).
+			Str("bound", cfg.Section("").Key("bound_location").String())
+	}
+	// lightweight checkouts point to a branch elsewhere
+	if location := read(".bzr/branch/location"); location != "" {
+		entry = entry.Str("location", location)
+	}
+	entry.Msg("bazaar branch")
+
+	dirstatePath := utils.URL(baseDir, ".bzr/checkout/dirstate")
+	content, err := os.ReadFile(dirstatePath)
+	if err != nil {
+		return fmt.Errorf("no bazaar working tree found at %s", baseURL)
+	}
+	ds, err := utils.ParseBzrDirstate(content)
+	if err != nil {
+		return fmt.Errorf("couldn't parse dirstate: %w", err)
+	}
+	if len(ds.Parents) > 0 && revid != "" && ds.Parents[0] != revid {
+		log.Warn().Str("base", baseURL).Str("parent", ds.Parents[0]).Str("revid", revid).Msg("working tree isn't at the last revision of the branch")
+	}
+
+	texts := fetchBzrTexts(baseURL, baseDir, ds)
+	restoreBzrFiles(baseURL, baseDir, ds, texts)
+	return nil
+}
+
+type bzrText struct {
+	pack  string
+	value string
+}
+
+// fetchBzrTexts reads the text indices of every pack of the repository, and
+// downloads the packs holding the texts of the basis tree of the dirstate.
+func fetchBzrTexts(baseURL, baseDir string, ds *utils.BzrDirstate) map[[2]string]bzrText {
+	texts := make(map[[2]string]bzrText)
+	if len(ds.Parents) == 0 {
+		return texts
+	}
+	repoDir := utils.URL(baseDir, ".bzr/repository")
+	content, err := os.ReadFile(utils.URL(repoDir, "pack-names"))
+	if err != nil {
+		return texts
+	}
+	packNames, err := utils.DecodeBTreeIndex(content)
+	if err != nil {
+		log.Error().Str("dir", baseDir).Err(err).Msg("couldn't read pack-names")
+		return texts
+	}
+	var packs []string
+	for _, e := range packNames {
+		if name := e.Key[0]; !strings.ContainsAny(name, `/\.`) {
+			packs = append(packs, name)
+		}
+	}
+
+	log.Info().Str("base", baseURL).Int("count", len(packs)).Msg("fetching pack text indices")
+	jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+	for _, pack := range packs {
+		jt.AddJob(".bzr/repository/indices/" + pack + ".tix")
+	}
+	jt.StartAndWait(workers.DownloadContext{C: c, BaseURL: baseURL, BaseDir: baseDir}, false)
+
+	wanted := make(map[[2]string]bool)
+	for _, e := range ds.Entries {
+		if basis := e.Trees[1]; basis.Kind == "f" {
+			wanted[[2]string{e.FileID, basis.Revision}] = true
+		}
+	}
+	neededPacks := make(map[string]bool)
+	for _, pack := range packs {
+		content, err := os.ReadFile(utils.URL(repoDir, "indices/"+pack+".tix"))
+		if err != nil {
+			continue
+		}
+		entries, err := utils.DecodeBTreeIndex(content)
+		if err != nil {
+			log.Error().Str("dir", baseDir).Str("pack", pack).Err(err).Msg("couldn't read text index")
+			continue
+		}
+		for _, e := range entries {
+			key := [2]string{e.Key[0], e.Key[len(e.Key)-1]}
+			if wanted[key] {
+				texts[key] = bzrText{pack: pack, value: e.Value}
+				neededPacks[pack] = true
+			}
+		}
+	}
+
+	log.Info().Str("base", baseURL).Int("count", len(neededPacks)).Msg("fetching packs")
+	jt = jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+	for pack := range neededPacks {
+		jt.AddJob(".bzr/repository/packs/" + pack + ".pack")
+	}
+	jt.StartAndWait(workers.DownloadContext{C: c, BaseURL: baseURL, BaseDir: baseDir, AllowHTML: true}, false)
+	return texts
+}
+
+// restoreBzrFiles writes the files of the basis tree of the dirstate to the
+// working tree, and fetches the live file for those whose text couldn't be
+// extracted, as well as the files added to the working tree since.
+func restoreBzrFiles(baseURL, baseDir string, ds *utils.BzrDirstate, texts map[[2]string]bzrText) {
+	packs := make(map[string][]byte)
+	var missing []string
+	for _, e := range ds.Entries {
+		if !utils.IsSafeWorkTreePath(e.Path, ".bzr") {
+			continue
+		}
+		target := utils.URL(baseDir, e.Path)
+		current := e.Trees[0]
+		if len(e.Trees) < 2 || e.Trees[1].Kind != "f" {
+			switch current.Kind {
+			case "d":
+				if err := utils.MkdirConfined(baseDir, e.Path, false); err != nil {
+					log.Error().Str("dir", baseDir).Str("path", e.Path).Err(err).Msg("couldn't create directory")
+				}
+			case "f":
+				missing = append(missing, e.Path)
+			}
+			continue
+		}
+		if utils.Exists(target) {
+			continue
+		}
+
+		basis := e.Trees[1]
+		text, ok := texts[[2]string{e.FileID, basis.Revision}]
+		if !ok {
+			missing = append(missing, e.Path)
+			continue
+		}
+		pack, ok := packs[text.pack]
+		if !ok {
+			pack, _ = os.ReadFile(utils.URL(baseDir, ".bzr/repository/packs/"+text.pack+".pack"))
+			packs[text.pack] = pack
+		}
+		content, err := utils.ExtractBzrText(pack, text.value)
+		if err != nil {
+			log.Warn().Str("dir", baseDir).Str("file", e.Path).Err(err).Msg("couldn't extract file text")
+			missing = append(missing, e.Path)
+			continue
+		}
+		if sum := sha1.Sum(content); hex.EncodeToString(sum[:]) != basis.Fingerprint {
+			log.Warn().Str("dir", baseDir).Str("file", e.Path).Str("expected", basis.Fingerprint).Msg("file text doesn't match its checksum")
+			missing = append(missing, e.Path)
+			continue
+		}
+
+		mode := os.FileMode(0644)
+		if basis.Executable {
+			mode = 0755
+		}
+		if err := utils.WriteConfinedFile(baseDir, e.Path, content, mode, false); err != nil {
+			log.Error().Str("file", target).Err(err).Msg("couldn't write file")
+		}
+	}
+
+	log.Info().Str("base", baseURL).Str("dir", baseDir).Int("count", len(missing)).Msg("attempting to fetch files without a text")
+	jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+	for _, f := range missing {
+		if !strings.HasSuffix(f, ".php") {
+			jt.AddJob(f)
+		}
+	}
+	jt.StartAndWait(workers.DownloadContext{C: c, BaseURL: baseURL, BaseDir: baseDir, AllowHTML: true, AlllowEmpty: true, WorkTree: true}, false)
+}
@@ -0,0 +1,171 @@
+package goop
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/phuslu/log"
+)
+
+// checkout writes the files of the index to the work tree, the way git
+// checkout . would, without running git against the dumped config. Files
+// whose blob is missing are skipped and listed in .goop/missing.jsonl, so a
+// partial dump still gets as much of its work tree as possible.
+func checkout(baseDir string, repo repository, format utils.ObjectFormat) error {
+	gitDir := utils.URL(baseDir, repo.gitDir)
+	workDir := utils.URL(baseDir, repo.workTree)
+	log.Info().Str("dir", workDir).Msg("checking out the index")
+
+	idx, err := utils.ReadIndex(utils.URL(gitDir, "index"), format)
+	if err != nil {
+		if idx == nil {
+			return err
+		}
+		log.Warn().Str("dir", baseDir).Err(err).Msg("index is only partially readable")
+	}
+	objectDir := utils.URL(baseDir, repo.objectDir())
+	store, err := utils.OpenObjectStore(objectDir, format)
+	if err != nil {
+		log.Warn().Str("dir", objectDir).Err(err).Msg("couldn't open all pack files")
+	}
+	defer store.Close()
+
+	written := 0
+	var missing []missingFile
+	for _, entry := range idx.Entries {
+		// unmerged paths are left as fetched from the site, with their
+		// conflict markers
+		if entry.Stage != 0 || entry.Name == "" {
+			continue
+		}
+		target, err := utils.ConfinedPath(workDir, entry.Name, false)
+		if err != nil {
+			log.Warn().Str("dir", workDir).Str("file", entry.Name).Err(err).Msg("refusing to check out file")
+			continue
+		}
+		if entry.Mode == utils.GitlinkMode {
+			// submodules are checked out when they get dumped
+			if err := utils.MkdirConfined(workDir, entry.Name, false); err != nil {
+				log.Error().Str("file", target).Err(err).Msg("couldn't create submodule directory")
+			}
+			continue
+		}
+
+		typ, content, err := store.Object(entry.Hash)
+		if err != nil || typ != "blob" {
+			log.Warn().Str("dir", workDir).Str("file", entry.Name).Str("obj", entry.Hash).Msg("blob is missing, skipping file")
+			missing = append(missing, missingFile{File: entry.Name, Obj: entry.Hash})
+			continue
+		}
+		if err := writeWorkTreeFile(workDir, entry.Name, entry.Mode, content); err != nil {
+			log.Error().Str("file", target).Err(err).Msg("couldn't write file")
+			continue
+		}
+		written++
+	}
+
+	log.Info().Str("dir", workDir).Int("written", written).Int("missing", len(missing)).Msg("checked out the index")
+	reportMissing(baseDir, repo, missing)
+	return nil
+}
+
+type missingFile struct {
+	WorkTree string `json:"work_tree"`
+	File     string `json:"file"`
+	Obj      string `json:"obj"`
+}
+
+// reportMissing replaces what baseDir/.goop/missing.jsonl lists for the work
+// tree of repo with the files that couldn't be checked out, as the work tree
+// may get checked out again once more objects were fetched.
+func reportMissing(baseDir string, repo repository, missing []missingFile) {
+	workTree := repo.workTree
+	if workTree == "" {
+		workTree = "."
+	}
+	var report []byte
+	if content, err := os.ReadFile(utils.URL(baseDir, ".goop/missing.jsonl")); err == nil {
+		for _, line := range bytes.SplitAfter(content, []byte("\n")) {
+			var f missingFile
+			if json.Unmarshal(line, &f) == nil && f.WorkTree != workTree {
+				report = append(report, line...)
+			}
+		}
+	}
+	for _, f := range missing {
+		f.WorkTree = workTree
+		line, _ := json.Marshal(f)
+		report = append(append(report, line...), '\n')
+	}
+	if len(report) == 0 && !utils.Exists(utils.URL(baseDir, ".goop/missing.jsonl")) {
+		return
+	}
+	if err := utils.WriteConfinedFile(baseDir, ".goop/missing.jsonl", report, 0644, false); err != nil {
+		log.Error().Str("dir", baseDir).Err(err).Msg("couldn't record missing files")
+	}
+}
+
+// writeWorkTreeFile writes a file of the work tree with the given git mode.
+// Symlinks pointing outside of the work tree are written as regular files
+// holding their target instead, like with core.symlinks turned off.
+func writeWorkTreeFile(workDir, name string, mode uint32, content []byte) error {
+	target := utils.URL(workDir, name)
+	if info, err := os.Lstat(target); err == nil {
+		if info.IsDir() {
+			// a directory in the work tree, such as an untracked one, would
+			// be lost with whatever it holds
+			log.Warn().Str("file", target).Msg("a directory is in the way, skipping file")
+			return nil
+		}
+		// never write through a symlink that's already there
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+	}
+	if err := utils.CreateParentFolders(target); err != nil {
+		return err
+	}
+
+	if mode == utils.SymlinkMode {
+		linkTarget := string(content)
+		if isSafeSymlink(name, linkTarget) {
+			return os.Symlink(linkTarget, target)
+		}
+		log.Warn().Str("file", target).Str("target", linkTarget).Msg("symlink points outside of the work tree, writing it as a file")
+		return os.WriteFile(target, content, 0644)
+	}
+
+	perm := os.FileMode(0644)
+	if mode&0111 != 0 {
+		perm = 0755
+	}
+	if err := os.WriteFile(target, content, perm); err != nil {
+		return err
+	}
+	return os.Chmod(target, perm)
+}
+
+// isSafeSymlink reports whether a symlink at name pointing to target stays
+// inside of the work tree, out of its git directory. Targets may only go up
+// before going down, as going up from another symlink leads elsewhere than
+// the path says.
+func isSafeSymlink(name, target string) bool {
+	if target == "" || path.IsAbs(target) || strings.ContainsAny(target, "\\\x00") {
+		return false
+	}
+	down := false
+	for _, part := range strings.Split(target, "/") {
+		switch {
+		case part == ".." && down:
+			return false
+		case part != ".." && part != "." && part != "":
+			down = true
+		}
+	}
+	resolved := path.Join(path.Dir(name), target)
+	return resolved != ".." && !strings.HasPrefix(resolved, "../") && utils.IsSafeWorkTreePath(resolved, ".git")
+}
@@ -0,0 +1,106 @@
+package goop
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+)
+
+// writeIndex writes an index of the given files and blobs to gitDir.
+func writeIndex(t *testing.T, gitDir string, files map[string]string) {
+	t.Helper()
+	var entries []*index.Entry
+	for name, hash := range files {
+		entries = append(entries, &index.Entry{Name: name, Hash: plumbing.NewHash(hash), Mode: filemode.Regular})
+	}
+	if err := os.MkdirAll(gitDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(gitDir, "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := index.NewEncoder(f).Encode(&index.Index{Version: 2, Entries: entries}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readMissing(t *testing.T, dir string) []missingFile {
+	t.Helper()
+	f, err := os.Open(filepath.Join(dir, ".goop/missing.jsonl"))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var missing []missingFile
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var m missingFile
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			t.Fatalf("malformed report line %q: %v", scanner.Text(), err)
+		}
+		missing = append(missing, m)
+	}
+	return missing
+}
+
+func TestCheckoutReportsMissing(t *testing.T) {
+	dir := t.TempDir()
+	gitDir := filepath.Join(dir, ".git")
+	present, err := utils.WriteLooseObject(gitDir, "blob", []byte("present\n"), utils.SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	absent := utils.SHA1.HashObject("blob", []byte("absent\n"))
+	writeIndex(t, gitDir, map[string]string{"a.txt": present, "dir/b.txt": absent})
+
+	if err := checkout(dir, mainRepository, utils.SHA1); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(filepath.Join(dir, "a.txt")); err != nil || string(content) != "present\n" {
+		t.Errorf("a.txt = %q, %v", content, err)
+	}
+	want := []missingFile{{WorkTree: ".", File: "dir/b.txt", Obj: absent}}
+	if got := readMissing(t, dir); len(got) != 1 || got[0] != want[0] {
+		t.Fatalf("report = %v, want %v", got, want)
+	}
+
+	// checking out another work tree keeps what's reported for the first
+	wtDir := filepath.Join(gitDir, "worktrees/wt")
+	writeIndex(t, wtDir, map[string]string{"c.txt": absent})
+	wt := repository{gitDir: ".git/worktrees/wt", workTree: ".goop/worktrees/wt", commonDir: ".git"}
+	if err := checkout(dir, wt, utils.SHA1); err != nil {
+		t.Fatal(err)
+	}
+	if got := readMissing(t, dir); len(got) != 2 || got[1] != (missingFile{WorkTree: ".goop/worktrees/wt", File: "c.txt", Obj: absent}) {
+		t.Fatalf("report = %v", got)
+	}
+
+	// once the blob is fetched, the file is no longer reported
+	if _, err := utils.WriteLooseObject(gitDir, "blob", []byte("absent\n"), utils.SHA1); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkout(dir, mainRepository, utils.SHA1); err != nil {
+		t.Fatal(err)
+	}
+	if got := readMissing(t, dir); len(got) != 1 || got[0].WorkTree != ".goop/worktrees/wt" {
+		t.Fatalf("report = %v", got)
+	}
+	if err := checkout(dir, wt, utils.SHA1); err != nil {
+		t.Fatal(err)
+	}
+	if got := readMissing(t, dir); len(got) != 0 {
+		t.Fatalf("report = %v, want it empty", got)
+	}
+	if content, err := os.ReadFile(filepath.Join(dir, ".goop/worktrees/wt/c.txt")); err != nil || string(content) != "absent\n" {
+		t.Errorf("worktree c.txt = %q, %v", content, err)
+	}
+}
@@ -5,10 +5,10 @@ import (
 	"bufio"
 	"bytes"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
@@ -16,14 +16,6 @@ import (
 	"github.com/deletescape/goop/internal/utils"
 	"github.com/deletescape/goop/internal/workers"
 	"github.com/deletescape/jobtracker"
-	"github.com/go-git/go-billy/v5/osfs"
-	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/cache"
-	"github.com/go-git/go-git/v5/plumbing/format/commitgraph"
-	"github.com/go-git/go-git/v5/plumbing/format/index"
-	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/go-git/go-git/v5/storage/filesystem"
-	"github.com/go-git/go-git/v5/storage/filesystem/dotgit"
 	"github.com/phuslu/log"
 	"github.com/valyala/fasthttp"
 	"github.com/valyala/fasthttp/fasthttpproxy"
@@ -66,10 +58,11 @@ var c = &fasthttp.Client{
 	},
 	NoDefaultUserAgentHeader: true,
 	MaxConnWaitTimeout:       10 * time.Second,
+	MaxResponseBodySize:      workers.MaxBodySize,
 	Dial:                     proxyFromEnv(),
 }
 
-func CloneList(listFile, baseDir string, force, keep bool) error {
+func CloneList(listFile, baseDir string, force, keep, worktrees, unsafeGit bool, refOpts RefNameOptions, lfsHosts []string) error {
 	lf, err := os.Open(listFile)
 	if err != nil {
 		return err
@@ -92,17 +85,21 @@ func CloneList(listFile, baseDir string, force, keep bool) error {
 			dir = utils.URL(dir, parsed.Host)
 		}
 		log.Info().Str("target", u).Str("dir", dir).Bool("force", force).Bool("keep", keep).Msg("starting download")
-		if err := Clone(u, dir, force, keep); err != nil {
+		if err := Clone(u, dir, force, keep, worktrees, unsafeGit, refOpts, lfsHosts); err != nil {
 			log.Error().Str("target", u).Str("dir", dir).Bool("force", force).Bool("keep", keep).Msg("download failed")
 		}
 	}
 	return nil
 }
 
-func Clone(u, dir string, force, keep bool) error {
+func Clone(u, dir string, force, keep, worktrees, unsafeGit bool, refOpts RefNameOptions, lfsHosts []string) error {
 	baseURL := strings.TrimSuffix(u, "/")
 	baseURL = strings.TrimSuffix(baseURL, "/HEAD")
 	baseURL = strings.TrimSuffix(baseURL, "/.git")
+	baseURL = strings.TrimSuffix(baseURL, "/.svn")
+	baseURL = strings.TrimSuffix(baseURL, "/.hg")
+	baseURL = strings.TrimSuffix(baseURL, "/.bzr")
+	baseURL = strings.TrimSuffix(baseURL, "/CVS")
 	parsed, err := url.Parse(baseURL)
 	if err != nil {
 		return err
@@ -139,12 +136,56 @@ func Clone(u, dir string, force, keep bool) error {
 		}
 	}
 
-	return FetchGit(baseURL, baseDir)
+	workers.RecordEncodings(baseDir)
+	workers.FingerprintNotFound(c, baseURL)
+
+	var errs []error
+	for _, vcs := range detectVCS(baseURL) {
+		var err error
+		switch vcs {
+		case vcsGit:
+			err = FetchGit(baseURL, baseDir, worktrees, unsafeGit, refOpts, lfsHosts)
+		case vcsSvn:
+			err = FetchSvn(baseURL, baseDir)
+		case vcsHg:
+			err = FetchHg(baseURL, baseDir)
+		case vcsBzr:
+			err = FetchBzr(baseURL, baseDir)
+		case vcsCvs:
+			err = FetchCvs(baseURL, baseDir)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", vcs, err))
+		}
+	}
+	return errors.Join(errs...)
 }
 
-func FetchGit(baseURL, baseDir string) error {
-	log.Info().Str("base", baseURL).Msg("testing for .git/HEAD")
-	code, body, err := c.Get(nil, utils.URL(baseURL, ".git/HEAD"))
+func FetchGit(baseURL, baseDir string, worktrees, unsafeGit bool, refOpts RefNameOptions, lfsHosts []string) error {
+	names, err := refNames(refOpts)
+	if err != nil {
+		return err
+	}
+	if err := fetchGit(baseURL, baseDir, mainRepository, nil, names, unsafeGit, lfsHosts); err != nil {
+		return err
+	}
+	if worktrees {
+		checkoutWorktrees(baseDir)
+	}
+	return nil
+}
+
+// fetchGit dumps repo, seeds are additional objects to look for, like the
+// commits a superproject expects to find in a submodule, refNames are the
+// branch and tag names to probe for. Unless unsafeGit is set, the config and
+// hooks of the dump are sanitized before git gets to run in it. lfsHosts are
+// the hosts besides the target the lfs batch API may be used on.
+func fetchGit(baseURL, baseDir string, repo repository, seeds, refNames []string, unsafeGit bool, lfsHosts []string) error {
+	gitDir := utils.URL(baseDir, repo.gitDir)
+	workDir := utils.URL(baseDir, repo.workTree)
+
+	log.Info().Str("base", baseURL).Str("repo", repo.gitDir).Msg("testing for .git/HEAD")
+	code, body, err := workers.Get(c, utils.URL(baseURL, repo.path(".git/HEAD")))
 	if err != nil {
 		return err
 	}
@@ -155,8 +196,15 @@ func FetchGit(baseURL, baseDir string) error {
 		log.Warn().Str("base", baseURL).Int("code", code).Msg(".git/HEAD doesn't appear to be a git HEAD file, clone will most likely fail")
 	}
 
+	if repo.isMain() {
+		log.Info().Str("base", baseURL).Msg("testing for smart http protocol")
+		if _, err := fetchSmart(baseURL, baseDir); err != nil {
+			log.Error().Str("base", baseURL).Err(err).Msg("failed to fetch pack using smart http protocol")
+		}
+	}
+
 	log.Info().Str("base", baseURL).Msg("testing if recursive download is possible")
-	code, body, err = c.Get(body, utils.URL(baseURL, ".git/"))
+	code, body, err = workers.Get(c, utils.URL(baseURL, repo.path(".git/")))
 	if err != nil {
 		if utils.IgnoreError(err) {
 			log.Error().Str("base", baseURL).Int("code", code).Err(err)
@@ -165,8 +213,8 @@ func FetchGit(baseURL, baseDir string) error {
 		}
 	}
 
-	if code == 200 && utils.IsHTML(body) {
-		lnk, _ := url.Parse(utils.URL(baseURL, ".git/"))
+	if code == 200 && utils.IsHTML(body) && !workers.IsNotFound(utils.URL(baseURL, repo.path(".git/")), body) {
+		lnk, _ := url.Parse(utils.URL(baseURL, repo.path(".git/")))
 		indexedFiles, err := utils.GetIndexedFiles(body, lnk.Path)
 		if err != nil {
 			return err
@@ -175,12 +223,12 @@ func FetchGit(baseURL, baseDir string) error {
 			log.Info().Str("base", baseURL).Msg("fetching .git/ recursively")
 			jt := jobtracker.NewJobTracker(workers.RecursiveDownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
 			jt.AddJobs(indexedFiles...)
-			jt.StartAndWait(workers.RecursiveDownloadContext{C: c, BaseURL: utils.URL(baseURL, ".git/"), BaseDir: utils.URL(baseDir, ".git/")}, true)
+			jt.StartAndWait(workers.RecursiveDownloadContext{C: c, BaseURL: utils.URL(baseURL, repo.path(".git/")), BaseDir: utils.URL(baseDir, repo.path(".git/"))}, true)
 
-			if err := checkout(baseDir); err != nil {
-				log.Error().Str("dir", baseDir).Err(err).Msg("failed to checkout")
+			if err := checkout(baseDir, repo, readObjectFormat(gitDir)); err != nil {
+				log.Error().Str("dir", workDir).Err(err).Msg("failed to checkout")
 			}
-			if err := fetchIgnored(baseDir, baseURL); err != nil {
+			if err := fetchIgnored(workDir, utils.URL(baseURL, repo.workTree)); err != nil {
 				return err
 			}
 		}
@@ -188,54 +236,125 @@ func FetchGit(baseURL, baseDir string) error {
 
 	log.Info().Str("base", baseURL).Msg("fetching common files")
 	jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
-	jt.AddJobs(commonFiles...)
+	jt.AddJobs(repo.paths(commonFiles)...)
 	jt.StartAndWait(workers.DownloadContext{C: c, BaseDir: baseDir, BaseURL: baseURL}, false)
 
 	log.Info().Str("base", baseURL).Msg("finding refs")
 	jt = jobtracker.NewJobTracker(workers.FindRefWorker, maxConcurrency, jobtracker.DefaultNapper)
-	jt.AddJobs(commonRefs...)
-	jt.StartAndWait(workers.FindRefContext{C: c, BaseURL: baseURL, BaseDir: baseDir}, true)
+	jt.AddJobs(repo.paths(commonRefs)...)
+	jt.StartAndWait(workers.FindRefContext{C: c, BaseURL: baseURL, BaseDir: baseDir, GitDir: repo.gitDir}, true)
+
+	if len(refNames) > 0 {
+		log.Info().Str("base", baseURL).Int("names", len(refNames)).Msg("probing ref names")
+		findRefNames(baseURL, baseDir, repo, refNames)
+	}
+
+	log.Info().Str("base", baseURL).Msg("finding operations in progress")
+	stateFilePaths := fetchState(baseURL, baseDir, repo)
+
+	log.Info().Str("base", baseURL).Msg("finding worktrees")
+	worktrees := findWorktrees(baseURL, baseDir, repo)
+	if !unsafeGit {
+		sanitizeGitDir(gitDir, worktrees)
+	}
+
+	format := readObjectFormat(gitDir)
+
+	indexPath := utils.URL(gitDir, "index")
+	indexPaths := []string{indexPath}
+	for _, name := range worktrees {
+		indexPaths = append(indexPaths, utils.URL(gitDir, fmt.Sprintf("worktrees/%s/index", name)))
+	}
+	fetchSharedIndexes(baseURL, baseDir, indexPaths, format)
+
+	objs := make(map[string]bool) // object "set"
+	for _, obj := range seeds {
+		objs[obj] = true
+	}
+
+	history := readHistory(gitDir, format)
+	for _, obj := range history.Hashes() {
+		objs[obj] = true
+	}
 
 	log.Info().Str("base", baseURL).Msg("finding packs")
-	infoPacksPath := utils.URL(baseDir, ".git/objects/info/packs")
+	packs := make(map[string]bool)
+	infoPacksPath := utils.URL(gitDir, "objects/info/packs")
 	if utils.Exists(infoPacksPath) {
 		infoPacks, err := os.ReadFile(infoPacksPath)
 		if err != nil {
 			return err
 		}
-		hashes := packRegex.FindAllSubmatch(infoPacks, -1)
+		for _, hash := range packRegex.FindAllSubmatch(infoPacks, -1) {
+			packs[string(hash[1])] = true
+		}
+	}
+
+	// the multi-pack-index names its packs as well, which helps when info/packs is missing or stale
+	parseMultiPackIndex(baseDir, utils.URL(gitDir, "objects/pack/multi-pack-index"), format, objs, packs)
+	midxChain := utils.URL(gitDir, "objects/pack/multi-pack-index.d/multi-pack-index-chain")
+	if utils.Exists(midxChain) {
+		var midxFiles []string
 		jt = jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
-		for _, sha1 := range hashes {
+		f, err := os.Open(midxChain)
+		if err != nil {
+			log.Error().Str("dir", baseDir).Err(err).Msg("failed to open multi-pack-index chain")
+		} else {
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				line := strings.TrimSpace(scanner.Text())
+				if format.IsHash(line) {
+					midxFile := repo.path(fmt.Sprintf(".git/objects/pack/multi-pack-index.d/multi-pack-index-%s.midx", line))
+					midxFiles = append(midxFiles, midxFile)
+					jt.AddJob(midxFile)
+				}
+			}
+			f.Close()
+		}
+		jt.StartAndWait(workers.DownloadContext{C: c, BaseDir: baseDir, BaseURL: baseURL}, false)
+		for _, midxFile := range midxFiles {
+			parseMultiPackIndex(baseDir, utils.URL(baseDir, midxFile), format, objs, packs)
+		}
+	}
+
+	if len(packs) > 0 {
+		jt = jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+		for hash := range packs {
 			jt.AddJobs(
-				fmt.Sprintf(".git/objects/pack/pack-%s.idx", sha1[1]),
-				fmt.Sprintf(".git/objects/pack/pack-%s.pack", sha1[1]),
-				fmt.Sprintf(".git/objects/pack/pack-%s.rev", sha1[1]),
+				repo.path(fmt.Sprintf(".git/objects/pack/pack-%s.idx", hash)),
+				repo.path(fmt.Sprintf(".git/objects/pack/pack-%s.pack", hash)),
+				repo.path(fmt.Sprintf(".git/objects/pack/pack-%s.rev", hash)),
 			)
 		}
 		jt.StartAndWait(workers.DownloadContext{C: c, BaseURL: baseURL, BaseDir: baseDir}, false)
 	}
 
-	log.Info().Str("base", baseURL).Msg("finding objects")
-	objs := make(map[string]bool) // object "set"
-	//var packed_objs [][]byte
+	log.Info().Str("base", baseURL).Msg("finding alternates")
+	alternates := findAlternates(baseURL, repo)
+	fetchAlternatePacks(gitDir, alternates)
 
+	log.Info().Str("base", baseURL).Msg("finding objects")
 	files := []string{
-		utils.URL(baseDir, ".git/packed-refs"),
-		utils.URL(baseDir, ".git/info/refs"),
-		utils.URL(baseDir, ".git/info/grafts"),
-		// utils.Url(baseDir, ".git/info/sparse-checkout"), // TODO: ?
-		utils.URL(baseDir, ".git/FETCH_HEAD"),
-		utils.URL(baseDir, ".git/ORIG_HEAD"),
-		utils.URL(baseDir, ".git/HEAD"),
-		utils.URL(baseDir, ".git/objects/loose-object-idx"), // TODO: is this even a text file?
-		utils.URL(baseDir, ".git/objects/info/commit-graphs/commit-graph-chain"),
-		utils.URL(baseDir, ".git/objects/info/alternates"),
-		utils.URL(baseDir, ".git/objects/info/http-alternates"),
+		utils.URL(gitDir, "packed-refs"),
+		utils.URL(gitDir, "info/refs"),
+		// utils.Url(gitDir, "info/sparse-checkout"), // TODO: ?
+		utils.URL(gitDir, "FETCH_HEAD"),
+		utils.URL(gitDir, "ORIG_HEAD"),
+		utils.URL(gitDir, "HEAD"),
+		utils.URL(gitDir, "objects/info/commit-graphs/commit-graph-chain"),
+		utils.URL(gitDir, "objects/info/alternates"),
+		utils.URL(gitDir, "objects/info/http-alternates"),
+	}
+	files = append(files, stateFilePaths...)
+	for _, name := range worktrees {
+		for _, file := range []string{"HEAD", "ORIG_HEAD", "FETCH_HEAD", "logs/HEAD"} {
+			files = append(files, utils.URL(gitDir, fmt.Sprintf("worktrees/%s/%s", name, file)))
+		}
 	}
 
 	// TODO : fix if-else hell in the entire object hash collection code (and get rid of bad early returns)
 
-	gitRefsDir := utils.URL(baseDir, ".git/refs")
+	gitRefsDir := utils.URL(gitDir, "refs")
 	if utils.Exists(gitRefsDir) {
 		if err := filepath.Walk(gitRefsDir, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
@@ -249,7 +368,7 @@ func FetchGit(baseURL, baseDir string) error {
 			return err
 		}
 	}
-	gitLogsDir := utils.URL(baseDir, ".git/logs")
+	gitLogsDir := utils.URL(gitDir, "logs")
 	if utils.Exists(gitLogsDir) {
 		refLogPrefix := utils.URL(gitLogsDir, "refs") + "/"
 		if err := filepath.Walk(gitLogsDir, func(path string, info os.FileInfo, err error) error {
@@ -275,12 +394,7 @@ func FetchGit(baseURL, baseDir string) error {
 						logObjs := refLogRegex.FindAllSubmatch(content, -1)
 						lastEntryObj := logObjs[len(logObjs)-1][1]
 
-						if err := utils.CreateParentFolders(filePath); err != nil {
-							log.Error().Str("file", filePath).Err(err).Msg("couldn't create parent directories")
-							return nil
-						}
-
-						if err := os.WriteFile(filePath, lastEntryObj, os.ModePerm); err != nil {
+						if err := utils.WriteConfinedFile(gitRefsDir, refName, lastEntryObj, os.ModePerm, true); err != nil {
 							log.Error().Str("file", filePath).Err(err).Msg("couldn't write to file")
 						}
 					}
@@ -307,48 +421,57 @@ func FetchGit(baseURL, baseDir string) error {
 		}
 	}
 
-	indexPath := utils.URL(baseDir, ".git/index")
-	if utils.Exists(indexPath) {
-		f, err := os.Open(indexPath)
-		if err != nil {
-			return err
+	for _, path := range indexPaths {
+		if !utils.Exists(path) {
+			continue
 		}
-		defer f.Close()
-		var idx index.Index
-		decoder := index.NewDecoder(f)
-		if err := decoder.Decode(&idx); err != nil {
-			log.Error().Str("dir", baseDir).Err(err).Msg("couldn't decode git index")
+		idx, err := utils.ReadIndex(path, format)
+		if err != nil {
+			log.Error().Str("dir", baseDir).Str("index", path).Err(err).Msg("couldn't decode git index")
 		}
-		for _, entry := range idx.Entries {
-			objs[entry.Hash.String()] = true
+		if idx != nil {
+			for _, entry := range idx.Entries {
+				if entry.Mode != utils.GitlinkMode {
+					objs[entry.Hash] = true
+				}
+			}
+			trees, err := idx.CacheTree(format)
+			if err != nil {
+				log.Error().Str("dir", baseDir).Str("index", path).Err(err).Msg("couldn't decode cache tree")
+			}
+			for _, tree := range trees {
+				objs[tree] = true
+			}
 		}
 	}
 
-	objStorage := filesystem.NewObjectStorage(dotgit.New(osfs.New(utils.URL(baseDir, ".git"))), &cache.ObjectLRU{MaxSize: 256})
-	if err := objStorage.ForEachObjectHash(func(hash plumbing.Hash) error {
-		objs[hash.String()] = true
-		encObj, err := objStorage.EncodedObject(plumbing.AnyObject, hash)
-		if err != nil {
-			return err
-
+	looseObjs, err := filepath.Glob(utils.URL(gitDir, "objects/[0-9a-f][0-9a-f]/*"))
+	if err != nil {
+		return err
+	}
+	for _, path := range looseObjs {
+		hash := filepath.Base(filepath.Dir(path)) + filepath.Base(path)
+		if !format.IsHash(hash) {
+			continue
 		}
-		decObj, err := object.DecodeObject(objStorage, encObj)
+		objs[hash] = true
+		typ, content, err := utils.ReadLooseObject(path)
 		if err != nil {
-			return err
+			log.Error().Str("dir", baseDir).Str("obj", hash).Err(err).Msg("error while processing object file")
+			continue
 		}
-		for _, hash := range utils.GetReferencedHashes(decObj) {
-			objs[hash] = true
+		for _, ref := range history.ReferencedHashes(hash, typ, content, format) {
+			objs[ref] = true
 		}
-		return nil
-	}); err != nil {
-		log.Error().Str("dir", baseDir).Err(err).Msg("error while processing object files")
 	}
 
+	parseLooseObjectIdx(gitDir, format, objs)
+
 	// Parse stand alone commit graph file
-	parseGraphFile(baseDir, utils.URL(baseDir, ".git/objects/info/commit-graph"), objs)
+	parseGraphFile(baseDir, utils.URL(gitDir, "objects/info/commit-graph"), format, objs)
 
 	// Parse commit graph chains
-	commitGraphList := utils.URL(baseDir, ".git/objects/info/commit-graphs/commit-graph-chain")
+	commitGraphList := utils.URL(gitDir, "objects/info/commit-graphs/commit-graph-chain")
 	if utils.Exists(commitGraphList) {
 		var graphFiles []string
 		jt = jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
@@ -360,7 +483,7 @@ func FetchGit(baseURL, baseDir string) error {
 			for scanner.Scan() {
 				line := strings.TrimSpace(scanner.Text())
 				if !strings.HasPrefix(line, "#") {
-					graphFile := fmt.Sprintf(".git/objects/info/commit-graphs/graph-%s.graph", line)
+					graphFile := repo.path(fmt.Sprintf(".git/objects/info/commit-graphs/graph-%s.graph", line))
 					graphFiles = append(graphFiles, graphFile)
 					jt.AddJob(graphFile)
 				}
@@ -368,201 +491,121 @@ func FetchGit(baseURL, baseDir string) error {
 		}
 		jt.StartAndWait(workers.DownloadContext{C: c, BaseDir: baseDir, BaseURL: baseURL}, false)
 		for _, graphFile := range graphFiles {
-			parseGraphFile(baseDir, utils.URL(baseDir, graphFile), objs)
+			parseGraphFile(baseDir, utils.URL(baseDir, graphFile), format, objs)
 		}
 	}
 
-	// TODO: find more objects to fetch in pack files and remove packed objects from list of objects to be fetched
-	/* packs, err := objStorage.ObjectPacks()
-	// TODO: handle error
-	if err == nil {
-		for _, pack := range packs {
-			pf := utils.Url(baseDir, fmt.Sprintf(".git/objects/pack/pack-%s.pack", pack))
-			r, err := os.Open(pf)
-			if err != nil {
-				log.Error().Str("dir", baseDir).Str("pack", pf).Err(err).Msg("failed to open pack file")
-				continue
-			}
-			sc := packfile.NewScanner(r)
-			for {
-				oh, err := sc.NextObjectHeader()
-				if err != nil {
-					log.Error().Str("dir", baseDir).Str("pack", pf).Err(err).Msg("error while parsing pack file")
-					break
-				}
-			}
-		}
-	} */
+	// Find more objects to fetch in pack files and remove packed objects from list of objects to be fetched
+	packed := make(map[string]bool)
+	parsePacks(gitDir, format, history, objs, packed)
+	for obj := range packed {
+		delete(objs, obj)
+	}
 
 	log.Info().Str("base", baseURL).Msg("fetching objects")
 	jt = jobtracker.NewJobTracker(workers.FindObjectsWorker, maxConcurrency, jobtracker.DefaultNapper)
 	for obj := range objs {
-		jt.AddJob(obj)
+		if format.IsHash(obj) {
+			jt.AddJob(obj)
+		}
+	}
+	jt.StartAndWait(workers.FindObjectsContext{C: c, BaseURL: baseURL, BaseDir: baseDir, GitDir: repo.gitDir, Format: format, Packed: packed, Alternates: alternates, History: history}, true)
+
+	if history.IsTruncated() {
+		log.Info().Str("base", baseURL).Int("shallow", len(history.Shallow)).Int("grafts", len(history.Grafts)).Msg("history is intentionally truncated at shallow and grafted commits, their original parents are not expected to exist")
 	}
-	jt.StartAndWait(workers.FindObjectsContext{C: c, BaseURL: baseURL, BaseDir: baseDir, Storage: objStorage}, true)
 
 	// exit early if we haven't managed to dump anything
 	if !utils.Exists(baseDir) {
 		return nil
 	}
 
-	fetchMissing(baseDir, baseURL, objStorage)
+	// without an index, everything would look deleted and we wouldn't know
+	// which files to fetch, so build one from HEAD
+	if !utils.Exists(indexPath) {
+		log.Info().Str("dir", baseDir).Msg("no index, rebuilding it from HEAD")
+		if err := rebuildIndex(baseDir, repo, format); err != nil {
+			log.Error().Str("dir", baseDir).Err(err).Msg("failed to create index from HEAD")
+		}
+	}
+
+	fetchMissing(baseDir, baseURL, repo, format, packed)
+	synthesizeTrees(baseDir, repo, format)
 
-	// TODO: disable lfs in checkout (for now lfs support depends on lfs NOT being setup on the system you use goop on)
-	if err := checkout(baseDir); err != nil {
-		log.Error().Str("dir", baseDir).Err(err).Msg("failed to checkout")
+	if err := checkout(baseDir, repo, format); err != nil {
+		log.Error().Str("dir", workDir).Err(err).Msg("failed to checkout")
 	}
 
-	// <fetch lfs objects and manually check them out>
-	fetchLfs(baseDir, baseURL)
+	fetchLfs(baseDir, baseURL, repo, format, lfsHosts)
 
-	if err := fetchIgnored(baseDir, baseURL); err != nil {
+	if err := fetchIgnored(workDir, utils.URL(baseURL, repo.workTree)); err != nil {
 		return err
 	}
 
+	reportState(baseURL, baseDir, repo)
+
+	fetchSubmodules(baseURL, baseDir, repo, format, refNames, unsafeGit, lfsHosts)
+
 	return nil
 }
 
-func checkout(baseDir string) error {
-	log.Info().Str("dir", baseDir).Msg("running git checkout .")
-	cmd := exec.Command("git", "checkout", ".")
-	cmd.Dir = baseDir
-	return cmd.Run()
-}
+// Iterate over index to find missing files
+func fetchMissing(baseDir, baseURL string, repo repository, format utils.ObjectFormat, packed map[string]bool) {
+	gitDir := utils.URL(baseDir, repo.gitDir)
+	workDir := utils.URL(baseDir, repo.workTree)
+	workURL := utils.URL(baseURL, repo.workTree)
+	indexPath := utils.URL(gitDir, "index")
+	if utils.Exists(indexPath) {
+		log.Info().Str("base", baseURL).Str("dir", baseDir).Msg("attempting to fetch potentially missing files")
 
-func fetchLfs(baseDir, baseURL string) {
-	attrPath := utils.URL(baseDir, ".gitattributes")
-	if utils.Exists(attrPath) {
-		log.Info().Str("dir", baseDir).Msg("attempting to fetch potential git lfs objects")
-		f, err := os.Open(attrPath)
+		var missingFiles []string
+		idx, err := utils.ReadIndex(indexPath, format)
 		if err != nil {
-			log.Error().Str("dir", baseDir).Err(err).Msg("couldn't read git attributes")
-			return
-		}
-		defer f.Close()
-
-		var globalFilters []string
-		var filters []string
-		var files []string
-		scanner := bufio.NewScanner(f)
-		for scanner.Scan() {
-			line := strings.TrimSpace(scanner.Text())
-			if strings.Contains(line, "filter=lfs") {
-				pattern := strings.SplitN(line, " ", 2)[0]
-				if strings.ContainsRune(pattern, '*') {
-					if strings.ContainsRune(pattern, '/') {
-						globalFilters = append(globalFilters, pattern)
-					} else {
-						filters = append(filters, pattern)
-					}
-				} else {
-					files = append(files, pattern)
-				}
+			log.Error().Str("dir", baseDir).Err(err).Msg("couldn't decode git index")
+			if idx == nil {
+				return
 			}
 		}
-		if err := scanner.Err(); err != nil {
-			log.Error().Str("dir", baseDir).Err(err).Msg("error while parsing git attributes file")
-		}
-
-		var hashes []string
-		readStub := func(fp string) {
-			f, err := os.Open(fp)
-			if err != nil {
-				log.Error().Str("file", fp).Err(err).Msg("couldn't open lfs stub file")
-				return
+		jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+		for _, entry := range idx.Entries {
+			if entry.Mode == utils.GitlinkMode || entry.Name == "" {
+				// submodules are dumped separately, entries without a name are
+				// left over from a split index that couldn't be merged
+				continue
 			}
-			defer f.Close()
-			scanner := bufio.NewScanner(f)
-			for scanner.Scan() {
-				line := strings.TrimSpace(scanner.Text())
-				fmt.Println(line)
-				if strings.HasPrefix(line, "oid ") {
-					hash := strings.SplitN(line, " ", 2)[1]
-					hash = strings.SplitN(hash, ":", 2)[1]
-					hashes = append(hashes, hash)
-					break
-				}
+			if strings.HasSuffix(entry.Name, ".php") {
+				continue
 			}
-			if err := scanner.Err(); err != nil {
-				log.Error().Str("file", fp).Err(err).Msg("error while parsing lfs stub file")
+			if entry.Stage != 0 {
+				// unmerged, the work tree holds the file with conflict markers
+				jt.AddJob(entry.Name)
+			} else if !packed[entry.Hash] && !utils.Exists(utils.URL(gitDir, utils.LooseObjectPath(entry.Hash))) {
+				missingFiles = append(missingFiles, entry.Name)
+				jt.AddJob(entry.Name)
 			}
 		}
+		jt.StartAndWait(workers.DownloadContext{C: c, BaseURL: workURL, BaseDir: workDir, AllowHTML: true, AlllowEmpty: true, WorkTree: true}, false)
 
-		for _, file := range files {
-			fp := utils.URL(baseDir, file)
-			if utils.Exists(fp) {
-				readStub(fp)
+		jt = jobtracker.NewJobTracker(workers.CreateObjectWorker, maxConcurrency, jobtracker.DefaultNapper)
+		for _, f := range missingFiles {
+			if utils.Exists(utils.URL(workDir, f)) {
+				jt.AddJob(f)
 			}
 		}
+		jt.StartAndWait(workers.CreateObjectContext{BaseDir: workDir, GitDir: gitDir, Format: format, Index: idx}, false)
 
-		err = filepath.Walk(baseDir,
-			func(path string, info os.FileInfo, err error) error {
-				if err != nil {
-					return err
-				}
-				for _, filter := range filters {
-					match, err := filepath.Match(filter, filepath.Base(path))
-					if err != nil {
-						log.Error().Str("dir", baseDir).Str("filter", filter).Err(err).Msg("failed to apply filter")
-						continue
-					}
-					if match {
-						readStub(path)
-					}
-				}
-				return nil
-			})
+		// the untracked cache lists files that aren't in the repository at all
+		untracked, err := idx.Untracked(format)
 		if err != nil {
-			log.Error().Str("dir", baseDir).Err(err).Msg("error while testing git lfs filters")
-		}
-
-		// TODO: global filters
-		_ = globalFilters
-
-		jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
-		for _, hash := range hashes {
-			jt.AddJob(fmt.Sprintf(".git/lfs/objects/%s/%s/%s", hash[:2], hash[2:4], hash))
+			log.Error().Str("dir", baseDir).Err(err).Msg("couldn't decode untracked cache")
 		}
-		jt.StartAndWait(workers.DownloadContext{C: c, BaseURL: baseURL, BaseDir: baseDir}, false)
-	}
-}
-
-// Iterate over index to find missing files
-func fetchMissing(baseDir, baseURL string, objStorage *filesystem.ObjectStorage) {
-	indexPath := utils.URL(baseDir, ".git/index")
-	if utils.Exists(indexPath) {
-		log.Info().Str("base", baseURL).Str("dir", baseDir).Msg("attempting to fetch potentially missing files")
-
-		var missingFiles []string
-		var idx index.Index
-		f, err := os.Open(indexPath)
-		if err != nil {
-			log.Error().Str("dir", baseDir).Err(err).Msg("couldn't read git index")
-			return
-		}
-		defer f.Close()
-		decoder := index.NewDecoder(f)
-		if err := decoder.Decode(&idx); err != nil {
-			log.Error().Str("dir", baseDir).Err(err).Msg("couldn't decode git index")
-			return
-		} else {
-			jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
-			for _, entry := range idx.Entries {
-				if !strings.HasSuffix(entry.Name, ".php") && !utils.Exists(utils.URL(baseDir, fmt.Sprintf(".git/objects/%s/%s", entry.Hash.String()[:2], entry.Hash.String()[2:]))) {
-					missingFiles = append(missingFiles, entry.Name)
-					jt.AddJob(entry.Name)
-				}
-			}
-			jt.StartAndWait(workers.DownloadContext{C: c, BaseURL: baseURL, BaseDir: baseDir, AllowHTML: true, AlllowEmpty: true}, false)
-
-			jt = jobtracker.NewJobTracker(workers.CreateObjectWorker, maxConcurrency, jobtracker.DefaultNapper)
-			for _, f := range missingFiles {
-				if utils.Exists(utils.URL(baseDir, f)) {
-					jt.AddJob(f)
-				}
+		jt = jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+		for _, f := range untracked {
+			if !strings.HasSuffix(f, "/") && !strings.HasSuffix(f, ".php") {
+				jt.AddJob(f)
 			}
-			jt.StartAndWait(workers.CreateObjectContext{BaseDir: baseDir, Storage: objStorage, Index: &idx}, false)
 		}
+		jt.StartAndWait(workers.DownloadContext{C: c, BaseURL: workURL, BaseDir: workDir, AllowHTML: true, AlllowEmpty: true, WorkTree: true}, false)
 	}
 }
 
@@ -587,45 +630,86 @@ func fetchIgnored(baseDir, baseURL string) error {
 			if line == "" || strings.HasPrefix(line, "!") || strings.HasSuffix(line, "/") || strings.ContainsRune(line, '*') || strings.HasSuffix(line, ".php") || strings.HasPrefix(line, "#") {
 				continue
 			}
-			jt.AddJob(line)
+			jt.AddJob(strings.TrimPrefix(line, "/"))
 		}
 
 		if err := scanner.Err(); err != nil {
 			return err
 		}
 
-		jt.StartAndWait(workers.DownloadContext{C: c, BaseURL: baseURL, BaseDir: baseDir, AllowHTML: true, AlllowEmpty: true}, false)
+		jt.StartAndWait(workers.DownloadContext{C: c, BaseURL: baseURL, BaseDir: baseDir, AllowHTML: true, AlllowEmpty: true, WorkTree: true}, false)
 	}
 	return nil
 }
 
-func parseGraphFile(baseDir, graphFile string, objs map[string]bool) {
+func parseGraphFile(baseDir, graphFile string, format utils.ObjectFormat, objs map[string]bool) {
 	if utils.Exists(graphFile) {
-		f, err := os.Open(graphFile)
+		graph, err := utils.ReadCommitGraph(graphFile, format)
 		if err != nil {
-			log.Error().Str("dir", baseDir).Str("graph", graphFile).Err(err).Msg("failed to open commit graph")
+			log.Error().Str("dir", baseDir).Str("graph", graphFile).Err(err).Msg("failed to decode commit graph")
 			return
 		}
-		graph, err := commitgraph.OpenFileIndex(f)
+		for _, hash := range graph.Commits {
+			objs[hash] = true
+		}
+		for _, hash := range graph.Trees {
+			objs[hash] = true
+		}
+	}
+}
+
+// parseLooseObjectIdx reads the object name mapping git keeps for repositories
+// with a compatibility object format.
+func parseLooseObjectIdx(gitDir string, format utils.ObjectFormat, objs map[string]bool) {
+	idxPath := utils.URL(gitDir, "objects/loose-object-idx")
+	if utils.Exists(idxPath) {
+		f, err := os.Open(idxPath)
 		if err != nil {
-			log.Error().Str("dir", baseDir).Str("graph", graphFile).Err(err).Msg("failed to decode commit graph")
+			log.Error().Str("dir", gitDir).Err(err).Msg("failed to open loose object index")
 			return
 		}
-		for _, hash := range graph.Hashes() {
-			objs[hash.String()] = true
-			i, err := graph.GetIndexByHash(hash)
-			if err != nil {
-				log.Error().Str("dir", baseDir).Str("graph", graphFile).Str("commit", hash.String()).Err(err).Msg("failed get index from graph")
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if strings.HasPrefix(line, "#") {
 				continue
 			}
-			data, err := graph.GetCommitDataByIndex(i)
-			if err != nil {
-				log.Error().Str("dir", baseDir).Str("graph", graphFile).Str("commit", hash.String()).Err(err).Msg("failed get commit data from graph")
-				continue
+			for _, hash := range strings.Fields(line) {
+				if format.IsHash(hash) {
+					objs[hash] = true
+				}
 			}
-			objs[data.TreeHash.String()] = true
-
+		}
+		if err := scanner.Err(); err != nil {
+			log.Error().Str("dir", gitDir).Err(err).Msg("error while parsing loose object index")
 		}
 	}
+}
 
+// readHistory parses the shallow and grafts files of gitDir.
+func readHistory(gitDir string, format utils.ObjectFormat) *utils.History {
+	history := utils.NewHistory()
+	if content, err := os.ReadFile(utils.URL(gitDir, "info/grafts")); err == nil {
+		history.ParseGrafts(content, format)
+	}
+	if content, err := os.ReadFile(utils.URL(gitDir, "shallow")); err == nil {
+		history.ParseShallow(content, format)
+	}
+	return history
+}
+
+// readObjectFormat determines the object format of the dumped repository from
+// its config, falling back to sha1.
+func readObjectFormat(gitDir string) utils.ObjectFormat {
+	content, err := os.ReadFile(utils.URL(gitDir, "config"))
+	if err != nil {
+		return utils.SHA1
+	}
+	format, err := utils.ObjectFormatFromConfig(content)
+	if err != nil {
+		log.Warn().Str("dir", gitDir).Err(err).Msg("couldn't determine object format, assuming sha1")
+	}
+	log.Info().Str("dir", gitDir).Str("format", format.Name).Msg("detected object format")
+	return format
 }
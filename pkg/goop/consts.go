@@ -4,17 +4,26 @@ import "regexp"
 
 const maxConcurrency = 40
 
+// how deep we follow per-directory metadata (like .svn/entries) down the tree
+const maxDirDepth = 64
+
+// how many of the numbered patches of an am or rebase --apply we look for, as
+// the count in rebase-apply/last comes from the server
+const maxStatePatches = 1000
+
 var refPrefix = []byte{'r', 'e', 'f', ':'}
 var (
-	packRegex   = regexp.MustCompile(`(?m)pack-([a-f0-9]{40})\.pack`)
-	objRegex    = regexp.MustCompile(`(?m)(^|\s)([a-f0-9]{40})($|\s)`) // TODO: add sha256 support
-	refLogRegex = regexp.MustCompile(`(?m)^(?:[a-f0-9]{40}) ([a-f0-9]{40}) .*$`)
+	// these match both sha1 and sha256 object names, hashes that don't match the
+	// object format of the repository are filtered out later on
+	packRegex   = regexp.MustCompile(`(?m)pack-([a-f0-9]{64}|[a-f0-9]{40})\.pack`)
+	objRegex    = regexp.MustCompile(`(?m)(^|\s)([a-f0-9]{64}|[a-f0-9]{40})($|\s)`)
+	refLogRegex = regexp.MustCompile(`(?m)^(?:[a-f0-9]{64}|[a-f0-9]{40}) ([a-f0-9]{64}|[a-f0-9]{40}) .*$`)
 )
 var (
 	commonFiles = []string{
 		".gitignore",
 		".gitattributes",
-		".gitmodules", // TODO: fetch submodules
+		".gitmodules",
 		".env",
 		".git/COMMIT_EDITMSG",
 		".git/description",
@@ -22,16 +31,23 @@ var (
 		".git/hooks/applypatch-msg",
 		".git/hooks/commit-msg.sample",
 		".git/hooks/commit-msg",
+		".git/hooks/fsmonitor-watchman.sample",
+		".git/hooks/fsmonitor-watchman",
+		".git/hooks/post-checkout",
 		".git/hooks/post-commit.sample",
 		".git/hooks/post-commit",
+		".git/hooks/post-merge",
 		".git/hooks/post-receive.sample",
 		".git/hooks/post-receive",
 		".git/hooks/post-update.sample",
+		".git/hooks/post-rewrite",
 		".git/hooks/post-update",
 		".git/hooks/pre-applypatch.sample",
 		".git/hooks/pre-applypatch",
 		".git/hooks/pre-commit.sample",
 		".git/hooks/pre-commit",
+		".git/hooks/pre-merge-commit.sample",
+		".git/hooks/pre-merge-commit",
 		".git/hooks/pre-push.sample",
 		".git/hooks/pre-push",
 		".git/hooks/pre-rebase.sample",
@@ -40,20 +56,25 @@ var (
 		".git/hooks/pre-receive",
 		".git/hooks/prepare-commit-msg.sample",
 		".git/hooks/prepare-commit-msg",
+		".git/hooks/push-to-checkout.sample",
+		".git/hooks/push-to-checkout",
+		".git/hooks/reference-transaction",
 		".git/hooks/update.sample",
 		".git/hooks/update",
 		".git/index",
 		".git/info/exclude",
 		".git/objects/info/packs",
-		".git/objects/info/alternates",                       // TODO: parse and process
-		".git/objects/info/http-alternates",                  // TODO: parse and process
+		".git/objects/info/alternates",
+		".git/objects/info/http-alternates",
 		".git/objects/info/commit-graph",                     // TODO: parse for object hashes
 		".git/objects/info/commit-graphs/commit-graph-chain", // TODO: read file and fetch mentioned graph files too, then parse those for object hashes
-		".git/info/grafts",                                   // TODO: parse and process
-		".git/info/attributes",                               // TODO: can lfs filters be in here?
-		".git/info/sparse-checkout",                          // TODO: parse and process
-		".git/objects/loose-object-idx",                      // TODO: parse and process
-		".git/objects/pack/multi-pack-index",                 // TODO: parse and process and fetch referenced packs (unless i'm missunderstanding this)
+		".git/info/grafts",
+		".git/info/attributes",      // TODO: can lfs filters be in here?
+		".git/info/sparse-checkout", // TODO: parse and process
+		".git/objects/loose-object-idx",
+		".git/shallow",
+		".git/objects/pack/multi-pack-index",
+		".git/objects/pack/multi-pack-index.d/multi-pack-index-chain",
 	}
 	commonRefs = []string{
 		".git/FETCH_HEAD",
@@ -111,4 +132,213 @@ var (
 		".git/refs/wip/wtree/refs/heads/master", //Magit
 		".git/refs/wip/index/refs/heads/master", //Magit
 	}
+	// files in the git directory of a linked worktree, .git/worktrees/<name>
+	worktreeFiles = []string{
+		"HEAD",
+		"ORIG_HEAD",
+		"FETCH_HEAD",
+		"index",
+		"logs/HEAD",
+		"gitdir",
+		"commondir",
+		"config.worktree",
+		"locked",
+	}
+	commonWorktrees = []string{
+		"main",
+		"master",
+		"dev",
+		"develop",
+		"development",
+		"staging",
+		"stage",
+		"prod",
+		"production",
+		"live",
+		"test",
+		"testing",
+		"qa",
+		"preview",
+		"release",
+		"hotfix",
+		"feature",
+		"beta",
+		"demo",
+		"deploy",
+		"backup",
+		"old",
+		"new",
+		"www",
+		"html",
+		"public",
+		"public_html",
+		"site",
+		"web",
+		"app",
+		"api",
+	}
+	commonRefNames = []string{
+		"master",
+		"main",
+		"dev",
+		"develop",
+		"development",
+		"staging",
+		"stage",
+		"prod",
+		"production",
+		"live",
+		"test",
+		"testing",
+		"qa",
+		"release",
+		"hotfix",
+		"stable",
+		"alpha",
+		"beta",
+		"rc",
+		"latest",
+		"gh-pages",
+		"deploy",
+		"backup",
+		"old",
+		"wip",
+	}
+	commonFeatureNames = []string{
+		"api",
+		"auth",
+		"login",
+		"admin",
+		"dashboard",
+		"search",
+		"payment",
+		"payments",
+		"checkout",
+		"cart",
+		"upload",
+		"export",
+		"import",
+		"ui",
+		"redesign",
+		"refactor",
+		"docker",
+		"ci",
+		"test",
+		"tests",
+	}
+	// files describing an operation in progress, relative to .git
+	stateFiles = []string{
+		"MERGE_HEAD",
+		"MERGE_MSG",
+		"MERGE_MODE",
+		"SQUASH_MSG",
+		"AUTO_MERGE",
+		"REBASE_HEAD",
+		"CHERRY_PICK_HEAD",
+		"REVERT_HEAD",
+		"BISECT_START",
+		"BISECT_LOG",
+		"BISECT_NAMES",
+		"BISECT_TERMS",
+		"BISECT_EXPECTED_REV",
+		"BISECT_ANCESTORS_OK",
+		"BISECT_HEAD",
+		"rebase-merge/head-name",
+		"rebase-merge/onto",
+		"rebase-merge/orig-head",
+		"rebase-merge/git-rebase-todo",
+		"rebase-merge/git-rebase-todo.backup",
+		"rebase-merge/done",
+		"rebase-merge/msgnum",
+		"rebase-merge/end",
+		"rebase-merge/interactive",
+		"rebase-merge/message",
+		"rebase-merge/author-script",
+		"rebase-merge/stopped-sha",
+		"rebase-merge/amend",
+		"rebase-merge/rewritten-list",
+		"rebase-merge/rewritten-pending",
+		"rebase-merge/current-fixups",
+		"rebase-merge/squash-onto",
+		"rebase-merge/autostash",
+		"rebase-apply/head-name",
+		"rebase-apply/onto",
+		"rebase-apply/orig-head",
+		"rebase-apply/next",
+		"rebase-apply/last",
+		"rebase-apply/applying",
+		"rebase-apply/rebasing",
+		"rebase-apply/original-commit",
+		"rebase-apply/abort-safety",
+		"rebase-apply/final-commit",
+		"rebase-apply/msg",
+		"rebase-apply/author-script",
+		"rebase-apply/autostash",
+		"sequencer/todo",
+		"sequencer/done",
+		"sequencer/head",
+		"sequencer/abort-safety",
+		"sequencer/opts",
+	}
+	svnFiles = []string{
+		".svn/wc.db",
+		".svn/entries",
+		".svn/format",
+	}
+	hgFiles = []string{
+		".hgignore",
+		".hgtags",
+		".hgsub",
+		".hgsubstate",
+		".hg/requires",
+		".hg/hgrc",
+		".hg/branch",
+		".hg/dirstate",
+		".hg/bookmarks",
+		".hg/bookmarks.current",
+		".hg/undo.desc",
+		".hg/undo.branch",
+		".hg/last-message.txt",
+		".hg/cache/branch2-base",
+		".hg/cache/branch2-immutable",
+		".hg/cache/branch2-served",
+		".hg/cache/branch2-visible",
+		".hg/cache/tags2-visible",
+		".hg/00changelog.i",
+		".hg/00changelog.d",
+		".hg/00manifest.i",
+		".hg/00manifest.d",
+		".hg/store/requires",
+		".hg/store/00changelog.i",
+		".hg/store/00changelog.d",
+		".hg/store/00manifest.i",
+		".hg/store/00manifest.d",
+		".hg/store/fncache",
+		".hg/store/phaseroots",
+		".hg/store/undo.backupfiles",
+	}
+	cvsFiles = []string{
+		"CVS/Entries",
+		"CVS/Entries.Log",
+		"CVS/Root",
+		"CVS/Repository",
+		"CVS/Tag",
+	}
+	bzrFiles = []string{
+		".bzrignore",
+		".bzr/README",
+		".bzr/branch-format",
+		".bzr/branch/format",
+		".bzr/branch/last-revision",
+		".bzr/branch/branch.conf",
+		".bzr/branch/location",
+		".bzr/branch/tags",
+		".bzr/checkout/format",
+		".bzr/checkout/dirstate",
+		".bzr/checkout/conflicts",
+		".bzr/checkout/merge-hashes",
+		".bzr/checkout/views",
+		".bzr/repository/format",
+		".bzr/repository/pack-names",
+	}
 )
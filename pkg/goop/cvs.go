@@ -0,0 +1,79 @@
+package goop
+
+import (
+	"os"
+	"path"
+	"strings"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/deletescape/goop/internal/workers"
+	"github.com/deletescape/jobtracker"
+	"github.com/phuslu/log"
+)
+
+// FetchCvs dumps the CVS checkout exposed at baseURL. CVS doesn't keep a copy
+// of the files it checks out, so all we can do is list them from the CVS/
+// directories and fetch them from the server.
+func FetchCvs(baseURL, baseDir string) error {
+	var files, added, removed []string
+	level := []string{""}
+	for depth := 0; len(level) > 0 && depth < maxDirDepth; depth++ {
+		jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+		for _, dir := range level {
+			for _, file := range cvsFiles {
+				if p := path.Join(dir, file); !utils.Exists(utils.URL(baseDir, p)) {
+					jt.AddJob(p)
+				}
+			}
+		}
+		jt.StartAndWait(workers.DownloadContext{C: c, BaseURL: baseURL, BaseDir: baseDir}, false)
+
+		var next []string
+		for _, dir := range level {
+			read := func(file string) []byte {
+				content, _ := os.ReadFile(utils.URL(baseDir, path.Join(dir, file)))
+				return content
+			}
+			entries := read("CVS/Entries")
+			if entries == nil {
+				continue
+			}
+			if dir == "" {
+				log.Info().Str("base", baseURL).
+					Str("root", strings.TrimSpace(string(read("CVS/Root")))).
+					Str("repository", strings.TrimSpace(string(read("CVS/Repository")))).
+					Str("tag", strings.TrimSpace(string(read("CVS/Tag")))).
+					Msg("cvs checkout")
+			}
+			for _, e := range utils.ParseCvsEntries(entries, read("CVS/Entries.Log")) {
+				p := path.Join(dir, e.Name)
+				if strings.Contains(e.Name, "/") || !utils.IsSafeWorkTreePath(p, "CVS") {
+					continue
+				}
+				switch {
+				case e.Dir:
+					next = append(next, p)
+				case e.Removed():
+					removed = append(removed, p)
+				default:
+					if e.Added() {
+						added = append(added, p)
+					}
+					files = append(files, p)
+				}
+			}
+		}
+		level = next
+	}
+	log.Info().Str("base", baseURL).Int("files", len(files)).Strs("added", added).Strs("removed", removed).Msg("listed cvs checkout")
+
+	log.Info().Str("base", baseURL).Str("dir", baseDir).Msg("attempting to fetch listed files")
+	jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+	for _, f := range files {
+		if !strings.HasSuffix(f, ".php") {
+			jt.AddJob(f)
+		}
+	}
+	jt.StartAndWait(workers.DownloadContext{C: c, BaseURL: baseURL, BaseDir: baseDir, AllowHTML: true, AlllowEmpty: true, WorkTree: true}, false)
+	return nil
+}
@@ -0,0 +1,51 @@
+package goop
+
+import (
+	"bytes"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/deletescape/goop/internal/workers"
+	"github.com/phuslu/log"
+)
+
+const (
+	vcsGit = "git"
+	vcsSvn = "svn"
+	vcsHg  = "hg"
+	vcsBzr = "bzr"
+	vcsCvs = "cvs"
+)
+
+// detectVCS returns the version control systems to dump at baseURL: git,
+// which is always attempted since it can be dumped through the smart http
+// protocol, refs or directory listings even when .git/HEAD is blocked, and
+// every other one whose metadata is exposed.
+func detectVCS(baseURL string) []string {
+	exposed := func(file string, magic []byte) bool {
+		uri := utils.URL(baseURL, file)
+		code, body, err := workers.Get(c, uri)
+		if err != nil || code != 200 || utils.IsHTML(body) || utils.IsEmptyBytes(body) || workers.IsNotFound(uri, body) {
+			return false
+		}
+		return magic == nil || bytes.HasPrefix(body, magic)
+	}
+
+	found := []string{vcsGit}
+	if exposed(".svn/wc.db", []byte("SQLite format 3\x00")) || exposed(".svn/entries", nil) {
+		log.Info().Str("base", baseURL).Msg("found subversion working copy")
+		found = append(found, vcsSvn)
+	}
+	if exposed(".hg/requires", nil) || exposed(".hg/store/00changelog.i", nil) {
+		log.Info().Str("base", baseURL).Msg("found mercurial repository")
+		found = append(found, vcsHg)
+	}
+	if exposed(".bzr/branch-format", []byte("Bazaar")) {
+		log.Info().Str("base", baseURL).Msg("found bazaar checkout")
+		found = append(found, vcsBzr)
+	}
+	if exposed("CVS/Entries", nil) || exposed("CVS/Root", nil) {
+		log.Info().Str("base", baseURL).Msg("found cvs checkout")
+		found = append(found, vcsCvs)
+	}
+	return found
+}
@@ -0,0 +1,62 @@
+package goop
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/phuslu/log"
+)
+
+// sanitizeGitDir keeps the dumped git directory from running anything when
+// git is run in it later: only the keys of its config files needed to read
+// the repository are kept, with the original files kept next to them as
+// config.untrusted, and its hooks are made non-executable.
+func sanitizeGitDir(gitDir string, worktrees []string) {
+	configs := []string{"config", "config.worktree"}
+	for _, name := range worktrees {
+		configs = append(configs, "worktrees/"+name+"/config.worktree")
+	}
+	for _, config := range configs {
+		path := utils.URL(gitDir, config)
+		content, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		sanitized, removed := utils.SanitizeGitConfig(content)
+		if len(removed) == 0 {
+			continue
+		}
+		log.Info().Str("file", path).Strs("keys", removed).Msg("removing the keys of the dumped git config that aren't needed to read it")
+		if evidence := config + ".untrusted"; !utils.Exists(utils.URL(gitDir, evidence)) {
+			if err := utils.WriteConfinedFile(gitDir, evidence, content, 0644, false); err != nil {
+				log.Error().Str("file", path+".untrusted").Err(err).Msg("couldn't keep original config")
+				continue
+			}
+		}
+		if err := utils.WriteConfinedFile(gitDir, config, sanitized, 0644, false); err != nil {
+			log.Error().Str("file", path).Err(err).Msg("couldn't write sanitized config")
+		}
+	}
+
+	hooks, _ := filepath.Glob(utils.URL(gitDir, "hooks/*"))
+	var active []string
+	for _, hook := range hooks {
+		info, err := os.Lstat(hook)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if info.Mode()&0111 != 0 {
+			if err := os.Chmod(hook, 0644); err != nil {
+				log.Error().Str("file", hook).Err(err).Msg("couldn't disable hook")
+			}
+		}
+		if !strings.HasSuffix(hook, ".sample") {
+			active = append(active, filepath.Base(hook))
+		}
+	}
+	if len(active) > 0 {
+		log.Warn().Str("dir", gitDir).Strs("hooks", active).Msg("dumped repository has hooks, they are kept non-executable")
+	}
+}
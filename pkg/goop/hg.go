@@ -0,0 +1,285 @@
+package goop
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/deletescape/goop/internal/workers"
+	"github.com/deletescape/jobtracker"
+	"github.com/phuslu/log"
+)
+
+// requirements we can't read the store of
+var unsupportedHgRequires = []string{"revlogv2", "changelogv2", "treemanifest", "revlog-compression-zstd"}
+
+// FetchHg dumps the Mercurial repository exposed at baseURL, rebuilding the
+// working tree of its tip from the revlogs.
+func FetchHg(baseURL, baseDir string) error {
+	log.Info().Str("base", baseURL).Msg("testing if recursive download is possible")
+	code, body, err := workers.Get(c, utils.URL(baseURL, ".hg/"))
+	if err != nil && !utils.IgnoreError(err) {
+		return err
+	}
+	if code == 200 && utils.IsHTML(body) && !workers.IsNotFound(utils.URL(baseURL, ".hg/"), body) {
+		lnk, _ := url.Parse(utils.URL(baseURL, ".hg/"))
+		indexedFiles, err := utils.GetIndexedFiles(body, lnk.Path)
+		if err != nil {
+			return err
+		}
+		if utils.StringsContain(indexedFiles, "requires") || utils.StringsContain(indexedFiles, "00changelog.i") {
+			log.Info().Str("base", baseURL).Msg("fetching .hg/ recursively")
+			jt := jobtracker.NewJobTracker(workers.RecursiveDownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+			jt.AddJobs(indexedFiles...)
+			jt.StartAndWait(workers.RecursiveDownloadContext{C: c, BaseURL: utils.URL(baseURL, ".hg/"), BaseDir: utils.URL(baseDir, ".hg/")}, true)
+		}
+	}
+
+	log.Info().Str("base", baseURL).Msg("fetching mercurial metadata")
+	jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+	jt.AddJobs(hgFiles...)
+	jt.StartAndWait(workers.DownloadContext{C: c, BaseURL: baseURL, BaseDir: baseDir}, false)
+
+	requires := readHgRequires(baseDir)
+	for _, req := range unsupportedHgRequires {
+		if requires[req] {
+			log.Warn().Str("base", baseURL).Str("requirement", req).Msg("unsupported repository format, the working tree will most likely be incomplete")
+		}
+	}
+	storeDir := ".hg"
+	if requires["store"] {
+		storeDir = ".hg/store"
+	}
+	localStore := utils.URL(baseDir, storeDir)
+
+	changelog, err := utils.ReadRevlog(utils.URL(localStore, "00changelog.i"))
+	if err != nil {
+		return fmt.Errorf("couldn't read changelog: %w", err)
+	}
+	if changelog.Len() == 0 {
+		return fmt.Errorf("changelog at %s is empty", baseURL)
+	}
+	tip := changelog.Len() - 1
+	text, err := changelog.Revision(tip)
+	if err != nil {
+		return fmt.Errorf("couldn't read tip: %w", err)
+	}
+	cs, err := utils.ParseHgChangeset(text)
+	if err != nil {
+		return fmt.Errorf("couldn't parse tip: %w", err)
+	}
+	summary, _, _ := strings.Cut(cs.Description, "\n")
+	log.Info().Str("base", baseURL).Int("rev", tip).Str("node", changelog.Node(tip)).Str("branch", cs.Branch).Str("user", cs.User).Str("summary", summary).Msg("found tip")
+
+	var files []utils.HgManifestEntry
+	manifest, err := utils.ReadRevlog(utils.URL(localStore, "00manifest.i"))
+	if err != nil {
+		log.Error().Str("dir", baseDir).Err(err).Msg("couldn't read manifest")
+	} else if rev, ok := manifest.Rev(cs.Manifest); !ok {
+		log.Error().Str("dir", baseDir).Str("node", cs.Manifest).Msg("manifest of tip is missing")
+	} else if text, err := manifest.Revision(rev); err != nil {
+		log.Error().Str("dir", baseDir).Str("node", cs.Manifest).Err(err).Msg("couldn't read manifest of tip")
+	} else if files, err = utils.ParseHgManifest(text); err != nil {
+		log.Error().Str("dir", baseDir).Str("node", cs.Manifest).Err(err).Msg("couldn't parse manifest of tip")
+	}
+
+	fetchHgFilelogs(baseURL, baseDir, storeDir, requires, files)
+	ds := readHgDirstate(baseDir, requires)
+	restoreHgFiles(baseURL, baseDir, storeDir, requires, files, ds)
+	reportHg(baseURL, baseDir, changelog, ds)
+	return nil
+}
+
+func readHgRequires(baseDir string) map[string]bool {
+	requires := make(map[string]bool)
+	// share-safe repositories keep most of their requirements in the store
+	for _, file := range []string{".hg/requires", ".hg/store/requires"} {
+		content, err := os.ReadFile(utils.URL(baseDir, file))
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				requires[line] = true
+			}
+		}
+	}
+	return requires
+}
+
+// fetchHgFilelogs downloads the revlog of every file of the manifest, and of
+// every file listed in the fncache, which includes deleted ones.
+func fetchHgFilelogs(baseURL, baseDir, storeDir string, requires map[string]bool, files []utils.HgManifestEntry) {
+	storeURL := utils.URL(baseURL, storeDir)
+	localStore := utils.URL(baseDir, storeDir)
+
+	revlogs := make(map[string]bool)
+	for _, f := range files {
+		revlogs["data/"+f.Path+".i"] = true
+	}
+	if content, err := os.ReadFile(utils.URL(localStore, "fncache")); err == nil {
+		for _, line := range strings.Split(string(content), "\n") {
+			if strings.HasPrefix(line, "data/") && strings.HasSuffix(line, ".i") {
+				revlogs[line] = true
+			}
+		}
+	}
+
+	log.Info().Str("base", baseURL).Int("count", len(revlogs)).Msg("fetching file revlogs")
+	jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+	for revlog := range revlogs {
+		jt.AddJob(utils.HgStorePath(revlog, requires))
+	}
+	jt.StartAndWait(workers.DownloadContext{C: c, BaseURL: storeURL, BaseDir: localStore}, false)
+
+	// revlogs that grew too big keep their data in a separate file
+	jt = jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+	for revlog := range revlogs {
+		index := utils.HgStorePath(revlog, requires)
+		content, err := os.ReadFile(utils.URL(localStore, index))
+		if err == nil && !utils.RevlogIsInline(content) {
+			jt.AddJob(strings.TrimSuffix(index, ".i") + ".d")
+		}
+	}
+	jt.StartAndWait(workers.DownloadContext{C: c, BaseURL: storeURL, BaseDir: localStore}, false)
+}
+
+func readHgDirstate(baseDir string, requires map[string]bool) *utils.HgDirstate {
+	dirstatePath := utils.URL(baseDir, ".hg/dirstate")
+	content, err := os.ReadFile(dirstatePath)
+	if err != nil {
+		return nil
+	}
+	if requires["dirstate-v2"] {
+		log.Warn().Str("file", dirstatePath).Msg("dirstate-v2 isn't supported")
+		return nil
+	}
+	ds, err := utils.ParseHgDirstate(content)
+	if err != nil {
+		log.Error().Str("file", dirstatePath).Err(err).Msg("couldn't parse dirstate")
+	}
+	return ds
+}
+
+// restoreHgFiles writes the files of the manifest to the working tree, and
+// fetches the live file for those whose revlog is missing, as well as the
+// files added to the working directory since.
+func restoreHgFiles(baseURL, baseDir, storeDir string, requires map[string]bool, files []utils.HgManifestEntry, ds *utils.HgDirstate) {
+	localStore := utils.URL(baseDir, storeDir)
+	var missing []string
+	for _, f := range files {
+		if !utils.IsSafeWorkTreePath(f.Path, ".hg") || strings.Contains(f.Flags, "t") {
+			continue
+		}
+		target := utils.URL(baseDir, f.Path)
+		if utils.Exists(target) {
+			continue
+		}
+		filelog, err := utils.ReadRevlog(utils.URL(localStore, utils.HgStorePath("data/"+f.Path+".i", requires)))
+		if err != nil {
+			missing = append(missing, f.Path)
+			continue
+		}
+		rev, ok := filelog.Rev(f.Node)
+		if !ok {
+			missing = append(missing, f.Path)
+			continue
+		}
+		text, err := filelog.Revision(rev)
+		if err != nil {
+			log.Warn().Str("dir", baseDir).Str("file", f.Path).Err(err).Msg("couldn't read file revision")
+			missing = append(missing, f.Path)
+			continue
+		}
+
+		mode := os.FileMode(0644)
+		if strings.Contains(f.Flags, "x") {
+			mode = 0755
+		}
+		if err := utils.WriteConfinedFile(baseDir, f.Path, utils.HgFileText(text), mode, false); err != nil {
+			log.Error().Str("file", target).Err(err).Msg("couldn't write file")
+		}
+	}
+	if ds != nil {
+		for _, e := range ds.Entries {
+			if e.State == "a" && utils.IsSafeWorkTreePath(e.Path, ".hg") {
+				missing = append(missing, e.Path)
+			}
+		}
+	}
+
+	log.Info().Str("base", baseURL).Str("dir", baseDir).Int("count", len(missing)).Msg("attempting to fetch files without a revlog")
+	jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+	for _, f := range missing {
+		if !strings.HasSuffix(f, ".php") {
+			jt.AddJob(f)
+		}
+	}
+	jt.StartAndWait(workers.DownloadContext{C: c, BaseURL: baseURL, BaseDir: baseDir, AllowHTML: true, AlllowEmpty: true, WorkTree: true}, false)
+}
+
+// reportHg logs the branches, bookmarks and working directory state of the
+// repository.
+func reportHg(baseURL, baseDir string, changelog *utils.Revlog, ds *utils.HgDirstate) {
+	read := func(file string) string {
+		content, _ := os.ReadFile(utils.URL(baseDir, file))
+		return strings.TrimSpace(string(content))
+	}
+	rev := func(node string) int {
+		if r, ok := changelog.Rev(node); ok {
+			return r
+		}
+		return -1
+	}
+
+	for _, cache := range []string{"branch2-served", "branch2-visible", "branch2-immutable", "branch2-base"} {
+		content := read(".hg/cache/" + cache)
+		if content == "" {
+			continue
+		}
+		scanner := bufio.NewScanner(strings.NewReader(content))
+		// the first line is the tip the cache is valid for
+		scanner.Scan()
+		for scanner.Scan() {
+			fields := strings.SplitN(scanner.Text(), " ", 3)
+			if len(fields) == 3 {
+				log.Info().Str("base", baseURL).Str("branch", fields[2]).Str("head", fields[0]).Int("rev", rev(fields[0])).Bool("closed", fields[1] == "c").Msg("found branch head")
+			}
+		}
+		break
+	}
+
+	active := read(".hg/bookmarks.current")
+	for _, line := range strings.Split(read(".hg/bookmarks"), "\n") {
+		node, name, ok := strings.Cut(line, " ")
+		if ok {
+			log.Info().Str("base", baseURL).Str("bookmark", name).Str("node", node).Int("rev", rev(node)).Bool("active", name == active).Msg("found bookmark")
+		}
+	}
+
+	if ds == nil {
+		return
+	}
+	var added, removed, merged []string
+	for _, e := range ds.Entries {
+		switch e.State {
+		case "a":
+			added = append(added, e.Path)
+		case "r":
+			removed = append(removed, e.Path)
+		case "m":
+			merged = append(merged, e.Path)
+		}
+	}
+	entry := log.Info().Str("base", baseURL).Str("branch", read(".hg/branch")).Str("parent", ds.Parents[0]).Int("rev", rev(ds.Parents[0])).Int("tracked", len(ds.Entries))
+	if ds.Parents[1] != strings.Repeat("0", 40) {
+		entry = entry.Str("merging", ds.Parents[1])
+	}
+	entry.Strs("added", added).Strs("removed", removed).Strs("merged", merged).Msg("working directory state")
+	if tip := changelog.Node(changelog.Len() - 1); ds.Parents[0] != tip {
+		log.Warn().Str("base", baseURL).Str("parent", ds.Parents[0]).Str("tip", tip).Msg("working directory isn't at tip, the dumped working tree may differ from the live one")
+	}
+}
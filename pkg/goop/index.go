@@ -0,0 +1,98 @@
+package goop
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/deletescape/goop/internal/workers"
+	"github.com/deletescape/jobtracker"
+	"github.com/phuslu/log"
+)
+
+// fetchSharedIndexes downloads the shared index of every split index in
+// indexPaths, so they can be merged whenever an index is read.
+func fetchSharedIndexes(baseURL, baseDir string, indexPaths []string, format utils.ObjectFormat) {
+	jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+	for _, path := range indexPaths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		idx, err := utils.DecodeIndex(data, format)
+		if idx == nil {
+			log.Error().Str("dir", baseDir).Str("index", path).Err(err).Msg("couldn't decode git index")
+			continue
+		}
+		if shared := idx.SharedIndex(format); shared != "" {
+			rel, err := filepath.Rel(baseDir, filepath.Join(filepath.Dir(path), "sharedindex."+shared))
+			if err != nil {
+				continue
+			}
+			log.Info().Str("dir", baseDir).Str("index", path).Str("shared", shared).Msg("index is split, fetching shared index")
+			jt.AddJob(filepath.ToSlash(rel))
+		}
+	}
+	jt.StartAndWait(workers.DownloadContext{C: c, BaseURL: baseURL, BaseDir: baseDir}, false)
+}
+
+// rebuildIndex writes a new index for repo from the tree of its HEAD commit,
+// for when the server didn't let us have the real one.
+func rebuildIndex(baseDir string, repo repository, format utils.ObjectFormat) error {
+	gitDir := utils.URL(baseDir, repo.gitDir)
+	head, err := repo.resolveHead(baseDir, format)
+	if err != nil {
+		return err
+	}
+	store, err := utils.OpenObjectStore(utils.URL(baseDir, repo.objectDir()), format)
+	if err != nil {
+		log.Warn().Str("dir", gitDir).Err(err).Msg("couldn't open all pack files")
+	}
+	defer store.Close()
+
+	typ, content, err := store.Object(head)
+	if err != nil {
+		return err
+	}
+	if typ != "commit" {
+		return fmt.Errorf("HEAD points to a %s, not a commit", typ)
+	}
+	tree, _ := utils.ParseCommit(content, format)
+
+	idx := &utils.Index{Version: 2}
+	var walk func(hash, prefix string) error
+	walk = func(hash, prefix string) error {
+		_, content, err := store.Object(hash)
+		if err != nil {
+			return err
+		}
+		treeEntries, err := utils.ParseTree(content, format)
+		if err != nil {
+			return err
+		}
+		for _, e := range treeEntries {
+			if e.Mode == 040000 {
+				if err := walk(e.Hash, prefix+e.Name+"/"); err != nil {
+					return err
+				}
+				continue
+			}
+			idx.Entries = append(idx.Entries, &utils.IndexEntry{
+				Hash: e.Hash,
+				Name: prefix + e.Name,
+				Mode: e.Mode,
+			})
+		}
+		return nil
+	}
+	if err := walk(tree, ""); err != nil {
+		return fmt.Errorf("couldn't read tree of HEAD: %w", err)
+	}
+
+	data, err := utils.EncodeIndex(idx, format)
+	if err != nil {
+		return err
+	}
+	return utils.WriteConfinedFile(baseDir, utils.URL(repo.gitDir, "index"), data, 0644, true)
+}
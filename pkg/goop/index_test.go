@@ -0,0 +1,91 @@
+package goop
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+)
+
+func TestRebuildIndex(t *testing.T) {
+	for _, format := range []utils.ObjectFormat{utils.SHA1, utils.SHA256} {
+		t.Run(format.Name, func(t *testing.T) {
+			dir := t.TempDir()
+			gitDir := filepath.Join(dir, ".git")
+			write := func(typ string, content []byte) string {
+				t.Helper()
+				hash, err := utils.WriteLooseObject(gitDir, typ, content, format)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return hash
+			}
+			tree := func(entries ...utils.TreeEntry) string {
+				t.Helper()
+				content, err := utils.EncodeTree(entries, format)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return write("tree", content)
+			}
+
+			readme := write("blob", []byte("hello\n"))
+			main := write("blob", []byte("package main\n"))
+			lib := tree(utils.TreeEntry{Mode: 0100755, Name: "run.sh", Hash: main})
+			root := tree(
+				utils.TreeEntry{Mode: 0100644, Name: "README", Hash: readme},
+				utils.TreeEntry{Mode: 040000, Name: "src", Hash: lib},
+				utils.TreeEntry{Mode: 0100644, Name: "src-file", Hash: readme},
+			)
+			commit := write("commit", []byte("tree "+root+"\nauthor a <a@b> 0 +0000\ncommitter a <a@b> 0 +0000\n\ninit\n"))
+			if err := os.MkdirAll(filepath.Join(gitDir, "refs/heads"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			os.WriteFile(filepath.Join(gitDir, "HEAD"), []byte("ref: refs/heads/master\n"), 0644)
+			os.WriteFile(filepath.Join(gitDir, "refs/heads/master"), []byte(commit+"\n"), 0644)
+
+			if err := rebuildIndex(dir, mainRepository, format); err != nil {
+				t.Fatal(err)
+			}
+
+			idx, err := utils.ReadIndex(filepath.Join(gitDir, "index"), format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []utils.IndexEntry{
+				{Name: "README", Hash: readme, Mode: 0100644},
+				{Name: "src-file", Hash: readme, Mode: 0100644},
+				{Name: "src/run.sh", Hash: main, Mode: 0100755},
+			}
+			if len(idx.Entries) != len(want) {
+				t.Fatalf("index has %d entries, want %d", len(idx.Entries), len(want))
+			}
+			for i, e := range idx.Entries {
+				if e.Name != want[i].Name || e.Hash != want[i].Hash || e.Mode != want[i].Mode {
+					t.Errorf("entry %d = %s %o %s, want %s %o %s", i, e.Name, e.Mode, e.Hash, want[i].Name, want[i].Mode, want[i].Hash)
+				}
+			}
+			trees, err := idx.Trees(format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if trees[""].Hash != root {
+				t.Errorf("index describes tree %s, want %s", trees[""].Hash, root)
+			}
+
+			if format.Name == utils.SHA1.Name {
+				// go-git checks the trailing checksum
+				f, err := os.Open(filepath.Join(gitDir, "index"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+				if err := index.NewDecoder(f).Decode(&index.Index{}); err != nil {
+					t.Errorf("go-git can't decode the index: %v", err)
+				}
+			}
+		})
+	}
+}
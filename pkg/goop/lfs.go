@@ -0,0 +1,367 @@
+package goop
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/deletescape/goop/internal/workers"
+	"github.com/deletescape/jobtracker"
+	"github.com/phuslu/log"
+	"github.com/valyala/fasthttp"
+	"gopkg.in/ini.v1"
+)
+
+const (
+	lfsMediaType = "application/vnd.git-lfs+json"
+	// how many objects we ask the batch API about at once
+	lfsBatchSize = 100
+)
+
+// fetchLfs finds the git lfs pointers among all blobs of the repository,
+// fetches the objects they point to, and replaces the pointer files in the
+// work tree with their content. The batch API is only used on the host of
+// baseURL and on lfsHosts.
+func fetchLfs(baseDir, baseURL string, repo repository, format utils.ObjectFormat, lfsHosts []string) {
+	gitDir := utils.URL(baseDir, repo.gitDir)
+	store, err := utils.OpenObjectStore(gitDir, format)
+	if err != nil {
+		log.Warn().Str("dir", gitDir).Err(err).Msg("couldn't open all pack files")
+	}
+	defer store.Close()
+
+	pointers := findLfsPointers(gitDir, store)
+	if len(pointers) == 0 {
+		return
+	}
+	objects := make(map[string]utils.LfsPointer)
+	for _, p := range pointers {
+		objects[p.Oid] = p
+	}
+	log.Info().Str("dir", baseDir).Int("pointers", len(pointers)).Int("objects", len(objects)).Msg("attempting to fetch git lfs objects")
+
+	jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+	for oid := range objects {
+		if file := repo.path(".git/" + utils.LfsObjectPath(oid)); !utils.Exists(utils.URL(baseDir, file)) {
+			jt.AddJob(file)
+		}
+	}
+	jt.StartAndWait(workers.DownloadContext{C: c, BaseURL: baseURL, BaseDir: baseDir}, false)
+
+	var missing []utils.LfsPointer
+	for _, p := range objects {
+		fp := utils.URL(gitDir, utils.LfsObjectPath(p.Oid))
+		err := p.VerifyFile(fp)
+		if err == nil {
+			continue
+		}
+		if !os.IsNotExist(err) {
+			log.Warn().Str("file", fp).Err(err).Msg("lfs object doesn't match its oid, removing it")
+			os.Remove(fp)
+		}
+		missing = append(missing, p)
+	}
+	if len(missing) > 0 {
+		hosts := lfsHosts
+		if u, err := url.Parse(baseURL); err == nil {
+			hosts = append([]string{u.Hostname()}, lfsHosts...)
+		}
+		fetchLfsBatch(baseDir, repo, missing, hosts)
+	}
+
+	smudgeLfs(baseDir, repo, format, pointers)
+}
+
+// findLfsPointers returns the lfs pointer in every blob of store, keyed by the
+// name of the blob. Blobs too large to be pointers aren't inflated.
+func findLfsPointers(gitDir string, store *utils.ObjectStore) map[string]utils.LfsPointer {
+	pointers := make(map[string]utils.LfsPointer)
+	hashes, err := store.Hashes()
+	if err != nil {
+		log.Error().Str("dir", gitDir).Err(err).Msg("couldn't list objects")
+		return pointers
+	}
+	for _, hash := range hashes {
+		typ, size, err := store.ObjectHeader(hash)
+		if err != nil || typ != "blob" || size > utils.LfsPointerMaxSize {
+			continue
+		}
+		_, content, err := store.Object(hash)
+		if err != nil {
+			continue
+		}
+		if p, ok := utils.ParseLfsPointer(content); ok {
+			pointers[hash] = p
+		}
+	}
+	return pointers
+}
+
+// smudgeLfs replaces the files of the work tree that are still lfs pointers
+// with the objects they point to, like the smudge filter of git lfs would.
+func smudgeLfs(baseDir string, repo repository, format utils.ObjectFormat, pointers map[string]utils.LfsPointer) {
+	gitDir := utils.URL(baseDir, repo.gitDir)
+	workDir := utils.URL(baseDir, repo.workTree)
+	idx, err := utils.ReadIndex(utils.URL(gitDir, "index"), format)
+	if err != nil {
+		log.Error().Str("dir", baseDir).Err(err).Msg("couldn't decode git index")
+		if idx == nil {
+			return
+		}
+	}
+
+	smudged := 0
+	for _, entry := range idx.Entries {
+		p, ok := pointers[entry.Hash]
+		if !ok || entry.Stage != 0 || entry.Name == "" {
+			continue
+		}
+		fp, err := utils.ConfinedPath(workDir, entry.Name, false)
+		if err != nil {
+			log.Warn().Str("dir", workDir).Str("file", entry.Name).Err(err).Msg("refusing to smudge file")
+			continue
+		}
+		f, err := os.Open(fp)
+		if err != nil {
+			continue
+		}
+		content, err := io.ReadAll(io.LimitReader(f, utils.LfsPointerMaxSize+1))
+		f.Close()
+		// files fetched from the site already have their actual content
+		if current, ok := utils.ParseLfsPointer(content); err != nil || !ok || current != p {
+			continue
+		}
+		object, err := os.ReadFile(utils.URL(gitDir, utils.LfsObjectPath(p.Oid)))
+		if err != nil || !p.Verify(object) {
+			log.Warn().Str("file", entry.Name).Str("oid", p.Oid).Msg("lfs object is missing, leaving pointer in place")
+			continue
+		}
+		if err := utils.WriteConfinedFile(workDir, entry.Name, object, os.ModePerm, false); err != nil {
+			log.Error().Str("file", fp).Err(err).Msg("couldn't write file")
+			continue
+		}
+		smudged++
+	}
+	log.Info().Str("dir", baseDir).Int("count", smudged).Msg("replaced lfs pointers with their content")
+}
+
+// fetchLfsBatch downloads objects from the lfs server of the repository
+// through the batch API, for when they aren't exposed with the repository.
+// The server is named by the dumped config, so neither it nor the downloads
+// it points to are contacted unless they are on one of hosts.
+func fetchLfsBatch(baseDir string, repo repository, pointers []utils.LfsPointer, hosts []string) {
+	endpoint := lfsEndpoint(baseDir, repo)
+	if endpoint == "" {
+		log.Info().Str("dir", baseDir).Int("count", len(pointers)).Msg("no lfs server configured, can't fetch missing lfs objects")
+		return
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		log.Warn().Str("dir", baseDir).Err(err).Msg("couldn't parse url of lfs server")
+		return
+	}
+	redacted := u.Redacted()
+	if !utils.LfsHostAllowed(hosts, u.Hostname()) {
+		log.Warn().Str("dir", baseDir).Str("endpoint", redacted).Str("host", u.Hostname()).Msg("lfs server isn't on the target host, not contacting it (allow it with --lfs-hosts)")
+		return
+	}
+	log.Info().Str("dir", baseDir).Str("endpoint", redacted).Str("host", u.Hostname()).Int("count", len(pointers)).Msg("fetching missing lfs objects through the batch api")
+
+	wanted := make(map[string]utils.LfsPointer)
+	for _, p := range pointers {
+		wanted[p.Oid] = p
+	}
+	objects := make(map[string]utils.LfsBatchObject)
+	for i := 0; i < len(pointers); i += lfsBatchSize {
+		res, err := lfsBatch(endpoint, pointers[i:min(i+lfsBatchSize, len(pointers))])
+		if err != nil {
+			log.Error().Str("dir", baseDir).Str("endpoint", redacted).Err(err).Msg("lfs batch request failed")
+			return
+		}
+		for _, obj := range res {
+			p, ok := wanted[obj.Oid]
+			if !ok {
+				continue
+			}
+			if obj.Error != nil {
+				log.Warn().Str("oid", obj.Oid).Int("code", obj.Error.Code).Str("error", obj.Error.Message).Msg("lfs server can't provide object")
+				continue
+			}
+			action, ok := obj.Actions["download"]
+			if !ok {
+				log.Warn().Str("oid", obj.Oid).Msg("lfs server returned no download action for object")
+				continue
+			}
+			href, err := url.Parse(action.Href)
+			if err != nil || (href.Scheme != "http" && href.Scheme != "https") {
+				log.Warn().Str("oid", obj.Oid).Str("uri", action.Href).Msg("lfs server returned an invalid download url")
+				continue
+			}
+			if !utils.LfsHostAllowed(hosts, href.Hostname()) {
+				log.Warn().Str("oid", obj.Oid).Str("host", href.Hostname()).Msg("lfs download isn't on the target host, not fetching it (allow it with --lfs-hosts)")
+				continue
+			}
+			// verify against what we asked for, not what the server claims
+			obj.LfsPointer = p
+			objects[obj.Oid] = obj
+		}
+	}
+
+	jt := jobtracker.NewJobTracker(workers.LfsDownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+	for oid := range objects {
+		jt.AddJob(oid)
+	}
+	jt.StartAndWait(workers.LfsDownloadContext{C: c, GitDir: utils.URL(baseDir, repo.gitDir), Objects: objects, Hosts: hosts}, false)
+}
+
+// lfsBatch asks the batch API at endpoint where to download objects from.
+// Credentials in the endpoint are sent along, and to downloads from the same
+// host if the server doesn't provide any for them.
+func lfsBatch(endpoint string, objects []utils.LfsPointer) ([]utils.LfsBatchObject, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	var auth string
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+password))
+		u.User = nil
+	}
+	body, err := json.Marshal(utils.LfsBatchRequest{
+		Operation: "download",
+		Transfers: []string{"basic"},
+		Objects:   objects,
+		HashAlgo:  "sha256",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	uri := utils.URL(u.String(), "objects/batch")
+	req.SetRequestURI(uri)
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.Header.SetContentType(lfsMediaType)
+	req.Header.Set("Accept", lfsMediaType)
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	req.SetBody(body)
+	if err := c.Do(req, resp); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("%s returned status code %d", uri, resp.StatusCode())
+	}
+
+	var res utils.LfsBatchResponse
+	if err := json.Unmarshal(resp.Body(), &res); err != nil {
+		return nil, err
+	}
+	if res.Transfer != "" && res.Transfer != "basic" {
+		return nil, fmt.Errorf("unsupported transfer adapter %q", res.Transfer)
+	}
+	for _, obj := range res.Objects {
+		action, ok := obj.Actions["download"]
+		if !ok || auth == "" {
+			continue
+		}
+		href, err := url.Parse(action.Href)
+		if err != nil || href.Host != u.Host {
+			continue
+		}
+		hasAuth := false
+		for k := range action.Header {
+			hasAuth = hasAuth || strings.EqualFold(k, "Authorization")
+		}
+		if !hasAuth {
+			header := map[string]string{"Authorization": auth}
+			for k, v := range action.Header {
+				header[k] = v
+			}
+			action.Header = header
+			obj.Actions["download"] = action
+		}
+	}
+	return res.Objects, nil
+}
+
+// lfsEndpoint finds the lfs server of the repository the way git lfs does:
+// lfs.url, then the lfsurl of the remote, from the config and then from
+// .lfsconfig, and last a url derived from the one of the remote.
+func lfsEndpoint(baseDir string, repo repository) string {
+	var cfgs []*ini.File
+	for _, file := range []string{repo.path(".git/config"), repo.path(".lfsconfig")} {
+		if cfg, err := ini.LoadSources(ini.LoadOptions{InsensitiveKeys: true}, utils.URL(baseDir, file)); err == nil {
+			cfgs = append(cfgs, cfg)
+		}
+	}
+	get := func(section, key string) string {
+		for _, cfg := range cfgs {
+			if v := strings.TrimSpace(cfg.Section(section).Key(key).String()); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+
+	remote := "origin"
+	if len(cfgs) > 0 && get(`remote "origin"`, "url") == "" {
+		for _, sec := range cfgs[0].Sections() {
+			if strings.HasPrefix(sec.Name(), "remote ") {
+				remote = strings.Trim(strings.SplitN(sec.Name(), " ", 2)[1], `"`)
+				break
+			}
+		}
+	}
+	section := fmt.Sprintf("remote %q", remote)
+	for _, endpoint := range []string{get("lfs", "url"), get(section, "lfsurl")} {
+		if strings.HasPrefix(endpoint, "http://") || strings.HasPrefix(endpoint, "https://") {
+			return endpoint
+		}
+	}
+	return lfsEndpointFromRemote(get(section, "url"))
+}
+
+// lfsEndpointFromRemote derives the url of the lfs server from the url of a
+// remote, which is <remote>.git/info/lfs over https for ssh remotes.
+func lfsEndpointFromRemote(remote string) string {
+	var endpoint string
+	switch {
+	case strings.HasPrefix(remote, "http://"), strings.HasPrefix(remote, "https://"):
+		endpoint = remote
+	case strings.HasPrefix(remote, "ssh://"), strings.HasPrefix(remote, "git+ssh://"), strings.HasPrefix(remote, "ssh+git://"):
+		u, err := url.Parse(remote)
+		if err != nil || u.Hostname() == "" {
+			return ""
+		}
+		endpoint = "https://" + u.Hostname() + u.Path
+	case !strings.Contains(remote, "://"):
+		// scp-like syntax, user@host:path
+		hostPart, p, ok := strings.Cut(remote, ":")
+		if !ok || hostPart == "" || strings.Contains(hostPart, "/") {
+			return ""
+		}
+		if i := strings.LastIndexByte(hostPart, '@'); i >= 0 {
+			hostPart = hostPart[i+1:]
+		}
+		endpoint = "https://" + hostPart + "/" + strings.TrimPrefix(p, "/")
+	default:
+		return ""
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(endpoint, ".git") {
+		endpoint += ".git"
+	}
+	return endpoint + "/info/lfs"
+}
@@ -0,0 +1,278 @@
+package goop
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/deletescape/goop/internal/utils"
+)
+
+func lfsPointer(content string) utils.LfsPointer {
+	sum := sha256.Sum256([]byte(content))
+	return utils.LfsPointer{Oid: hex.EncodeToString(sum[:]), Size: int64(len(content))}
+}
+
+// lfsServer stands in for an lfs server, answering the batch API with the
+// given objects and serving the content of downloads, or redirecting them as
+// set in redirects. It records the paths requested from it and the
+// authorization sent along.
+type lfsServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	requests  []string
+	auth      map[string]string
+	redirects map[string]string
+}
+
+func newLfsServer(t *testing.T, objects func(srv *lfsServer, p utils.LfsPointer) utils.LfsBatchObject, downloads map[string]string) *lfsServer {
+	t.Helper()
+	srv := &lfsServer{auth: make(map[string]string), redirects: make(map[string]string)}
+	srv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		srv.mu.Lock()
+		srv.requests = append(srv.requests, r.URL.Path)
+		srv.auth[r.URL.Path] = r.Header.Get("Authorization")
+		srv.mu.Unlock()
+
+		if r.URL.Path == "/info/lfs/objects/batch" && r.Method == http.MethodPost {
+			var req utils.LfsBatchRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Operation != "download" {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			var res utils.LfsBatchResponse
+			res.Transfer = "basic"
+			for _, p := range req.Objects {
+				res.Objects = append(res.Objects, objects(srv, p))
+			}
+			w.Header().Set("Content-Type", lfsMediaType)
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		if to, ok := srv.redirects[r.URL.Path]; ok {
+			http.Redirect(w, r, to, http.StatusFound)
+			return
+		}
+		content, ok := downloads[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "", time.Unix(0, 0), strings.NewReader(content))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (srv *lfsServer) requested(path string) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for _, p := range srv.requests {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// lfsDump sets up a dump whose config names endpoint as its lfs server.
+func lfsDump(t *testing.T, endpoint string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	config := "[lfs]\n\turl = " + endpoint + "\n"
+	if err := os.WriteFile(filepath.Join(dir, ".git/config"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestFetchLfsBatch(t *testing.T) {
+	good := lfsPointer("the actual content\n")
+	mismatch := lfsPointer("what we expect\n")
+	missing := lfsPointer("gone\n")
+	foreign := lfsPointer("elsewhere\n")
+
+	srv := newLfsServer(t, func(srv *lfsServer, p utils.LfsPointer) utils.LfsBatchObject {
+		obj := utils.LfsBatchObject{LfsPointer: p}
+		switch p.Oid {
+		case good.Oid:
+			obj.Actions = map[string]utils.LfsAction{"download": {Href: srv.URL + "/objects/good"}}
+		case mismatch.Oid:
+			obj.Actions = map[string]utils.LfsAction{"download": {Href: srv.URL + "/objects/mismatch"}}
+		case missing.Oid:
+			obj.Error = &utils.LfsObjectError{Code: 404, Message: "object does not exist"}
+		case foreign.Oid:
+			obj.Actions = map[string]utils.LfsAction{"download": {Href: "http://lfs.invalid/objects/foreign"}}
+		}
+		return obj
+	}, map[string]string{
+		"/objects/good":     "the actual content\n",
+		"/objects/mismatch": "something else\n",
+	})
+	u, _ := url.Parse(srv.URL)
+	u.User = url.UserPassword("user", "secret")
+	dir := lfsDump(t, u.String()+"/info/lfs")
+
+	fetchLfsBatch(dir, mainRepository, []utils.LfsPointer{good, mismatch, missing, foreign}, []string{u.Hostname()})
+
+	gitDir := filepath.Join(dir, ".git")
+	content, err := os.ReadFile(filepath.Join(gitDir, utils.LfsObjectPath(good.Oid)))
+	if err != nil || !good.Verify(content) {
+		t.Errorf("object from download action = %q, %v", content, err)
+	}
+	for name, p := range map[string]utils.LfsPointer{"mismatching": mismatch, "errored": missing, "foreign": foreign} {
+		if _, err := os.Stat(filepath.Join(gitDir, utils.LfsObjectPath(p.Oid))); err == nil {
+			t.Errorf("%s object was written", name)
+		}
+		if _, err := os.Stat(filepath.Join(gitDir, utils.LfsObjectPath(p.Oid)+".part")); err == nil {
+			t.Errorf("%s object was left as a partial download", name)
+		}
+	}
+
+	if !srv.requested("/info/lfs/objects/batch") {
+		t.Fatal("batch api wasn't used")
+	}
+	want := "Basic " + "dXNlcjpzZWNyZXQ="
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for _, path := range []string{"/info/lfs/objects/batch", "/objects/good"} {
+		if srv.auth[path] != want {
+			t.Errorf("%s got authorization %q, want %q", path, srv.auth[path], want)
+		}
+	}
+}
+
+func TestFetchLfsBatchOtherHost(t *testing.T) {
+	p := lfsPointer("content\n")
+	srv := newLfsServer(t, func(srv *lfsServer, p utils.LfsPointer) utils.LfsBatchObject {
+		return utils.LfsBatchObject{LfsPointer: p, Actions: map[string]utils.LfsAction{"download": {Href: srv.URL + "/objects/p"}}}
+	}, map[string]string{"/objects/p": "content\n"})
+	dir := lfsDump(t, srv.URL+"/info/lfs")
+
+	for _, tt := range []struct {
+		name  string
+		hosts []string
+		want  bool
+	}{
+		{"target elsewhere", []string{"example.com"}, false},
+		{"no hosts", nil, false},
+		{"allowed", []string{"example.com", "127.0.0.1"}, true},
+		{"any", []string{"*"}, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			os.RemoveAll(filepath.Join(dir, ".git/lfs"))
+			srv.mu.Lock()
+			srv.requests = nil
+			srv.mu.Unlock()
+
+			fetchLfsBatch(dir, mainRepository, []utils.LfsPointer{p}, tt.hosts)
+			if got := srv.requested("/info/lfs/objects/batch"); got != tt.want {
+				t.Errorf("lfs server contacted = %v, want %v", got, tt.want)
+			}
+			content, _ := os.ReadFile(filepath.Join(dir, ".git", utils.LfsObjectPath(p.Oid)))
+			if got := bytes.Equal(content, []byte("content\n")); got != tt.want {
+				t.Errorf("object fetched = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchLfsBatchRedirect(t *testing.T) {
+	p := lfsPointer("content\n")
+	// the same machine, under a name that isn't the one of the lfs server
+	other := newLfsServer(t, nil, map[string]string{"/objects/p": "content\n"})
+	otherURL := strings.Replace(other.URL, "127.0.0.1", "localhost", 1)
+
+	var href string
+	srv := newLfsServer(t, func(srv *lfsServer, p utils.LfsPointer) utils.LfsBatchObject {
+		return utils.LfsBatchObject{LfsPointer: p, Actions: map[string]utils.LfsAction{"download": {Href: srv.URL + href}}}
+	}, map[string]string{"/objects/p": "content\n"})
+	srv.redirects["/objects/same"] = "/objects/p"
+	srv.redirects["/objects/away"] = otherURL + "/objects/p"
+	srv.redirects["/objects/loop"] = "/objects/loop"
+	u, _ := url.Parse(srv.URL)
+	u.User = url.UserPassword("user", "secret")
+	dir := lfsDump(t, u.String()+"/info/lfs")
+	auth := "Basic " + "dXNlcjpzZWNyZXQ="
+
+	for _, tt := range []struct {
+		name      string
+		href      string
+		hosts     []string
+		want      bool
+		wantOther bool
+	}{
+		{"same host", "/objects/same", []string{"127.0.0.1"}, true, false},
+		{"off host", "/objects/away", []string{"127.0.0.1"}, false, false},
+		{"allowed host", "/objects/away", []string{"127.0.0.1", "localhost"}, true, true},
+		{"loop", "/objects/loop", []string{"*"}, false, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			os.RemoveAll(filepath.Join(dir, ".git/lfs"))
+			for _, s := range []*lfsServer{srv, other} {
+				s.mu.Lock()
+				s.requests = nil
+				s.auth = make(map[string]string)
+				s.mu.Unlock()
+			}
+			href = tt.href
+
+			fetchLfsBatch(dir, mainRepository, []utils.LfsPointer{p}, tt.hosts)
+			content, _ := os.ReadFile(filepath.Join(dir, ".git", utils.LfsObjectPath(p.Oid)))
+			if got := bytes.Equal(content, []byte("content\n")); got != tt.want {
+				t.Errorf("object fetched = %v, want %v", got, tt.want)
+			}
+			if got := other.requested("/objects/p"); got != tt.wantOther {
+				t.Errorf("other host contacted = %v, want %v", got, tt.wantOther)
+			}
+			srv.mu.Lock()
+			if tt.name == "same host" && srv.auth["/objects/p"] != auth {
+				t.Errorf("same host redirect got authorization %q, want %q", srv.auth["/objects/p"], auth)
+			}
+			srv.mu.Unlock()
+			other.mu.Lock()
+			if a := other.auth["/objects/p"]; a != "" {
+				t.Errorf("other host got authorization %q", a)
+			}
+			other.mu.Unlock()
+		})
+	}
+}
+
+func TestLfsEndpoint(t *testing.T) {
+	tests := []struct {
+		config, lfsconfig, want string
+	}{
+		{"[lfs]\n\turl = https://lfs.example.com/repo\n", "", "https://lfs.example.com/repo"},
+		{"[remote \"origin\"]\n\turl = https://example.com/repo\n", "", "https://example.com/repo.git/info/lfs"},
+		{"[remote \"origin\"]\n\turl = git@example.com:group/repo.git\n", "", "https://example.com/group/repo.git/info/lfs"},
+		{"[remote \"upstream\"]\n\turl = ssh://git@example.com/repo\n", "", "https://example.com/repo.git/info/lfs"},
+		{"[remote \"origin\"]\n\turl = https://example.com/repo.git\n", "[lfs]\n\turl = https://lfs.example.com\n", "https://lfs.example.com"},
+		{"[lfs]\n\turl = file:///etc\n", "", ""},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		os.MkdirAll(filepath.Join(dir, ".git"), 0755)
+		os.WriteFile(filepath.Join(dir, ".git/config"), []byte(tt.config), 0644)
+		if tt.lfsconfig != "" {
+			os.WriteFile(filepath.Join(dir, ".lfsconfig"), []byte(tt.lfsconfig), 0644)
+		}
+		if got := lfsEndpoint(dir, mainRepository); got != tt.want {
+			t.Errorf("lfsEndpoint(%q, %q) = %q, want %q", tt.config, tt.lfsconfig, got, tt.want)
+		}
+	}
+}
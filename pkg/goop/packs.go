@@ -0,0 +1,105 @@
+package goop
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/deletescape/goop/internal/workers"
+	"github.com/phuslu/log"
+)
+
+// parsePacks enumerates the objects in every downloaded pack file. Packed
+// objects are recorded in packed, and the objects they reference are added to
+// objs so the history only the packs know about is walked as well.
+func parsePacks(gitDir string, format utils.ObjectFormat, history *utils.History, objs, packed map[string]bool) {
+	packFiles, err := filepath.Glob(utils.URL(gitDir, "objects/pack/pack-*.pack"))
+	if err != nil {
+		log.Error().Str("dir", gitDir).Err(err).Msg("failed to list pack files")
+		return
+	}
+	for _, packFile := range packFiles {
+		idxFile := strings.TrimSuffix(packFile, ".pack") + ".idx"
+		if !utils.Exists(idxFile) {
+			log.Warn().Str("dir", gitDir).Str("pack", packFile).Msg("pack file has no index, skipping")
+			continue
+		}
+		if err := checkPackIndex(packFile, idxFile, format); err != nil {
+			log.Error().Str("dir", gitDir).Str("pack", packFile).Err(err).Msg("pack file doesn't match its index, quarantining both")
+			for _, file := range []string{packFile, idxFile} {
+				workers.QuarantineFile(gitDir, file, err.Error())
+			}
+			continue
+		}
+		pack, err := utils.OpenPack(packFile, idxFile, format)
+		if err != nil {
+			log.Error().Str("dir", gitDir).Str("pack", packFile).Err(err).Msg("failed to open pack file")
+			continue
+		}
+		log.Info().Str("dir", gitDir).Str("pack", packFile).Int("objects", len(pack.Index.Hashes)).Msg("parsing pack file")
+		for _, hash := range pack.Index.Hashes {
+			packed[hash] = true
+		}
+		for _, hash := range pack.Index.Hashes {
+			typ, err := pack.ObjectType(hash)
+			if err != nil {
+				log.Error().Str("dir", gitDir).Str("pack", packFile).Str("obj", hash).Err(err).Msg("couldn't read packed object")
+				continue
+			}
+			if typ == "blob" {
+				continue
+			}
+			typ, content, err := pack.Object(hash)
+			if err != nil {
+				log.Error().Str("dir", gitDir).Str("pack", packFile).Str("obj", hash).Err(err).Msg("couldn't read packed object")
+				continue
+			}
+			for _, ref := range history.ReferencedHashes(hash, typ, content, format) {
+				objs[ref] = true
+			}
+		}
+		pack.Close()
+	}
+}
+
+// checkPackIndex makes sure idxFile is the index of packFile, by comparing the
+// checksum of the pack the index ends with to the one the pack ends with.
+func checkPackIndex(packFile, idxFile string, format utils.ObjectFormat) error {
+	packSum, err := utils.ReadChecksumTrailer(packFile, format, false)
+	if err != nil {
+		return err
+	}
+	idxSum, err := utils.ReadChecksumTrailer(idxFile, format, true)
+	if err != nil {
+		return err
+	}
+	if packSum != idxSum {
+		return fmt.Errorf("index is for pack %s, but pack is %s", idxSum, packSum)
+	}
+	return nil
+}
+
+// parseMultiPackIndex adds the objects listed in a multi-pack-index file to
+// objs, and the hashes of the packs it covers to packs.
+func parseMultiPackIndex(baseDir, midxFile string, format utils.ObjectFormat, objs, packs map[string]bool) {
+	if !utils.Exists(midxFile) {
+		return
+	}
+	midx, err := utils.ReadMultiPackIndex(midxFile, format)
+	if err != nil {
+		log.Error().Str("dir", baseDir).Str("midx", midxFile).Err(err).Msg("failed to decode multi-pack-index")
+		if midx == nil {
+			return
+		}
+	}
+	for _, hash := range midx.Hashes {
+		objs[hash] = true
+	}
+	for _, name := range midx.PackNames {
+		hash := strings.TrimSuffix(strings.TrimPrefix(name, "pack-"), ".idx")
+		if format.IsHash(hash) {
+			packs[hash] = true
+		}
+	}
+}
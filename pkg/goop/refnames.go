@@ -0,0 +1,149 @@
+package goop
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/deletescape/goop/internal/workers"
+	"github.com/deletescape/jobtracker"
+	"github.com/phuslu/log"
+	"gopkg.in/ini.v1"
+)
+
+// RefNameOptions configures the branch and tag names probed for.
+type RefNameOptions struct {
+	// Wordlist is a file with additional names, one per line
+	Wordlist string
+	// Builtin enables the built-in candidates
+	Builtin bool
+	// MaxVersion is the highest version, as major.minor.patch, the built-in
+	// candidates count up to in each component, or "" for no versions
+	MaxVersion string
+}
+
+// DefaultRefNameOptions doesn't probe for the built-in candidates, which are
+// several hundred names, each tried in every ref namespace of every dumped
+// repository. When they are enabled, versions go up to 2.9.2.
+var DefaultRefNameOptions = RefNameOptions{MaxVersion: "2.9.2"}
+
+// refNames returns the branch and tag names to probe for: the built-in
+// candidates, if enabled, followed by the names in the wordlist file, if any.
+func refNames(opts RefNameOptions) ([]string, error) {
+	var names []string
+	if opts.Builtin {
+		var err error
+		if names, err = generateRefNames(opts.MaxVersion); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Wordlist == "" {
+		return names, nil
+	}
+	f, err := os.Open(opts.Wordlist)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name := strings.TrimSpace(scanner.Text())
+		if name == "" || strings.HasPrefix(name, "#") {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, scanner.Err()
+}
+
+// generateRefNames builds the built-in candidates: common branch names,
+// feature/ branches and version numbers up to maxVersion, as tags and
+// release/ and hotfix/ branches.
+func generateRefNames(maxVersion string) ([]string, error) {
+	var names []string
+	names = append(names, commonRefNames...)
+	for _, feature := range commonFeatureNames {
+		names = append(names, "feature/"+feature, "features/"+feature)
+	}
+	if maxVersion == "" {
+		return names, nil
+	}
+	var maxMajor, maxMinor, maxPatch int
+	if n, err := fmt.Sscanf(maxVersion, "%d.%d.%d", &maxMajor, &maxMinor, &maxPatch); err != nil || n != 3 || maxMajor < 0 || maxMinor < 0 || maxPatch < 0 {
+		return nil, fmt.Errorf("invalid version %q, expected major.minor.patch", maxVersion)
+	}
+	var versions []string
+	for major := 0; major <= maxMajor; major++ {
+		for minor := 0; minor <= maxMinor; minor++ {
+			versions = append(versions, fmt.Sprintf("%d.%d", major, minor))
+			for patch := 0; patch <= maxPatch; patch++ {
+				versions = append(versions, fmt.Sprintf("%d.%d.%d", major, minor, patch))
+			}
+		}
+	}
+	for _, version := range versions {
+		names = append(names, version, "v"+version, "release/"+version, "hotfix/"+version)
+	}
+	return names, nil
+}
+
+// findRefNames probes for every candidate name as a branch, a tag and a
+// remote-tracking branch of every remote in the config, along with their
+// reflogs.
+func findRefNames(baseURL, baseDir string, repo repository, names []string) {
+	remotes := []string{"origin"}
+	if cfg, err := ini.Load(utils.URL(baseDir, repo.path(".git/config"))); err == nil {
+		for _, sec := range cfg.Sections() {
+			if strings.HasPrefix(sec.Name(), "remote ") {
+				parts := strings.SplitN(sec.Name(), " ", 2)
+				if remote := strings.Trim(parts[1], `"`); remote != "origin" && isSafeRefName(remote) {
+					remotes = append(remotes, remote)
+				}
+			}
+		}
+	}
+
+	namespaces := []string{"refs/heads", "refs/tags"}
+	for _, remote := range remotes {
+		namespaces = append(namespaces, "refs/remotes/"+remote)
+	}
+
+	jt := jobtracker.NewJobTracker(workers.FindRefWorker, maxConcurrency, jobtracker.DefaultNapper)
+	for _, name := range names {
+		if !isSafeRefName(name) {
+			log.Warn().Str("ref", name).Msg("skipping invalid ref name")
+			continue
+		}
+		for _, ns := range namespaces {
+			jt.AddJobs(
+				repo.path(fmt.Sprintf(".git/%s/%s", ns, name)),
+				repo.path(fmt.Sprintf(".git/logs/%s/%s", ns, name)),
+			)
+		}
+	}
+	jt.StartAndWait(workers.FindRefContext{C: c, BaseURL: baseURL, BaseDir: baseDir, GitDir: repo.gitDir}, true)
+}
+
+// isSafeRefName roughly follows git check-ref-format, mostly to keep names from
+// escaping the refs directory.
+func isSafeRefName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") || strings.HasSuffix(name, ".lock") {
+		return false
+	}
+	if strings.ContainsAny(name, " ~^:?*[\\\x7f") || strings.Contains(name, "..") || strings.Contains(name, "//") {
+		return false
+	}
+	for _, r := range name {
+		if r < 0x20 {
+			return false
+		}
+	}
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+package goop
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRefNames(t *testing.T) {
+	wordlist := filepath.Join(t.TempDir(), "words")
+	os.WriteFile(wordlist, []byte("# comment\nmy-branch\n\n"), 0644)
+	common := len(commonRefNames) + 2*len(commonFeatureNames)
+
+	tests := []struct {
+		name    string
+		opts    RefNameOptions
+		want    int
+		wantErr bool
+	}{
+		{"default", DefaultRefNameOptions, 0, false},
+		{"builtin", RefNameOptions{Builtin: true, MaxVersion: DefaultRefNameOptions.MaxVersion}, 545, false},
+		{"none", RefNameOptions{}, 0, false},
+		{"only the wordlist", RefNameOptions{Wordlist: wordlist}, 1, false},
+		{"no versions", RefNameOptions{Builtin: true}, common, false},
+		{"versions up to 1.0.0", RefNameOptions{Builtin: true, MaxVersion: "1.0.0"}, common + 4*4, false},
+		{"with the wordlist", RefNameOptions{Builtin: true, Wordlist: wordlist}, common + 1, false},
+		{"invalid version", RefNameOptions{Builtin: true, MaxVersion: "1.x"}, 0, true},
+		{"negative version", RefNameOptions{Builtin: true, MaxVersion: "1.-1.0"}, 0, true},
+		{"missing wordlist", RefNameOptions{Wordlist: wordlist + ".missing"}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names, err := refNames(tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("refNames() error = %v", err)
+			}
+			if len(names) != tt.want {
+				t.Fatalf("refNames() returned %d names, want %d", len(names), tt.want)
+			}
+		})
+	}
+}
+
+func TestIsSafeRefName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"refs/heads/master":                true,
+		"feature/login":                    true,
+		"v1.2.3":                           true,
+		"":                                 false,
+		"refs/../config":                   false,
+		"refs/x/../../hooks/post-checkout": false,
+		"/etc/passwd":                      false,
+		"refs/heads/.hidden":               false,
+		"refs/heads/x.lock":                false,
+		"refs/heads/a b":                   false,
+		"refs/heads/a\\b":                  false,
+		"refs//heads":                      false,
+	} {
+		if got := isSafeRefName(name); got != want {
+			t.Errorf("isSafeRefName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
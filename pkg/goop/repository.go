@@ -0,0 +1,76 @@
+package goop
+
+import (
+	"os"
+	"strings"
+
+	"github.com/deletescape/goop/internal/utils"
+)
+
+// repository describes where the git directory and the working tree of a
+// dumped repository live, relative to the base URL and directory of the dump.
+// Submodules keep their git directory in .git/modules/<name> of their
+// superproject, and their working tree at their path in the superproject.
+type repository struct {
+	gitDir   string
+	workTree string
+	// depth is how many submodules deep the repository is nested
+	depth int
+	// commonDir is the git directory holding the objects and branches of a
+	// linked worktree, whose own git directory only has its HEAD and index
+	commonDir string
+}
+
+var mainRepository = repository{gitDir: ".git"}
+
+// path maps p, given relative to the root of a main repository (".git/HEAD",
+// ".gitignore"), to the location of the same file in r.
+func (r repository) path(p string) string {
+	if p == ".git" || strings.HasPrefix(p, ".git/") {
+		return r.gitDir + strings.TrimPrefix(p, ".git")
+	}
+	if r.workTree == "" {
+		return p
+	}
+	return utils.URL(r.workTree, p)
+}
+
+// objectDir returns the git directory the objects of r are stored in.
+func (r repository) objectDir() string {
+	if r.commonDir != "" {
+		return r.commonDir
+	}
+	return r.gitDir
+}
+
+// resolveHead resolves the HEAD of r, following it into the common directory
+// for the branch a linked worktree has checked out.
+func (r repository) resolveHead(baseDir string, format utils.ObjectFormat) (string, error) {
+	gitDir := utils.URL(baseDir, r.gitDir)
+	head, err := utils.ResolveRef(gitDir, "HEAD", format)
+	if err == nil || r.commonDir == "" {
+		return head, err
+	}
+	content, readErr := os.ReadFile(utils.URL(gitDir, "HEAD"))
+	if readErr != nil {
+		return "", err
+	}
+	target, ok := strings.CutPrefix(strings.TrimSpace(string(content)), "ref:")
+	if !ok {
+		return "", err
+	}
+	return utils.ResolveRef(utils.URL(baseDir, r.commonDir), strings.TrimSpace(target), format)
+}
+
+// paths maps every path in ps using path.
+func (r repository) paths(ps []string) []string {
+	mapped := make([]string, len(ps))
+	for i, p := range ps {
+		mapped[i] = r.path(p)
+	}
+	return mapped
+}
+
+func (r repository) isMain() bool {
+	return r == mainRepository
+}
@@ -0,0 +1,169 @@
+package goop
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/deletescape/goop/internal/workers"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/filesystem/dotgit"
+	"github.com/phuslu/log"
+	"github.com/valyala/fasthttp"
+)
+
+const uploadPackService = "git-upload-pack"
+
+// fetchSmart tries to negotiate a full packfile over the smart HTTP protocol,
+// which works even if the dumb file layout is locked down. It returns whether
+// the server spoke the protocol and a pack was fetched.
+func fetchSmart(baseURL, baseDir string) (bool, error) {
+	for _, repoURL := range []string{utils.URL(baseURL, ".git"), baseURL} {
+		ar, err := smartAdvertisedRefs(repoURL)
+		if err != nil {
+			log.Info().Str("base", repoURL).Err(err).Msg("smart http protocol not available")
+			continue
+		}
+		log.Info().Str("base", repoURL).Int("refs", len(ar.References)).Msg("server speaks the smart http protocol, fetching pack")
+		if err := smartFetchPack(repoURL, baseDir, ar); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func smartAdvertisedRefs(repoURL string) (*packp.AdvRefs, error) {
+	uri := utils.URL(repoURL, fmt.Sprintf("info/refs?service=%s", uploadPackService))
+	code, body, err := workers.Get(c, uri)
+	if err != nil {
+		return nil, err
+	}
+	if code != 200 {
+		return nil, fmt.Errorf("unexpected status code %d", code)
+	}
+	if !bytes.Contains(body, []byte("# service="+uploadPackService)) {
+		return nil, fmt.Errorf("not a smart http advertisement")
+	}
+	ar := packp.NewAdvRefs()
+	if err := ar.Decode(bytes.NewReader(body)); err != nil {
+		return nil, err
+	}
+	if len(ar.References) == 0 && ar.Head == nil {
+		return nil, fmt.Errorf("no refs advertised")
+	}
+	return ar, nil
+}
+
+func smartFetchPack(repoURL, baseDir string, ar *packp.AdvRefs) error {
+	upreq := packp.NewUploadPackRequestFromCapabilities(ar.Capabilities)
+	// we have no objects to resolve thin pack deltas against
+	upreq.Capabilities.Delete(capability.ThinPack)
+
+	wanted := make(map[plumbing.Hash]bool)
+	if ar.Head != nil {
+		wanted[*ar.Head] = true
+	}
+	for _, hash := range ar.References {
+		wanted[hash] = true
+	}
+	for hash := range wanted {
+		upreq.Wants = append(upreq.Wants, hash)
+	}
+
+	var buf bytes.Buffer
+	if err := upreq.UploadRequest.Encode(&buf); err != nil {
+		return err
+	}
+	if err := upreq.UploadHaves.Encode(&buf, false); err != nil {
+		return err
+	}
+	if err := pktline.NewEncoder(&buf).EncodeString("done\n"); err != nil {
+		return err
+	}
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	uri := utils.URL(repoURL, uploadPackService)
+	req.SetRequestURI(uri)
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.Header.SetContentType(fmt.Sprintf("application/x-%s-request", uploadPackService))
+	req.Header.Set("Accept", fmt.Sprintf("application/x-%s-result", uploadPackService))
+	req.SetBody(buf.Bytes())
+	// the pack can be as large as the repository, so it's read as it arrives
+	if err := workers.StreamClient(c).Do(req, resp); err != nil {
+		return err
+	}
+	defer resp.CloseBodyStream()
+	if resp.StatusCode() != 200 {
+		return fmt.Errorf("%s returned status code %d", uri, resp.StatusCode())
+	}
+
+	res := packp.NewUploadPackResponse(upreq)
+	if err := res.Decode(io.NopCloser(resp.BodyStream())); err != nil {
+		return err
+	}
+
+	dg := dotgit.New(osfs.New(utils.URL(baseDir, ".git")))
+	if err := dg.Initialize(); err != nil {
+		return err
+	}
+	objStorage := filesystem.NewObjectStorage(dg, &cache.ObjectLRU{MaxSize: 256})
+
+	var pack io.Reader = res
+	if upreq.Capabilities.Supports(capability.Sideband64k) {
+		pack = sideband.NewDemuxer(sideband.Sideband64k, res)
+	} else if upreq.Capabilities.Supports(capability.Sideband) {
+		pack = sideband.NewDemuxer(sideband.Sideband, res)
+	}
+	if err := packfile.WritePackfileToObjectStorage(objStorage, pack); err != nil {
+		return err
+	}
+
+	for name, hash := range ar.References {
+		if strings.HasSuffix(name, "^{}") || !strings.HasPrefix(name, "refs/") {
+			continue
+		}
+		if !isSafeRefName(name) {
+			log.Warn().Str("dir", baseDir).Str("ref", name).Msg("refusing to write ref with unsafe name")
+			continue
+		}
+		if err := utils.WriteConfinedFile(baseDir, ".git/"+name, []byte(hash.String()+"\n"), 0644, true); err != nil {
+			log.Error().Str("dir", baseDir).Str("ref", name).Err(err).Msg("couldn't write ref")
+		}
+	}
+
+	head := smartHead(ar)
+	if head == "" {
+		return nil
+	}
+	return utils.WriteConfinedFile(baseDir, ".git/HEAD", []byte(head+"\n"), 0644, true)
+}
+
+// smartHead builds the content of HEAD from the symref capability, falling
+// back to a detached HEAD if the server didn't advertise where it points.
+func smartHead(ar *packp.AdvRefs) string {
+	for _, symref := range ar.Capabilities.Get(capability.SymRef) {
+		parts := strings.SplitN(symref, ":", 2)
+		if len(parts) == 2 && parts[0] == plumbing.HEAD.String() && strings.HasPrefix(parts[1], "refs/") && isSafeRefName(parts[1]) {
+			return "ref: " + parts[1]
+		}
+	}
+	if ar.Head != nil {
+		return ar.Head.String()
+	}
+	return ""
+}
@@ -0,0 +1,142 @@
+package goop
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// smartServer stands in for git http-backend, advertising refs and answering
+// every upload-pack request with the whole repository.
+func smartServer(t *testing.T, refs map[string]plumbing.Hash) (*httptest.Server, plumbing.Hash) {
+	t.Helper()
+	storer := memory.NewStorage()
+	fs := memfs.New()
+	repo, err := git.Init(storer, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, _ := fs.Create("README")
+	f.Write([]byte("hello\n"))
+	f.Close()
+	wt, _ := repo.Worktree()
+	wt.Add("README")
+	head, err := wt.Commit("init", &git.CommitOptions{Author: &object.Signature{Name: "a", Email: "a@b", When: time.Unix(0, 0)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hashes []plumbing.Hash
+	iter, _ := storer.IterEncodedObjects(plumbing.AnyObject)
+	iter.ForEach(func(o plumbing.EncodedObject) error {
+		hashes = append(hashes, o.Hash())
+		return nil
+	})
+	var pack bytes.Buffer
+	if _, err := packfile.NewEncoder(&pack, storer, false).Encode(hashes, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	ar := packp.NewAdvRefs()
+	ar.Head = &head
+	ar.Capabilities.Add(capability.OFSDelta)
+	ar.Capabilities.Add(capability.SymRef, "HEAD:refs/heads/master")
+	ar.References["refs/heads/master"] = head
+	for name, hash := range refs {
+		if hash.IsZero() {
+			hash = head
+		}
+		ar.References[name] = hash
+	}
+	var adv bytes.Buffer
+	pktline.NewEncoder(&adv).EncodeString("# service=git-upload-pack\n")
+	pktline.NewEncoder(&adv).Flush()
+	if err := ar.Encode(&adv); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.git/info/refs", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+		w.Write(adv.Bytes())
+	})
+	mux.HandleFunc("/.git/git-upload-pack", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+		pktline.NewEncoder(w).EncodeString("NAK\n")
+		w.Write(pack.Bytes())
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, head
+}
+
+func TestFetchSmart(t *testing.T) {
+	srv, head := smartServer(t, map[string]plumbing.Hash{
+		"refs/tags/v1":                     {},
+		"refs/../config":                   {},
+		"refs/x/../../hooks/post-checkout": {},
+	})
+	dir := t.TempDir()
+
+	ok, err := fetchSmart(srv.URL, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("smart http protocol wasn't detected")
+	}
+
+	for file, want := range map[string]string{
+		"HEAD":              "ref: refs/heads/master\n",
+		"refs/heads/master": head.String() + "\n",
+		"refs/tags/v1":      head.String() + "\n",
+	} {
+		got, err := os.ReadFile(filepath.Join(dir, ".git", file))
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", file, got, want)
+		}
+	}
+	for _, file := range []string{"config", "hooks/post-checkout"} {
+		if _, err := os.Stat(filepath.Join(dir, ".git", file)); err == nil {
+			t.Errorf("ref with unsafe name was written to %s", file)
+		}
+	}
+
+	packs, _ := filepath.Glob(filepath.Join(dir, ".git/objects/pack/pack-*.pack"))
+	idxs, _ := filepath.Glob(filepath.Join(dir, ".git/objects/pack/pack-*.idx"))
+	if len(packs) != 1 || len(idxs) != 1 {
+		t.Fatalf("got packs %v and indexes %v, want one of each", packs, idxs)
+	}
+}
+
+func TestFetchSmartNotAvailable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	ok, err := fetchSmart(srv.URL, t.TempDir())
+	if err != nil || ok {
+		t.Fatalf("fetchSmart = %v, %v, want false, nil", ok, err)
+	}
+}
@@ -0,0 +1,168 @@
+package goop
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/deletescape/goop/internal/workers"
+	"github.com/deletescape/jobtracker"
+	"github.com/phuslu/log"
+)
+
+// fetchState downloads the files git leaves behind while a merge, rebase,
+// cherry-pick, revert, am or bisect is in progress, and probes for the
+// branches they mention. The paths of the downloaded files are returned so
+// they can be searched for object names.
+func fetchState(baseURL, baseDir string, repo repository) []string {
+	gitDir := utils.URL(baseDir, repo.gitDir)
+	ctx := workers.FindRefContext{C: c, BaseURL: baseURL, BaseDir: baseDir, GitDir: repo.gitDir}
+
+	jt := jobtracker.NewJobTracker(workers.FindRefWorker, maxConcurrency, jobtracker.DefaultNapper)
+	for _, file := range stateFiles {
+		jt.AddJob(repo.path(".git/" + file))
+	}
+	jt.StartAndWait(ctx, true)
+
+	// am and rebase --apply keep every patch in a numbered file, fetch them a
+	// batch at a time and stop at the first one that's missing
+	var patches []string
+	if content, err := os.ReadFile(utils.URL(gitDir, "rebase-apply/last")); err == nil {
+		last, _ := strconv.Atoi(strings.TrimSpace(string(content)))
+		last = min(last, maxStatePatches)
+		for first := 1; first <= last; first += maxConcurrency {
+			var batch []string
+			for i := first; i <= last && i < first+maxConcurrency; i++ {
+				batch = append(batch, fmt.Sprintf("rebase-apply/%04d", i))
+			}
+			jt = jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+			for _, patch := range batch {
+				jt.AddJob(repo.path(".git/" + patch))
+			}
+			jt.StartAndWait(workers.DownloadContext{C: c, BaseURL: baseURL, BaseDir: baseDir}, false)
+
+			complete := true
+			for _, patch := range batch {
+				if !utils.Exists(utils.URL(gitDir, patch)) {
+					complete = false
+					break
+				}
+				patches = append(patches, patch)
+			}
+			if !complete {
+				break
+			}
+		}
+	}
+
+	// BISECT_START holds the branch that was checked out, without refs/heads/
+	if content, err := os.ReadFile(utils.URL(gitDir, "BISECT_START")); err == nil {
+		if branch := strings.TrimSpace(string(content)); branch != "" && !objRegex.MatchString(branch) && isSafeRefName(branch) {
+			jt = jobtracker.NewJobTracker(workers.FindRefWorker, maxConcurrency, jobtracker.DefaultNapper)
+			jt.AddJobs(
+				repo.path(".git/refs/heads/"+branch),
+				repo.path(".git/logs/refs/heads/"+branch),
+			)
+			jt.StartAndWait(ctx, true)
+		}
+	}
+
+	var files []string
+	for _, file := range stateFiles {
+		if path := utils.URL(gitDir, file); utils.Exists(path) {
+			files = append(files, path)
+		}
+	}
+	for _, patch := range patches {
+		files = append(files, utils.URL(gitDir, patch))
+	}
+	return files
+}
+
+// reportState logs which operation was in progress on the server, with its
+// pending todo list and commit messages.
+func reportState(baseURL, baseDir string, repo repository) {
+	gitDir := utils.URL(baseDir, repo.gitDir)
+	read := func(file string) string {
+		content, _ := os.ReadFile(utils.URL(gitDir, file))
+		return strings.TrimSpace(string(content))
+	}
+	todo := func(file string) []string {
+		var lines []string
+		for _, line := range strings.Split(read(file), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				lines = append(lines, line)
+			}
+		}
+		return lines
+	}
+
+	var operations []string
+	switch {
+	case utils.Exists(utils.URL(gitDir, "rebase-merge")):
+		operations = append(operations, "rebase")
+	case utils.Exists(utils.URL(gitDir, "rebase-apply/applying")):
+		operations = append(operations, "am")
+	case utils.Exists(utils.URL(gitDir, "rebase-apply")):
+		operations = append(operations, "rebase")
+	}
+	if read("MERGE_HEAD") != "" {
+		operations = append(operations, "merge")
+	}
+	if read("CHERRY_PICK_HEAD") != "" {
+		operations = append(operations, "cherry-pick")
+	}
+	if read("REVERT_HEAD") != "" {
+		operations = append(operations, "revert")
+	}
+	if len(todo("sequencer/todo")) > 0 && read("CHERRY_PICK_HEAD") == "" && read("REVERT_HEAD") == "" {
+		operations = append(operations, "sequencer")
+	}
+	if read("BISECT_START") != "" || read("BISECT_LOG") != "" {
+		operations = append(operations, "bisect")
+	}
+	if len(operations) == 0 {
+		return
+	}
+
+	for _, op := range operations {
+		entry := log.Info().Str("base", baseURL).Str("dir", gitDir).Str("operation", op)
+		switch op {
+		case "rebase", "am":
+			stateDir := "rebase-merge"
+			if !utils.Exists(utils.URL(gitDir, stateDir)) {
+				stateDir = "rebase-apply"
+			}
+			entry = entry.Str("head-name", read(stateDir+"/head-name")).
+				Str("onto", read(stateDir+"/onto")).
+				Str("orig-head", read(stateDir+"/orig-head")).
+				Strs("done", todo(stateDir+"/done")).
+				Strs("todo", todo(stateDir+"/git-rebase-todo"))
+			if stateDir == "rebase-apply" {
+				entry = entry.Str("next", read("rebase-apply/next")).Str("last", read("rebase-apply/last"))
+			}
+		case "merge":
+			entry = entry.Strs("heads", strings.Fields(read("MERGE_HEAD"))).Str("mode", read("MERGE_MODE"))
+		case "cherry-pick":
+			entry = entry.Str("commit", read("CHERRY_PICK_HEAD")).Strs("todo", todo("sequencer/todo"))
+		case "revert":
+			entry = entry.Str("commit", read("REVERT_HEAD")).Strs("todo", todo("sequencer/todo"))
+		case "sequencer":
+			entry = entry.Str("head", read("sequencer/head")).Strs("todo", todo("sequencer/todo"))
+		case "bisect":
+			entry = entry.Str("start", read("BISECT_START")).Strs("log", todo("BISECT_LOG"))
+		}
+		if msg := read("MERGE_MSG"); msg != "" {
+			entry = entry.Str("merge-msg", msg)
+		}
+		if msg := read("SQUASH_MSG"); msg != "" {
+			entry = entry.Str("squash-msg", msg)
+		}
+		if tree := read("AUTO_MERGE"); tree != "" {
+			entry = entry.Str("auto-merge", tree)
+		}
+		entry.Msg("operation was in progress on the server")
+	}
+}
@@ -0,0 +1,205 @@
+package goop
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/phuslu/log"
+)
+
+// fileServer serves the given files and records the paths requested from it.
+type fileServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []string
+}
+
+func newFileServer(t *testing.T, files map[string]string) *fileServer {
+	t.Helper()
+	srv := &fileServer{}
+	srv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		srv.mu.Lock()
+		srv.requests = append(srv.requests, r.URL.Path)
+		srv.mu.Unlock()
+		content, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(content))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (srv *fileServer) requestedPrefix(prefix string) []string {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	var paths []string
+	for _, p := range srv.requests {
+		if strings.HasPrefix(p, prefix) {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestFetchState(t *testing.T) {
+	head := strings.Repeat("a", 40)
+	files := map[string]string{
+		"/.git/MERGE_HEAD":        head + "\n",
+		"/.git/MERGE_MSG":         "Merge branch 'topic'\n",
+		"/.git/BISECT_START":      "topic\n",
+		"/.git/refs/heads/topic":  head + "\n",
+		"/.git/rebase-apply/last": "99999\n",
+	}
+	for i := 1; i <= 3; i++ {
+		files[fmt.Sprintf("/.git/rebase-apply/%04d", i)] = fmt.Sprintf("patch %d\n", i)
+	}
+	srv := newFileServer(t, files)
+	dir := t.TempDir()
+
+	got := fetchState(srv.URL, dir, mainRepository)
+	for i := range got {
+		got[i], _ = filepath.Rel(dir, got[i])
+	}
+	sort.Strings(got)
+	want := []string{
+		".git/BISECT_START",
+		".git/MERGE_HEAD",
+		".git/MERGE_MSG",
+		".git/rebase-apply/0001",
+		".git/rebase-apply/0002",
+		".git/rebase-apply/0003",
+		".git/rebase-apply/last",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("fetchState = %v, want %v", got, want)
+	}
+
+	// patches stop being fetched after the first batch with a missing one
+	if n := len(srv.requestedPrefix("/.git/rebase-apply/0")); n > maxConcurrency {
+		t.Errorf("requested %d patches, want at most %d", n, maxConcurrency)
+	}
+	if content, err := os.ReadFile(filepath.Join(dir, ".git/refs/heads/topic")); err != nil || string(content) != head+"\n" {
+		t.Errorf("branch from BISECT_START = %q, %v", content, err)
+	}
+}
+
+func TestFetchStateUnsafeBisectBranch(t *testing.T) {
+	srv := newFileServer(t, map[string]string{
+		"/.git/BISECT_START": "../../config\n",
+	})
+	dir := t.TempDir()
+
+	fetchState(srv.URL, dir, mainRepository)
+	if got := srv.requestedPrefix("/.git/refs/"); len(got) != 0 {
+		t.Errorf("requested %v for an unsafe branch name", got)
+	}
+}
+
+func TestReportState(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []map[string]any
+	}{
+		{"none", map[string]string{"HEAD": "ref: refs/heads/master\n"}, nil},
+		{"merge", map[string]string{
+			"MERGE_HEAD": "aaaa\nbbbb\n",
+			"MERGE_MODE": "no-ff",
+			"MERGE_MSG":  "Merge branches\n",
+		}, []map[string]any{
+			{"operation": "merge", "heads": []any{"aaaa", "bbbb"}, "mode": "no-ff", "merge-msg": "Merge branches"},
+		}},
+		{"rebase", map[string]string{
+			"rebase-merge/head-name":       "refs/heads/topic",
+			"rebase-merge/onto":            "cccc",
+			"rebase-merge/done":            "pick 1111 first\n",
+			"rebase-merge/git-rebase-todo": "# comment\npick 2222 second\n\n",
+		}, []map[string]any{
+			{"operation": "rebase", "head-name": "refs/heads/topic", "onto": "cccc", "done": []any{"pick 1111 first"}, "todo": []any{"pick 2222 second"}},
+		}},
+		{"am", map[string]string{
+			"rebase-apply/applying": "",
+			"rebase-apply/next":     "2",
+			"rebase-apply/last":     "3",
+		}, []map[string]any{
+			{"operation": "am", "next": "2", "last": "3"},
+		}},
+		{"cherry-pick with sequencer", map[string]string{
+			"CHERRY_PICK_HEAD": "dddd",
+			"sequencer/todo":   "pick eeee next\n",
+		}, []map[string]any{
+			{"operation": "cherry-pick", "commit": "dddd", "todo": []any{"pick eeee next"}},
+		}},
+		{"sequencer alone", map[string]string{
+			"sequencer/head": "ffff",
+			"sequencer/todo": "revert 0000 last\n",
+		}, []map[string]any{
+			{"operation": "sequencer", "head": "ffff", "todo": []any{"revert 0000 last"}},
+		}},
+		{"bisect and revert", map[string]string{
+			"REVERT_HEAD":  "9999",
+			"BISECT_START": "master",
+			"BISECT_LOG":   "# bad: [1234] broken\ngit bisect bad 1234\n",
+		}, []map[string]any{
+			{"operation": "revert", "commit": "9999"},
+			{"operation": "bisect", "start": "master", "log": []any{"git bisect bad 1234"}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for file, content := range tt.files {
+				path := filepath.Join(dir, ".git", file)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var buf bytes.Buffer
+			logger := log.DefaultLogger
+			log.DefaultLogger = log.Logger{Level: log.InfoLevel, Writer: &log.IOWriter{Writer: &buf}}
+			reportState("http://example.com", dir, mainRepository)
+			log.DefaultLogger = logger
+
+			var got []map[string]any
+			for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+				if len(line) == 0 {
+					continue
+				}
+				var entry map[string]any
+				if err := json.Unmarshal(line, &entry); err != nil {
+					t.Fatalf("malformed log line %q: %v", line, err)
+				}
+				got = append(got, entry)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("logged %v, want %v", got, tt.want)
+			}
+			for i, want := range tt.want {
+				for key, value := range want {
+					g, _ := json.Marshal(got[i][key])
+					w, _ := json.Marshal(value)
+					if !bytes.Equal(g, w) {
+						t.Errorf("entry %d %s = %s, want %s", i, key, g, w)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package goop
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/phuslu/log"
+	"gopkg.in/ini.v1"
+)
+
+// submodules nested deeper than this aren't dumped, so a server can't keep us
+// descending into .git/modules/ forever
+const maxSubmoduleDepth = 5
+
+type submodule struct {
+	name string
+	path string
+}
+
+// fetchSubmodules dumps the submodules of repo, as listed in its current and
+// historical .gitmodules files, and checks them out in place.
+func fetchSubmodules(baseURL, baseDir string, repo repository, format utils.ObjectFormat, refNames []string, unsafeGit bool, lfsHosts []string) {
+	gitDir := utils.URL(baseDir, repo.gitDir)
+
+	var gitmodules [][]byte
+	if content, err := os.ReadFile(utils.URL(baseDir, repo.path(".gitmodules"))); err == nil {
+		gitmodules = append(gitmodules, content)
+	}
+
+	store, err := utils.OpenObjectStore(gitDir, format)
+	if err != nil {
+		log.Warn().Str("dir", gitDir).Err(err).Msg("couldn't open all pack files")
+	}
+	defer store.Close()
+
+	blobs, gitlinks := findSubmoduleObjects(gitDir, store, format)
+	for blob := range blobs {
+		_, content, err := store.Object(blob)
+		if err != nil {
+			log.Warn().Str("dir", gitDir).Str("obj", blob).Err(err).Msg("couldn't read .gitmodules blob")
+			continue
+		}
+		gitmodules = append(gitmodules, content)
+	}
+
+	submodules := make(map[string]submodule)
+	for _, content := range gitmodules {
+		for _, sm := range parseGitmodules(content) {
+			if _, ok := submodules[sm.name]; !ok {
+				submodules[sm.name] = sm
+			}
+		}
+	}
+	if len(submodules) == 0 {
+		return
+	}
+	if repo.depth >= maxSubmoduleDepth {
+		log.Warn().Str("dir", gitDir).Int("depth", repo.depth).Msg("submodules are nested too deeply, not dumping them")
+		return
+	}
+
+	names := make([]string, 0, len(submodules))
+	for name := range submodules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		sm := submodules[name]
+		if !isSafeSubmodulePath(sm.name) || !isSafeSubmodulePath(sm.path) {
+			log.Warn().Str("dir", gitDir).Str("submodule", sm.name).Str("path", sm.path).Msg("refusing to dump submodule with unsafe name or path")
+			continue
+		}
+		sub := repository{
+			gitDir:   utils.URL(repo.gitDir, "modules/"+sm.name),
+			workTree: path.Join(repo.workTree, sm.path),
+			depth:    repo.depth + 1,
+		}
+		log.Info().Str("base", baseURL).Str("submodule", sm.name).Str("path", sub.workTree).Msg("fetching submodule")
+
+		if err := writeGitFile(baseDir, sub); err != nil {
+			log.Error().Str("dir", baseDir).Str("submodule", sm.name).Err(err).Msg("couldn't link submodule work tree")
+			continue
+		}
+
+		var seeds []string
+		for commit := range gitlinks[sm.path] {
+			seeds = append(seeds, commit)
+		}
+		if err := fetchGit(baseURL, baseDir, sub, seeds, refNames, unsafeGit, lfsHosts); err != nil {
+			log.Error().Str("base", baseURL).Str("submodule", sm.name).Err(err).Msg("failed to fetch submodule")
+			continue
+		}
+		reportGitlinks(baseDir, sub, sm, gitlinks[sm.path])
+	}
+}
+
+// findSubmoduleObjects walks the trees of every commit in store, returning the
+// .gitmodules blobs at their roots and the submodule commits they point to,
+// keyed by submodule path.
+func findSubmoduleObjects(gitDir string, store *utils.ObjectStore, format utils.ObjectFormat) (map[string]bool, map[string]map[string]bool) {
+	blobs := make(map[string]bool)
+	gitlinks := make(map[string]map[string]bool)
+
+	if idx, err := utils.ReadIndex(utils.URL(gitDir, "index"), format); err == nil {
+		for _, entry := range idx.Entries {
+			if entry.Mode == utils.GitlinkMode {
+				addGitlink(gitlinks, entry.Name, entry.Hash)
+			}
+		}
+	}
+
+	hashes, err := store.Hashes()
+	if err != nil {
+		log.Error().Str("dir", gitDir).Err(err).Msg("couldn't list objects")
+		return blobs, gitlinks
+	}
+
+	seen := make(map[string]bool)
+	var walkTree func(hash, prefix string)
+	walkTree = func(hash, prefix string) {
+		key := prefix + "\x00" + hash
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		typ, content, err := store.Object(hash)
+		if err != nil || typ != "tree" {
+			return
+		}
+		entries, _ := utils.ParseTree(content, format)
+		for _, e := range entries {
+			switch {
+			case e.Mode == utils.GitlinkMode:
+				addGitlink(gitlinks, prefix+e.Name, e.Hash)
+			case e.Mode == 040000:
+				walkTree(e.Hash, prefix+e.Name+"/")
+			case prefix == "" && e.Name == ".gitmodules":
+				blobs[e.Hash] = true
+			}
+		}
+	}
+
+	for _, hash := range hashes {
+		typ, err := store.ObjectType(hash)
+		if err != nil || typ != "commit" {
+			continue
+		}
+		_, content, err := store.Object(hash)
+		if err != nil {
+			continue
+		}
+		if tree, _ := utils.ParseCommit(content, format); tree != "" {
+			walkTree(tree, "")
+		}
+	}
+	return blobs, gitlinks
+}
+
+func addGitlink(gitlinks map[string]map[string]bool, path, commit string) {
+	if gitlinks[path] == nil {
+		gitlinks[path] = make(map[string]bool)
+	}
+	gitlinks[path][commit] = true
+}
+
+func parseGitmodules(content []byte) []submodule {
+	cfg, err := ini.LoadSources(ini.LoadOptions{InsensitiveKeys: true, AllowShadows: true}, content)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to parse .gitmodules")
+		return nil
+	}
+	var submodules []submodule
+	for _, sec := range cfg.Sections() {
+		if !strings.HasPrefix(sec.Name(), "submodule ") {
+			continue
+		}
+		parts := strings.SplitN(sec.Name(), " ", 2)
+		name := strings.Trim(parts[1], `"`)
+		smPath := sec.Key("path").String()
+		if name == "" || smPath == "" {
+			continue
+		}
+		submodules = append(submodules, submodule{name: name, path: strings.TrimSuffix(smPath, "/")})
+	}
+	return submodules
+}
+
+// isSafeSubmodulePath rejects names and paths that would take us outside of
+// the dump, the same way git refuses them.
+func isSafeSubmodulePath(p string) bool {
+	if p == "" || path.IsAbs(p) || strings.ContainsRune(p, '\\') {
+		return false
+	}
+	for _, part := range strings.Split(p, "/") {
+		if part == ".." || strings.EqualFold(part, ".git") {
+			return false
+		}
+	}
+	return true
+}
+
+// writeGitFile links the work tree of a submodule to its git directory.
+func writeGitFile(baseDir string, sub repository) error {
+	if err := utils.MkdirConfined(baseDir, sub.workTree, false); err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(utils.URL(baseDir, sub.workTree), utils.URL(baseDir, sub.gitDir))
+	if err != nil {
+		return err
+	}
+	return utils.WriteConfinedFile(baseDir, sub.workTree+"/.git", []byte(fmt.Sprintf("gitdir: %s\n", filepath.ToSlash(rel))), 0644, true)
+}
+
+// reportGitlinks logs which of the commits the superproject recorded for a
+// submodule could be found in the dumped submodule.
+func reportGitlinks(baseDir string, sub repository, sm submodule, commits map[string]bool) {
+	gitDir := utils.URL(baseDir, sub.gitDir)
+	format := readObjectFormat(gitDir)
+	store, err := utils.OpenObjectStore(gitDir, format)
+	if err != nil {
+		log.Warn().Str("dir", gitDir).Err(err).Msg("couldn't open all pack files")
+	}
+	defer store.Close()
+
+	var resolved int
+	for commit := range commits {
+		if store.Has(commit) {
+			resolved++
+		} else {
+			log.Warn().Str("submodule", sm.name).Str("path", sub.workTree).Str("commit", commit).Msg("submodule commit is missing")
+		}
+	}
+	log.Info().Str("submodule", sm.name).Str("path", sub.workTree).Int("resolved", resolved).Int("total", len(commits)).Msg("resolved submodule commits")
+}
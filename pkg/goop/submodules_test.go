@@ -0,0 +1,33 @@
+package goop
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteGitFile(t *testing.T) {
+	dir := t.TempDir()
+	sub := repository{gitDir: ".git/modules/lib", workTree: "vendor/lib"}
+	if err := writeGitFile(dir, sub); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "vendor/lib/.git"))
+	if err != nil || string(content) != "gitdir: ../../.git/modules/lib\n" {
+		t.Fatalf(".git = %q, %v", content, err)
+	}
+
+	// a symlink planted by an earlier step doesn't redirect the write
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(dir, "linked")); err != nil {
+		t.Fatal(err)
+	}
+	for _, workTree := range []string{"linked", "linked/lib"} {
+		if err := writeGitFile(dir, repository{gitDir: ".git/modules/x", workTree: workTree}); err == nil {
+			t.Errorf("writeGitFile(%s) succeeded", workTree)
+		}
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Errorf("wrote %v through the symlink", entries)
+	}
+}
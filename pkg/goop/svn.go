@@ -0,0 +1,237 @@
+package goop
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/deletescape/goop/internal/workers"
+	"github.com/deletescape/jobtracker"
+	"github.com/phuslu/log"
+)
+
+// FetchSvn dumps the Subversion working copy exposed at baseURL, rebuilding
+// its working tree from the pristine copies of its files.
+func FetchSvn(baseURL, baseDir string) error {
+	log.Info().Str("base", baseURL).Msg("testing if recursive download is possible")
+	code, body, err := workers.Get(c, utils.URL(baseURL, ".svn/"))
+	if err != nil && !utils.IgnoreError(err) {
+		return err
+	}
+	if code == 200 && utils.IsHTML(body) && !workers.IsNotFound(utils.URL(baseURL, ".svn/"), body) {
+		lnk, _ := url.Parse(utils.URL(baseURL, ".svn/"))
+		indexedFiles, err := utils.GetIndexedFiles(body, lnk.Path)
+		if err != nil {
+			return err
+		}
+		if utils.StringsContain(indexedFiles, "wc.db") || utils.StringsContain(indexedFiles, "entries") {
+			log.Info().Str("base", baseURL).Msg("fetching .svn/ recursively")
+			jt := jobtracker.NewJobTracker(workers.RecursiveDownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+			jt.AddJobs(indexedFiles...)
+			jt.StartAndWait(workers.RecursiveDownloadContext{C: c, BaseURL: utils.URL(baseURL, ".svn/"), BaseDir: utils.URL(baseDir, ".svn/")}, true)
+		}
+	}
+
+	log.Info().Str("base", baseURL).Msg("fetching subversion metadata")
+	jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+	jt.AddJobs(svnFiles...)
+	jt.StartAndWait(workers.DownloadContext{C: c, BaseURL: baseURL, BaseDir: baseDir}, false)
+
+	// since 1.7 there is a single wc.db at the root, and entries is a stub
+	if wcDB := utils.URL(baseDir, ".svn/wc.db"); utils.Exists(wcDB) {
+		return fetchSvnWcDB(baseURL, baseDir, wcDB)
+	}
+	if utils.Exists(utils.URL(baseDir, ".svn/entries")) {
+		return fetchSvnEntries(baseURL, baseDir)
+	}
+	return fmt.Errorf("no subversion metadata found at %s", baseURL)
+}
+
+// fetchSvnWcDB restores a 1.7+ working copy, where pristine copies are stored
+// as .svn/pristine/<xx>/<sha1>.svn-base.
+func fetchSvnWcDB(baseURL, baseDir, wcDB string) error {
+	wc, err := utils.ReadWcDB(wcDB)
+	if err != nil {
+		return fmt.Errorf("couldn't read wc.db: %w", err)
+	}
+	var revision int64
+	for _, node := range wc.Nodes {
+		if node.Revision > revision {
+			revision = node.Revision
+		}
+	}
+	for _, repo := range wc.Repositories {
+		log.Info().Str("base", baseURL).Str("root", repo.Root).Str("uuid", repo.UUID).Int("format", wc.Format).Int64("revision", revision).Int("nodes", len(wc.Nodes)).Msg("subversion working copy")
+	}
+
+	pristinePath := func(checksum string) string {
+		return fmt.Sprintf(".svn/pristine/%s/%s.svn-base", checksum[:2], checksum)
+	}
+	log.Info().Str("base", baseURL).Int("count", len(wc.Pristines)).Msg("fetching pristine copies")
+	jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+	queued := make(map[string]bool)
+	for _, checksum := range wc.Pristines {
+		queued[checksum] = true
+	}
+	for _, node := range wc.Nodes {
+		if node.Checksum != "" {
+			queued[node.Checksum] = true
+		}
+	}
+	for checksum := range queued {
+		if utils.SHA1.IsHash(checksum) {
+			jt.AddJob(pristinePath(checksum))
+		}
+	}
+	jt.StartAndWait(workers.DownloadContext{C: c, BaseURL: baseURL, BaseDir: baseDir, AllowHTML: true, AlllowEmpty: true}, false)
+
+	var files []svnFile
+	for _, node := range wc.Nodes {
+		if !utils.IsSafeWorkTreePath(node.Path, ".svn") {
+			continue
+		}
+		switch node.Kind {
+		case "dir":
+			if err := utils.MkdirConfined(baseDir, node.Path, false); err != nil {
+				log.Error().Str("dir", baseDir).Str("path", node.Path).Err(err).Msg("couldn't create directory")
+			}
+		case "file", "symlink":
+			f := svnFile{path: node.Path, checksum: node.Checksum}
+			if utils.SHA1.IsHash(node.Checksum) {
+				f.pristine = pristinePath(node.Checksum)
+			}
+			files = append(files, f)
+		}
+	}
+	restoreSvnFiles(baseURL, baseDir, files, func(content []byte) string {
+		sum := sha1.Sum(content)
+		return hex.EncodeToString(sum[:])
+	})
+	return nil
+}
+
+// fetchSvnEntries restores a pre-1.7 working copy, which has a .svn directory
+// with an entries file in every directory, and keeps pristine copies as
+// .svn/text-base/<name>.svn-base.
+func fetchSvnEntries(baseURL, baseDir string) error {
+	var files []svnFile
+	level := []string{""}
+	for depth := 0; len(level) > 0 && depth < maxDirDepth; depth++ {
+		jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+		for _, dir := range level {
+			if entries := path.Join(dir, ".svn/entries"); !utils.Exists(utils.URL(baseDir, entries)) {
+				jt.AddJob(entries)
+			}
+		}
+		jt.StartAndWait(workers.DownloadContext{C: c, BaseURL: baseURL, BaseDir: baseDir}, false)
+
+		var next []string
+		for _, dir := range level {
+			entriesPath := utils.URL(baseDir, path.Join(dir, ".svn/entries"))
+			content, err := os.ReadFile(entriesPath)
+			if err != nil {
+				continue
+			}
+			entries, err := utils.ParseSvnEntries(content)
+			if err != nil {
+				log.Error().Str("file", entriesPath).Err(err).Msg("couldn't parse entries file")
+				continue
+			}
+			for _, e := range entries {
+				if e.Name == "" {
+					if dir == "" {
+						log.Info().Str("base", baseURL).Str("root", e.Root).Str("url", e.URL).Str("uuid", e.UUID).Str("revision", e.Revision).Msg("subversion working copy")
+					}
+					continue
+				}
+				if e.Deleted || e.Absent || e.Schedule == "delete" || strings.Contains(e.Name, "/") {
+					continue
+				}
+				p := path.Join(dir, e.Name)
+				if !utils.IsSafeWorkTreePath(p, ".svn") {
+					continue
+				}
+				switch e.Kind {
+				case "dir":
+					if err := utils.MkdirConfined(baseDir, p, false); err != nil {
+						log.Error().Str("dir", baseDir).Str("path", p).Err(err).Msg("couldn't create directory")
+					}
+					next = append(next, p)
+				case "file":
+					f := svnFile{path: p, checksum: e.Checksum}
+					// files scheduled for addition don't have a text base yet
+					if e.Schedule != "add" {
+						f.pristine = path.Join(dir, ".svn/text-base", e.Name+".svn-base")
+					}
+					files = append(files, f)
+				}
+			}
+		}
+		level = next
+	}
+
+	log.Info().Str("base", baseURL).Int("count", len(files)).Msg("fetching text bases")
+	jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+	for _, f := range files {
+		if f.pristine != "" {
+			jt.AddJob(f.pristine)
+		}
+	}
+	jt.StartAndWait(workers.DownloadContext{C: c, BaseURL: baseURL, BaseDir: baseDir, AllowHTML: true, AlllowEmpty: true}, false)
+
+	restoreSvnFiles(baseURL, baseDir, files, func(content []byte) string {
+		sum := md5.Sum(content)
+		return hex.EncodeToString(sum[:])
+	})
+	return nil
+}
+
+type svnFile struct {
+	path     string
+	pristine string
+	checksum string
+}
+
+// restoreSvnFiles copies the pristine copy of every file into the working
+// tree, and fetches the live file for those without a valid one.
+func restoreSvnFiles(baseURL, baseDir string, files []svnFile, sum func([]byte) string) {
+	var missing []string
+	for _, f := range files {
+		target := utils.URL(baseDir, f.path)
+		if utils.Exists(target) {
+			continue
+		}
+		if f.pristine == "" {
+			missing = append(missing, f.path)
+			continue
+		}
+		content, err := os.ReadFile(utils.URL(baseDir, f.pristine))
+		if err != nil {
+			missing = append(missing, f.path)
+			continue
+		}
+		if f.checksum != "" && sum(content) != f.checksum {
+			log.Warn().Str("file", f.pristine).Str("expected", f.checksum).Msg("pristine copy doesn't match its checksum")
+			missing = append(missing, f.path)
+			continue
+		}
+		if err := utils.WriteConfinedFile(baseDir, f.path, content, os.ModePerm, false); err != nil {
+			log.Error().Str("file", target).Err(err).Msg("couldn't write file")
+		}
+	}
+
+	log.Info().Str("base", baseURL).Str("dir", baseDir).Int("count", len(missing)).Msg("attempting to fetch files without a pristine copy")
+	jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+	for _, f := range missing {
+		if !strings.HasSuffix(f, ".php") {
+			jt.AddJob(f)
+		}
+	}
+	jt.StartAndWait(workers.DownloadContext{C: c, BaseURL: baseURL, BaseDir: baseDir, AllowHTML: true, AlllowEmpty: true, WorkTree: true}, false)
+}
@@ -0,0 +1,85 @@
+package goop
+
+import (
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/phuslu/log"
+)
+
+// synthesizeTrees rebuilds missing tree objects from the entries of the index.
+// A rebuilt tree is only written if its hash is one we know to be missing,
+// either from the cache tree of the index, or from a commit or tree
+// referencing it.
+func synthesizeTrees(baseDir string, repo repository, format utils.ObjectFormat) {
+	gitDir := utils.URL(baseDir, repo.gitDir)
+	indexPath := utils.URL(gitDir, "index")
+	if !utils.Exists(indexPath) {
+		return
+	}
+	idx, err := utils.ReadIndex(indexPath, format)
+	if err != nil {
+		log.Error().Str("dir", baseDir).Err(err).Msg("couldn't decode git index")
+		return
+	}
+	store, err := utils.OpenObjectStore(gitDir, format)
+	if err != nil {
+		log.Warn().Str("dir", gitDir).Err(err).Msg("couldn't open all pack files")
+	}
+	defer store.Close()
+
+	expected := make(map[string]bool)
+	cacheTree, _ := idx.CacheTree(format)
+	for _, tree := range cacheTree {
+		expected[tree] = true
+	}
+	hashes, err := store.Hashes()
+	if err != nil {
+		log.Error().Str("dir", gitDir).Err(err).Msg("couldn't list objects")
+		return
+	}
+	for _, hash := range hashes {
+		typ, err := store.ObjectType(hash)
+		if err != nil || (typ != "commit" && typ != "tree") {
+			continue
+		}
+		_, content, err := store.Object(hash)
+		if err != nil {
+			continue
+		}
+		if typ == "commit" {
+			if tree, _ := utils.ParseCommit(content, format); tree != "" {
+				expected[tree] = true
+			}
+			continue
+		}
+		entries, _ := utils.ParseTree(content, format)
+		for _, e := range entries {
+			if e.Mode == 040000 {
+				expected[e.Hash] = true
+			}
+		}
+	}
+	for hash := range expected {
+		if store.Has(hash) {
+			delete(expected, hash)
+		}
+	}
+	if len(expected) == 0 {
+		return
+	}
+
+	trees, err := idx.Trees(format)
+	if err != nil {
+		log.Error().Str("dir", baseDir).Err(err).Msg("couldn't build trees from index")
+		return
+	}
+	for dir, tree := range trees {
+		if !expected[tree.Hash] {
+			continue
+		}
+		if _, err := utils.WriteLooseObject(gitDir, "tree", tree.Content, format); err != nil {
+			log.Error().Str("dir", baseDir).Str("tree", dir).Str("obj", tree.Hash).Err(err).Msg("failed to write tree object")
+			continue
+		}
+		log.Info().Str("dir", baseDir).Str("tree", "/"+dir).Str("obj", tree.Hash).Msg("rebuilt missing tree object from index")
+	}
+}
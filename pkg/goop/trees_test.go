@@ -0,0 +1,84 @@
+package goop
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+)
+
+func TestSynthesizeTrees(t *testing.T) {
+	dir := t.TempDir()
+	gitDir := filepath.Join(dir, ".git")
+	if err := os.MkdirAll(gitDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// a sparse index, keeping docs as a single entry
+	entries := []*index.Entry{
+		{Name: "README", Mode: filemode.Regular, Hash: plumbing.NewHash("ce013625030ba8dba906f756967f9e9ca394464a")},
+		{Name: "bin/run.sh", Mode: filemode.Executable, Hash: plumbing.NewHash("1a2485251c33a70432394c93fb89330ef214bfc9")},
+		{Name: "docs/", Mode: filemode.Dir, Hash: plumbing.NewHash("632fae84d591a2bbb7777041861abc5685bdaa0e")},
+		{Name: "link", Mode: filemode.Symlink, Hash: plumbing.NewHash("100b93820ade4c16225673b4ca62bb3ade63c313")},
+		{Name: "src-file", Mode: filemode.Regular, Hash: plumbing.NewHash("587be6b4c3f93f93c489c0111bba5596147a26cb")},
+		{Name: "src/lib/util.go", Mode: filemode.Regular, Hash: plumbing.NewHash("55c21f80aa6524ff206213a9453abd5e759c8f48")},
+		{Name: "src/main.go", Mode: filemode.Regular, Hash: plumbing.NewHash("06ab7d0f9a35a7d1070711496d6ca1cb892a258f")},
+	}
+	f, err := os.Create(filepath.Join(gitDir, "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := index.NewEncoder(f).Encode(&index.Index{Version: 2, Entries: entries}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// the commit is all we have, its root tree is missing
+	root := "85fdb5f31571367e18a4b29fd10f2a7a6fb47d56"
+	commit := "tree " + root + "\nauthor a <a@b> 0 +0000\ncommitter a <a@b> 0 +0000\n\ninit\n"
+	if _, err := utils.WriteLooseObject(gitDir, "commit", []byte(commit), utils.SHA1); err != nil {
+		t.Fatal(err)
+	}
+
+	synthesizeTrees(dir, mainRepository, utils.SHA1)
+
+	store, err := utils.OpenObjectStore(gitDir, utils.SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if !store.Has(root) {
+		t.Fatalf("root tree %s wasn't rebuilt", root)
+	}
+	_, content, err := store.Object(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := utils.ParseTree(content, utils.SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"README":   "ce013625030ba8dba906f756967f9e9ca394464a",
+		"bin":      "4d30b2ddd4dbd82d6ad7ee4d2a4ea360f5d65b61",
+		"docs":     "632fae84d591a2bbb7777041861abc5685bdaa0e",
+		"link":     "100b93820ade4c16225673b4ca62bb3ade63c313",
+		"src-file": "587be6b4c3f93f93c489c0111bba5596147a26cb",
+		"src":      "fb1533f8cb3d7bdd1c4fe9b22cc489455e894311",
+	}
+	if len(tree) != len(want) {
+		t.Errorf("root tree has %d entries, want %d", len(tree), len(want))
+	}
+	for _, e := range tree {
+		if want[e.Name] != e.Hash {
+			t.Errorf("root tree entry %s = %s, want %s", e.Name, e.Hash, want[e.Name])
+		}
+	}
+	// only trees known to be missing are written
+	if store.Has("fb1533f8cb3d7bdd1c4fe9b22cc489455e894311") {
+		t.Error("src tree was written without being known to be missing")
+	}
+}
@@ -0,0 +1,179 @@
+package goop
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/deletescape/goop/internal/workers"
+	"github.com/deletescape/jobtracker"
+	"github.com/phuslu/log"
+)
+
+// findWorktrees looks for linked worktrees (created by git worktree add) in
+// the git directory of repo and downloads their HEADs, indexes and logs.
+// Candidate names come from a directory listing of .git/worktrees, the branch
+// names found so far, the target's own .git file if it is a linked worktree
+// itself, and a wordlist. The names of the worktrees found are returned.
+func findWorktrees(baseURL, baseDir string, repo repository) []string {
+	gitDir := utils.URL(baseDir, repo.gitDir)
+	candidates := make(map[string]bool)
+	for _, name := range commonWorktrees {
+		candidates[name] = true
+	}
+
+	listingURL := utils.URL(baseURL, repo.path(".git/worktrees/"))
+	if code, body, err := workers.Get(c, listingURL); err == nil && code == 200 && utils.IsHTML(body) && !workers.IsNotFound(listingURL, body) {
+		lnk, _ := url.Parse(listingURL)
+		names, err := utils.GetIndexedFiles(body, lnk.Path)
+		if err != nil {
+			log.Error().Str("uri", listingURL).Err(err).Msg("couldn't parse directory listing")
+		}
+		for _, name := range names {
+			candidates[strings.TrimSuffix(name, "/")] = true
+		}
+	}
+
+	// a worktree added with -b usually shares its name with its branch
+	for _, branch := range localBranches(gitDir) {
+		candidates[branch[strings.LastIndex(branch, "/")+1:]] = true
+	}
+
+	// a linked worktree's .git is a file pointing back to its git directory
+	if code, body, err := workers.Get(c, utils.URL(baseURL, repo.path(".git"))); err == nil && code == 200 && bytes.HasPrefix(body, []byte("gitdir:")) {
+		pointer := strings.TrimSpace(strings.TrimPrefix(string(body), "gitdir:"))
+		log.Info().Str("base", baseURL).Str("gitdir", pointer).Msg("target is a linked worktree")
+		if dir, name := filepath.Split(filepath.ToSlash(pointer)); strings.HasSuffix(dir, "/worktrees/") {
+			candidates[name] = true
+		}
+	}
+
+	jt := jobtracker.NewJobTracker(workers.FindRefWorker, maxConcurrency, jobtracker.DefaultNapper)
+	for name := range candidates {
+		if isSafeWorktreeName(name) {
+			jt.AddJob(repo.path(fmt.Sprintf(".git/worktrees/%s/HEAD", name)))
+		}
+	}
+	jt.StartAndWait(workers.FindRefContext{C: c, BaseURL: baseURL, BaseDir: baseDir, GitDir: repo.gitDir}, true)
+
+	var worktrees []string
+	jt = jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+	for name := range candidates {
+		if !isSafeWorktreeName(name) || !utils.Exists(utils.URL(gitDir, fmt.Sprintf("worktrees/%s/HEAD", name))) {
+			continue
+		}
+		worktrees = append(worktrees, name)
+		for _, file := range worktreeFiles {
+			jt.AddJob(repo.path(fmt.Sprintf(".git/worktrees/%s/%s", name, file)))
+		}
+	}
+	jt.StartAndWait(workers.DownloadContext{C: c, BaseURL: baseURL, BaseDir: baseDir}, false)
+
+	for _, name := range worktrees {
+		pointer, _ := os.ReadFile(utils.URL(gitDir, fmt.Sprintf("worktrees/%s/gitdir", name)))
+		log.Info().Str("base", baseURL).Str("worktree", name).Str("gitdir", strings.TrimSpace(string(pointer))).Msg("found linked worktree")
+	}
+	return worktrees
+}
+
+// localBranches lists the branches in the refs directory and packed-refs
+// file of gitDir.
+func localBranches(gitDir string) []string {
+	var branches []string
+	headsDir := utils.URL(gitDir, "refs/heads")
+	filepath.Walk(headsDir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(headsDir, path)
+			branches = append(branches, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if f, err := os.Open(utils.URL(gitDir, "packed-refs")); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 2 && strings.HasPrefix(fields[1], "refs/heads/") {
+				branches = append(branches, strings.TrimPrefix(fields[1], "refs/heads/"))
+			}
+		}
+	}
+	return branches
+}
+
+func isSafeWorktreeName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// checkoutWorktrees checks out every linked worktree of the dump into
+// <baseDir>/.goop/worktrees/<name>.
+func checkoutWorktrees(baseDir string) {
+	gitDir, err := filepath.Abs(utils.URL(baseDir, ".git"))
+	if err != nil {
+		log.Error().Str("dir", baseDir).Err(err).Msg("couldn't resolve git directory")
+		return
+	}
+	format := readObjectFormat(gitDir)
+	adminDirs, err := filepath.Glob(utils.URL(gitDir, "worktrees/*/HEAD"))
+	if err != nil {
+		log.Error().Str("dir", baseDir).Err(err).Msg("couldn't list worktrees")
+		return
+	}
+	for _, head := range adminDirs {
+		adminDir := filepath.Dir(head)
+		name := filepath.Base(adminDir)
+		workTree := ".goop/worktrees/" + name
+		workDir, err := filepath.Abs(utils.URL(baseDir, workTree))
+		if err != nil {
+			log.Error().Str("worktree", name).Err(err).Msg("couldn't resolve worktree directory")
+			continue
+		}
+		log.Info().Str("worktree", name).Str("dir", workDir).Msg("checking out linked worktree")
+		if err := utils.MkdirConfined(baseDir, workTree, false); err != nil {
+			log.Error().Str("worktree", name).Str("dir", workDir).Err(err).Msg("couldn't create worktree directory")
+			continue
+		}
+
+		// point the worktree and its git directory at each other, the dumped
+		// gitdir file is the path the worktree had on the server
+		if err := utils.WriteConfinedFile(baseDir, workTree+"/.git", []byte("gitdir: "+adminDir+"\n"), 0644, true); err != nil {
+			log.Error().Str("worktree", name).Str("dir", workDir).Err(err).Msg("couldn't link worktree")
+			continue
+		}
+		if err := utils.WriteConfinedFile(gitDir, "worktrees/"+name+"/gitdir", []byte(utils.URL(workDir, ".git")+"\n"), 0644, false); err != nil {
+			log.Error().Str("worktree", name).Str("dir", adminDir).Err(err).Msg("couldn't link worktree")
+			continue
+		}
+		// the dumped commondir could point git at any directory as the object
+		// store, so it's only kept as evidence
+		commondir := "worktrees/" + name + "/commondir"
+		if content, err := os.ReadFile(utils.URL(gitDir, commondir)); err == nil && strings.TrimSpace(string(content)) != "../.." {
+			log.Warn().Str("worktree", name).Str("commondir", strings.TrimSpace(string(content))).Msg("dumped worktree points at another common directory, ignoring it")
+			if evidence := commondir + ".untrusted"; !utils.Exists(utils.URL(gitDir, evidence)) {
+				if err := utils.WriteConfinedFile(gitDir, evidence, content, 0644, false); err != nil {
+					log.Error().Str("file", utils.URL(gitDir, evidence)).Err(err).Msg("couldn't keep original commondir")
+				}
+			}
+		}
+		if err := utils.WriteConfinedFile(gitDir, commondir, []byte("../..\n"), 0644, false); err != nil {
+			log.Error().Str("worktree", name).Str("dir", adminDir).Err(err).Msg("couldn't link worktree")
+			continue
+		}
+
+		repo := repository{gitDir: ".git/worktrees/" + name, workTree: workTree, commonDir: ".git"}
+		if !utils.Exists(utils.URL(adminDir, "index")) {
+			if err := rebuildIndex(baseDir, repo, format); err != nil {
+				log.Error().Str("worktree", name).Str("dir", adminDir).Err(err).Msg("failed to create index from HEAD")
+				continue
+			}
+		}
+		if err := checkout(baseDir, repo, format); err != nil {
+			log.Error().Str("worktree", name).Str("dir", workDir).Err(err).Msg("failed to checkout")
+		}
+	}
+}